@@ -0,0 +1,102 @@
+// Command replay pushes dead-lettered tracking events batches back through the configured sink,
+// batches which failed in a fan-out sink are pushed to that sink only.
+// It reads the same environment variables as the server. The "file" sink directory is locked by
+// the server, so stop it or point TRACKING_FILE_DIR to another directory before the replay.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"sweng-task/internal/config"
+	"sweng-task/internal/model"
+	"sweng-task/internal/service"
+	"sweng-task/internal/storage"
+
+	"go.uber.org/zap"
+)
+
+func main() {
+	dryRun := flag.Bool("dry-run", false, "list dead-lettered batches without replaying them")
+	writeTimeout := flag.Duration("write-timeout", 10*time.Second, "timeout of writing a batch to the sink")
+	flag.Parse()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	logger, err := zap.NewProduction()
+	if err != nil {
+		fmt.Printf("Error initializing logger: %v\n", err)
+		os.Exit(1)
+	}
+	defer logger.Sync()
+	log := logger.Sugar()
+
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	deadLetters, err := storage.NewFileDeadLetterStore(storage.DeadLetterDir(cfg), service.SystemClock{})
+	if err != nil {
+		log.Fatalf("Failed to open tracking dead-letter store: %v", err)
+	}
+	batches, err := deadLetters.Batches()
+	if err != nil {
+		log.Fatalf("Failed to list dead-lettered batches: %v", err)
+	}
+	log.Infow("Dead-lettered batches found",
+		"dir", storage.DeadLetterDir(cfg),
+		"batches", len(batches),
+	)
+	if *dryRun || len(batches) == 0 {
+		for _, name := range batches {
+			fmt.Println(name)
+		}
+		return
+	}
+
+	sink, closeSink, err := storage.OpenTrackingEventsStorage(ctx, cfg, log)
+	if err != nil {
+		log.Fatalf("Failed to open tracking events storage: %v", err)
+	}
+
+	var events int
+	replayed, err := deadLetters.Replay(ctx, func(ctx context.Context, sinkName string, batch []model.TrackingEvent) error {
+		// a batch failed in a fan-out sink is replayed to that sink only, the others have accepted it
+		target := sink
+		if sinkName != "" {
+			fanOut, ok := sink.(*service.FanOutStorage)
+			if !ok {
+				return fmt.Errorf("batch failed in sink %q, but TRACKING_SINKS is not configured", sinkName)
+			}
+			if target, ok = fanOut.Sink(sinkName); !ok {
+				return fmt.Errorf("sink %q is not configured", sinkName)
+			}
+		}
+
+		ctx, stop := context.WithTimeout(ctx, *writeTimeout)
+		defer stop()
+		if err := target.Write(ctx, batch); err != nil {
+			return err
+		}
+		events += len(batch)
+		return nil
+	})
+	if closeErr := closeSink(); closeErr != nil {
+		log.Errorf("Failed to close tracking events storage: %v", closeErr)
+	}
+	log.Infow("Dead-lettered batches replayed",
+		"batches", replayed,
+		"events", events,
+		"remaining", len(batches)-replayed,
+	)
+	if err != nil {
+		log.Fatalf("Failed to replay dead-lettered batches: %v", err)
+	}
+}
@@ -2,21 +2,29 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
+	"database/sql"
 	"fmt"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"syscall"
 	"time"
 
 	"sweng-task/internal/config"
 	"sweng-task/internal/handler"
-	"sweng-task/internal/model"
 	"sweng-task/internal/service"
+	"sweng-task/internal/signing"
+	"sweng-task/internal/storage"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/adaptor"
 	"github.com/gofiber/fiber/v2/middleware/cors"
 	fiberlogger "github.com/gofiber/fiber/v2/middleware/logger"
 	"github.com/gofiber/fiber/v2/middleware/recover"
+	"github.com/gofiber/fiber/v2/middleware/requestid"
+	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"go.uber.org/zap"
 )
 
@@ -46,26 +54,207 @@ func main() {
 		"server_port", cfg.Server.Port,
 	)
 
-	// Initialize services
-	lineItemService := service.NewLineItemService(log)
-	adService := service.NewAdService(lineItemService, log)
-	trackingEventsBuffer := 1000 // TODO: configurable from an ENV variable
+	// Initialize storages
+	var (
+		lineItemRepository service.LineItemRepository
+		lineItemHistory    service.LineItemHistory
+		spendStore         service.SpendStore
+	)
+	switch cfg.Storage.LineItems {
+	case "memory":
+		lineItemRepository = service.NewMemoryLineItemRepository()
+		lineItemHistory = service.NewMemoryLineItemHistory()
+		spendStore = service.NewMemorySpendStore()
+	case "file":
+		if err := os.MkdirAll(cfg.Storage.DataDir, 0o755); err != nil {
+			log.Fatalf("Failed to create data directory: %v", err)
+		}
+		db, err := storage.OpenBolt(filepath.Join(cfg.Storage.DataDir, "lineitems.db"))
+		if err != nil {
+			log.Fatalf("Failed to open line items storage: %v", err)
+		}
+		defer db.Close()
+		lineItemRepository, err = storage.NewBoltLineItemRepository(db)
+		if err != nil {
+			log.Fatalf("Failed to load line items: %v", err)
+		}
+		lineItemHistory, err = storage.NewBoltLineItemHistory(db)
+		if err != nil {
+			log.Fatalf("Failed to open line items history: %v", err)
+		}
+		spendStore, err = storage.NewBoltSpendStore(db)
+		if err != nil {
+			log.Fatalf("Failed to open line items spend: %v", err)
+		}
+	case "postgres":
+		db, err := sql.Open("pgx", cfg.Storage.PostgresDSN)
+		if err != nil {
+			log.Fatalf("Failed to open postgres connection: %v", err)
+		}
+		defer db.Close()
+		if err := storage.MigratePostgres(ctx, db); err != nil {
+			log.Fatalf("Failed to migrate postgres schema: %v", err)
+		}
+		postgresRepository := storage.NewPostgresLineItemRepository(db)
+		backfilled, err := postgresRepository.BackfillKeywordTerms(ctx)
+		if err != nil {
+			log.Fatalf("Failed to backfill keyword terms: %v", err)
+		}
+		if backfilled > 0 {
+			log.Infof("Backfilled keyword terms of %d line items", backfilled)
+		}
+		lineItemRepository = postgresRepository
+		lineItemHistory = storage.NewPostgresLineItemHistory(db)
+		spendStore = storage.NewPostgresSpendStore(db)
+	default:
+		log.Fatalf("Unknown line items storage: %q", cfg.Storage.LineItems)
+	}
 
-	// TODO: implement tracking events storage
-	discardTrackingEventsStorage := service.TrackingEventsStorageFunc(func(_ context.Context, _ []model.TrackingEvent) error { return nil })
+	// Initialize services
+	lineItemService := service.NewLineItemService(lineItemRepository, lineItemHistory, service.SystemClock{}, log)
+	go lineItemService.ExpiredLineItemsSweeper(ctx, cfg.Scheduler.SweepInterval)
+	budgetLocation, err := time.LoadLocation(cfg.Budget.Timezone)
+	if err != nil {
+		log.Fatalf("Failed to load budget timezone: %v", err)
+	}
+	budgetService := service.NewBudgetService(lineItemService, spendStore, service.SystemClock{}, budgetLocation, log)
+	go budgetService.PersistLoop(ctx, cfg.Budget.PersistInterval)
+	pacingService := service.NewPacingService(budgetService)
+	frequencyCounters := service.NewMemoryFrequencyCounterStore(service.SystemClock{})
+	go frequencyCounters.EvictLoop(ctx, cfg.Frequency.EvictInterval)
+	frequencyCapService := service.NewFrequencyCapService(lineItemService, frequencyCounters, service.SystemClock{}, log)
+	auction := service.Auction{
+		Type:   service.AuctionType(cfg.Auction.Type),
+		Floors: cfg.Auction.Floors,
+	}
+	if err := auction.Validate(); err != nil {
+		log.Fatalf("Invalid auction configuration: %v", err)
+	}
+	diversity := service.Diversity{
+		MaxAdsPerAdvertiser: cfg.Diversity.MaxAdsPerAdvertiser,
+	}
+	for _, group := range cfg.Diversity.ExclusionGroups {
+		diversity.ExclusionGroups = append(diversity.ExclusionGroups, service.ExclusionGroup{
+			Name:        group.Name,
+			Advertisers: group.Advertisers,
+			Categories:  group.Categories,
+		})
+	}
+	if err := diversity.Validate(); err != nil {
+		log.Fatalf("Invalid diversity configuration: %v", err)
+	}
+	signer, err := newSigner(cfg.Signing, log)
+	if err != nil {
+		log.Fatalf("Invalid signing configuration: %v", err)
+	}
+	adServiceOptions := []service.AdServiceOption{
+		service.WithAuction(auction),
+		service.WithDiversity(diversity),
+		service.WithAdURLs(service.AdURLs{
+			BaseURL: cfg.Server.PublicURL,
+			Signer:  signer,
+			TTL:     cfg.Signing.TokenTTL,
+			Clock:   service.SystemClock{},
+		}),
+		service.WithLineItemFilter(budgetService),
+		service.WithLineItemFilter(pacingService),
+		service.WithFrequencyCaps(frequencyCapService),
+	}
+	switch cfg.Scoring.Scorer {
+	case "tfidf":
+		scorer := service.NewTFIDFScorer()
+		go scorer.RefreshLoop(ctx, lineItemService, cfg.Scoring.RefreshInterval)
+		adServiceOptions = append(adServiceOptions, service.WithScorer(scorer, cfg.Scoring.RelevanceWeight))
+	case "none":
+	default:
+		log.Fatalf("Unknown scorer: %q", cfg.Scoring.Scorer)
+	}
+	adService := service.NewAdService(lineItemService, log, adServiceOptions...)
+	trackingEventsStorage, closeTrackingEventsStorage, err := storage.OpenTrackingEventsStorage(ctx, cfg, log)
+	if err != nil {
+		log.Fatalf("Failed to open tracking events storage: %v", err)
+	}
+	trackingEventsBreaker, err := service.NewCircuitBreaker(trackingEventsStorage, service.SystemClock{}, cfg.Tracking.BreakerFailureThreshold, cfg.Tracking.BreakerOpenTimeout)
+	if err != nil {
+		log.Fatalf("Invalid tracking circuit breaker configuration: %v", err)
+	}
+	trackingRetryPolicy := service.RetryPolicy{
+		MaxAttempts:    cfg.Tracking.RetryMaxAttempts,
+		InitialBackoff: cfg.Tracking.RetryInitialBackoff,
+		MaxBackoff:     cfg.Tracking.RetryMaxBackoff,
+		Jitter:         cfg.Tracking.RetryJitter,
+	}
+	if err := trackingRetryPolicy.Validate(); err != nil {
+		log.Fatalf("Invalid tracking retry configuration: %v", err)
+	}
+	deadLetters, err := storage.NewFileDeadLetterStore(storage.DeadLetterDir(cfg), service.SystemClock{})
+	if err != nil {
+		log.Fatalf("Failed to open tracking dead-letter store: %v", err)
+	}
+	if cfg.Tracking.Workers < 1 {
+		log.Fatalf("Invalid number of tracking workers: %d", cfg.Tracking.Workers)
+	}
 	trackingEventsWriteTimeout := 10 * time.Second // TODO: configurable from ENV
-	trackingService := service.NewTrackingService(trackingEventsBuffer, discardTrackingEventsStorage, trackingEventsWriteTimeout, log)
+	trackingServiceOptions := []service.TrackingServiceOption{
+		service.WithWorkers(cfg.Tracking.Workers),
+		service.WithTrackingEventListener(budgetService),
+		service.WithTrackingEventListener(frequencyCapService),
+		service.WithOverflowPolicy(service.OverflowPolicy(cfg.Tracking.OverflowPolicy), cfg.Tracking.OverflowWait),
+		service.WithRetryPolicy(trackingRetryPolicy),
+		service.WithDeadLetterStore(deadLetters),
+	}
+	if cfg.Tracking.OverflowPolicy == string(service.OverflowSpill) {
+		spill, err := storage.NewFileSpillQueue(filepath.Join(cfg.Storage.DataDir, "spill"))
+		if err != nil {
+			log.Fatalf("Failed to open tracking events spill queue: %v", err)
+		}
+		defer spill.Close()
+		trackingServiceOptions = append(trackingServiceOptions, service.WithSpillQueue(spill))
+	}
+	if cfg.Tracking.WAL {
+		eventLog, err := storage.NewFileEventLog(storage.EventLogDir(cfg), cfg.Tracking.WALSegmentBytes)
+		if err != nil {
+			log.Fatalf("Failed to open tracking events log: %v", err)
+		}
+		defer eventLog.Close()
+		trackingServiceOptions = append(trackingServiceOptions, service.WithEventLog(eventLog))
+	}
+	if cfg.Tracking.DedupWindow > 0 {
+		dedup, err := service.NewBloomDeduplicator(service.SystemClock{}, cfg.Tracking.DedupWindow, cfg.Tracking.DedupCapacity, cfg.Tracking.DedupFalsePositiveRate)
+		if err != nil {
+			log.Fatalf("Invalid tracking de-duplication configuration: %v", err)
+		}
+		trackingServiceOptions = append(trackingServiceOptions, service.WithEventDeduplicator(dedup))
+	}
+	trackingService := service.NewTrackingService(cfg.Tracking.BufferSize, trackingEventsBreaker, trackingEventsWriteTimeout, log, trackingServiceOptions...)
+	if err := trackingService.ValidateOverflowPolicy(); err != nil {
+		log.Fatalf("Invalid tracking overflow configuration: %v", err)
+	}
+	go trackingService.ReplaySpilled(ctx, cfg.Tracking.SpillReplayInterval)
+	// the workers have their own context, they are stopped only once no more events are accepted
+	workerCtx, stopWorkers := context.WithCancel(context.Background())
+	defer stopWorkers()
+	trackingWorkerDone := make(chan struct{})
 	go func() {
+		defer close(trackingWorkerDone)
 		// TODO: configurable from ENV
 		chunkSize := 100
 		flushEvery := 3 * time.Second
-		err := trackingService.TrackingEventsWorker(ctx, chunkSize, flushEvery)
+		err := trackingService.TrackingEventsWorker(workerCtx, chunkSize, flushEvery)
 		if err != nil {
 			log.Errorf("Tracking events worker stopped with an error: %v", err)
 
 			stop() // we gracefully stop the service in case if worker is stopped
 		}
 	}()
+	// events which were not written before a crash are passed to the worker before new ones are accepted
+	replayed, err := trackingService.ReplayEventLog(ctx)
+	if err != nil {
+		log.Fatalf("Failed to replay tracking events log: %v", err)
+	}
+	if replayed > 0 {
+		log.Infof("Replayed %d tracking events from the log", replayed)
+	}
 
 	// Setup Fiber app
 	app := fiber.New(fiber.Config{
@@ -77,11 +266,13 @@ func main() {
 
 	// Register middleware
 	app.Use(recover.New())
+	app.Use(requestid.New())
 	app.Use(fiberlogger.New())
 	app.Use(cors.New())
 
 	// Register routes
 	app.Get("/health", handler.HealthCheck)
+	app.Get("/metrics", adaptor.HTTPHandler(promhttp.Handler()))
 
 	api := app.Group("/api/v1")
 
@@ -90,6 +281,16 @@ func main() {
 	api.Post("/lineitems", lineItemHandler.Create)
 	api.Get("/lineitems", lineItemHandler.GetAll)
 	api.Get("/lineitems/:id", lineItemHandler.GetByID)
+	api.Patch("/lineitems/:id", lineItemHandler.Update)
+	api.Delete("/lineitems/:id", lineItemHandler.Archive)
+	api.Post("/lineitems/:id/pause", lineItemHandler.Pause)
+	api.Post("/lineitems/:id/resume", lineItemHandler.Resume)
+	api.Post("/lineitems/:id/complete", lineItemHandler.Complete)
+	api.Get("/lineitems/:id/history", lineItemHandler.History)
+	api.Post("/lineitems/:id/history/:version/restore", lineItemHandler.Restore)
+
+	pacingHandler := handler.NewPacingHandler(lineItemService, pacingService, log)
+	api.Get("/lineitems/:id/pacing", pacingHandler.GetState)
 
 	// Ad endpoints - TO BE IMPLEMENTED BY CANDIDATE
 	adHandler := handler.NewAdHandler(adService, log)
@@ -98,6 +299,13 @@ func main() {
 	// Tracking endpoint - TO BE IMPLEMENTED BY CANDIDATE
 	trackingHandler := handler.NewTrackingHandler(trackingService, log)
 	api.Post("/tracking", trackingHandler.TrackEvent)
+	api.Post("/tracking/batch", trackingHandler.TrackBatch)
+
+	// Signed URLs of served ads
+	pixelHandler := handler.NewPixelHandler(service.NewSignedTrackingService(trackingService, lineItemService, signer, service.SystemClock{}, log), log)
+	app.Get(service.ServePath, pixelHandler.Serve)
+	app.Get(service.ImpressionPath, pixelHandler.Impression)
+	app.Get(service.ClickPath, pixelHandler.Click)
 
 	// Start server
 	go func() {
@@ -113,8 +321,42 @@ func main() {
 	log.Info("Shutting down server...")
 
 	if err := app.Shutdown(); err != nil {
-		log.Fatalf("Error shutting down server: %v", err)
+		log.Errorf("Error shutting down server: %v", err)
+	}
+
+	// the workers flush all accepted events before the storage is closed
+	trackingService.StopAccepting()
+	stopWorkers()
+	<-trackingWorkerDone
+	if err := closeTrackingEventsStorage(); err != nil {
+		log.Errorf("Failed to close tracking events storage: %v", err)
+	}
+	if err := budgetService.Persist(); err != nil {
+		log.Errorf("Failed to persist spend of line items: %v", err)
 	}
 
 	log.Info("Server gracefully stopped")
 }
+
+// newSigner creates a signer of served ads URLs. Without configured keys a random key is generated,
+// so URLs of served ads are not accepted after a restart or by other instances.
+func newSigner(cfg config.SigningConfig, log *zap.SugaredLogger) (*signing.Signer, error) {
+	if len(cfg.Keys) == 0 {
+		log.Warn("No signing keys configured, using a random key")
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, fmt.Errorf("generate signing key: %w", err)
+		}
+		return signing.NewSigner("random", []signing.Key{{ID: "random", Secret: secret}})
+	}
+
+	keyID := cfg.KeyID
+	keys := make([]signing.Key, 0, len(cfg.Keys))
+	for id, secret := range cfg.Keys {
+		keys = append(keys, signing.Key{ID: id, Secret: []byte(secret)})
+		if len(cfg.Keys) == 1 && keyID == "" {
+			keyID = id
+		}
+	}
+	return signing.NewSigner(keyID, keys)
+}
@@ -1,6 +1,8 @@
 package config
 
 import (
+	"encoding/json"
+	"fmt"
 	"time"
 
 	"github.com/kelseyhightower/envconfig"
@@ -8,8 +10,17 @@ import (
 
 // Config represents the application configuration
 type Config struct {
-	App    AppConfig    `split_words:"true"`
-	Server ServerConfig `split_words:"true"`
+	App       AppConfig       `split_words:"true"`
+	Server    ServerConfig    `split_words:"true"`
+	Storage   StorageConfig   `split_words:"true"`
+	Scheduler SchedulerConfig `split_words:"true"`
+	Budget    BudgetConfig    `split_words:"true"`
+	Auction   AuctionConfig   `split_words:"true"`
+	Scoring   ScoringConfig   `split_words:"true"`
+	Frequency FrequencyConfig `split_words:"true"`
+	Diversity DiversityConfig `split_words:"true"`
+	Signing   SigningConfig   `split_words:"true"`
+	Tracking  TrackingConfig  `split_words:"true"`
 }
 
 // AppConfig contains application-specific configuration
@@ -24,6 +35,200 @@ type AppConfig struct {
 type ServerConfig struct {
 	Port    int           `default:"8080"`
 	Timeout time.Duration `default:"30s"`
+	// PublicURL is the URL clients reach the server at, URLs of served ads point to it
+	PublicURL string `default:"http://localhost:8080" split_words:"true"`
+}
+
+// StorageConfig contains persistence configuration
+type StorageConfig struct {
+	// LineItems selects the line items storage: "memory", "file" or "postgres"
+	LineItems string `default:"memory" split_words:"true"`
+	// DataDir is a directory for file based storages
+	DataDir string `default:"data" split_words:"true"`
+	// PostgresDSN is a connection string used by the "postgres" storage
+	PostgresDSN string `split_words:"true"`
+}
+
+// SchedulerConfig contains line items scheduling configuration
+type SchedulerConfig struct {
+	// SweepInterval is how often line items with an expired flight are completed
+	SweepInterval time.Duration `default:"1m" split_words:"true"`
+}
+
+// BudgetConfig contains budget enforcement configuration
+type BudgetConfig struct {
+	// Timezone is the time zone whose midnight starts a new budget day
+	Timezone string `default:"UTC"`
+	// PersistInterval is how often charges are added to the spend persisted in the line items storage
+	PersistInterval time.Duration `default:"1s" split_words:"true"`
+}
+
+// AuctionConfig contains ad auction configuration
+type AuctionConfig struct {
+	// Type is "first_price" or "second_price"
+	Type string `default:"second_price"`
+	// Floors are floor prices (CPM) by placement, e.g. "homepage_top:1.5,sidebar:0.5"
+	Floors map[string]float64
+}
+
+// ScoringConfig contains ad relevance scoring configuration
+type ScoringConfig struct {
+	// Scorer is "tfidf" or "none"
+	Scorer string `default:"tfidf"`
+	// RelevanceWeight is the exponent of relevance in the rank score bid × relevance^weight
+	RelevanceWeight float64 `default:"1" split_words:"true"`
+	// RefreshInterval is how often term statistics of line items are recomputed
+	RefreshInterval time.Duration `default:"1m" split_words:"true"`
+}
+
+// FrequencyConfig contains frequency capping configuration
+type FrequencyConfig struct {
+	// EvictInterval is how often expired impression counters are deleted
+	EvictInterval time.Duration `default:"1m" split_words:"true"`
+}
+
+// DiversityConfig contains rules limiting similar ads in one response
+type DiversityConfig struct {
+	// MaxAdsPerAdvertiser limits ads of an advertiser in one response, 0 means no limit
+	MaxAdsPerAdvertiser int `default:"1" split_words:"true"`
+	// ExclusionGroups are groups of competitors which never appear together
+	ExclusionGroups ExclusionGroups `split_words:"true"`
+}
+
+// ExclusionGroups is a JSON list of competitive exclusion groups, e.g.
+// [{"name":"cola","advertisers":["adv_1","adv_2"],"categories":["soft_drinks"]}]
+type ExclusionGroups []ExclusionGroup
+
+// ExclusionGroup is a group of competing advertisers and categories
+type ExclusionGroup struct {
+	Name        string   `json:"name"`
+	Advertisers []string `json:"advertisers"`
+	Categories  []string `json:"categories"`
+}
+
+// Decode implements envconfig.Decoder
+func (g *ExclusionGroups) Decode(value string) error {
+	return json.Unmarshal([]byte(value), g)
+}
+
+// SigningConfig contains keys signing tokens of served ads URLs
+type SigningConfig struct {
+	// Keys are secrets by key ID, e.g. "k2:secret2,k1:secret1". All of them verify tokens,
+	// so a key may be rotated by adding a new one and removing the old one once its tokens expire.
+	Keys map[string]string
+	// KeyID selects the key signing new tokens, may be omitted if there is a single key
+	KeyID string `split_words:"true"`
+	// TokenTTL is how long tracking URLs of served ads are accepted
+	TokenTTL time.Duration `default:"24h" split_words:"true"`
+}
+
+// TrackingConfig contains tracking events configuration
+type TrackingConfig struct {
+	// DedupWindow is how long event IDs are remembered to drop duplicates, 0 disables de-duplication
+	DedupWindow time.Duration `default:"10m" split_words:"true"`
+	// DedupCapacity is the expected number of events per window, memory of the dedup filters is sized for it
+	DedupCapacity int `default:"1000000" split_words:"true"`
+	// DedupFalsePositiveRate is the probability to drop a unique event as a duplicate within the capacity
+	DedupFalsePositiveRate float64 `default:"0.0001" split_words:"true"`
+	// BufferSize is the number of events waiting for the storage
+	BufferSize int `default:"1000" split_words:"true"`
+	// Workers is the number of workers writing events to the storage, events are sharded between them by line item
+	Workers int `default:"1"`
+	// OverflowPolicy is what happens to events once the buffer is full: "wait", "spill" or "reject"
+	OverflowPolicy string `default:"wait" split_words:"true"`
+	// OverflowWait is how long an event waits for free space in the buffer with the "wait" policy
+	OverflowWait time.Duration `default:"50ms" split_words:"true"`
+	// SpillReplayInterval is how often spilled events are passed to the storage with the "spill" policy
+	SpillReplayInterval time.Duration `default:"1s" split_words:"true"`
+	// Sink selects the events storage: "file", "kafka" or "discard"
+	Sink string `default:"file"`
+	// Sinks are storages events are fanned out to, they replace Sink if set
+	Sinks TrackingSinks
+	// FileDir is a directory of the "file" sink, "events" of the data directory by default
+	FileDir string `split_words:"true"`
+	// FileCompression is "none", "gzip" or "zstd"
+	FileCompression string `default:"gzip" split_words:"true"`
+	// FileMaxSegmentBytes is the size a segment file is completed at
+	FileMaxSegmentBytes int64 `default:"67108864" split_words:"true"`
+	// FileMaxSegmentAge is the age a segment file is completed at
+	FileMaxSegmentAge time.Duration `default:"5m" split_words:"true"`
+	// KafkaBrokers are seed brokers of the "kafka" sink
+	KafkaBrokers []string `default:"localhost:9092" split_words:"true"`
+	// KafkaTopic is the topic tracking events are produced to, records are keyed by the line item ID
+	KafkaTopic string `default:"tracking-events" split_words:"true"`
+	// KafkaClientID identifies the producer in the broker logs and quotas
+	KafkaClientID string `default:"ad-service" split_words:"true"`
+	// KafkaAcks is "all", "leader" or "none"
+	KafkaAcks string `default:"all" split_words:"true"`
+	// KafkaCompression is "none", "gzip", "snappy", "lz4" or "zstd"
+	KafkaCompression string `default:"zstd" split_words:"true"`
+	// RetryMaxAttempts is the number of attempts to write a batch of events, including the first one
+	RetryMaxAttempts int `default:"5" split_words:"true"`
+	// RetryInitialBackoff is the backoff after the first failed attempt, it doubles with every attempt
+	RetryInitialBackoff time.Duration `default:"200ms" split_words:"true"`
+	// RetryMaxBackoff limits the backoff between attempts
+	RetryMaxBackoff time.Duration `default:"10s" split_words:"true"`
+	// RetryJitter is the share of a backoff which is randomized
+	RetryJitter float64 `default:"0.5" split_words:"true"`
+	// BreakerFailureThreshold is the number of consecutive failed writes opening the circuit breaker
+	BreakerFailureThreshold int `default:"5" split_words:"true"`
+	// BreakerOpenTimeout is how long writes fail fast before the storage is probed again
+	BreakerOpenTimeout time.Duration `default:"30s" split_words:"true"`
+	// DeadLetterDir is a directory of batches which cannot be written, "deadletter" of the data directory by default
+	DeadLetterDir string `split_words:"true"`
+	// WAL appends accepted events to a write-ahead log, so events which are not written yet survive a crash
+	WAL bool `default:"false"`
+	// WALDir is a directory of the write-ahead log, "wal" of the data directory by default
+	WALDir string `split_words:"true"`
+	// WALSegmentBytes is the size a segment of the write-ahead log is rotated at
+	WALSegmentBytes int64 `default:"67108864" split_words:"true"`
+}
+
+// TrackingSinks is a JSON list of tracking events sinks, e.g.
+// [{"name":"durable","type":"file","required":true},{"name":"attribution","type":"kafka","topic":"attribution","event_types":["click","conversion"],"timeout":"2s"}]
+type TrackingSinks []TrackingSink
+
+// TrackingSink is a storage tracking events are fanned out to
+type TrackingSink struct {
+	Name string `json:"name"`
+	// Type is "file", "kafka" or "discard", the sink is configured by the TRACKING_FILE_* or TRACKING_KAFKA_* variables
+	Type string `json:"type"`
+	// Required sinks fail writes, failures of best-effort sinks are only logged
+	Required bool `json:"required"`
+	// Timeout limits writes to the sink
+	Timeout time.Duration `json:"-"`
+	// EventTypes the sink receives, all of them if empty
+	EventTypes []string `json:"event_types"`
+	// Topic overrides the topic of a "kafka" sink
+	Topic string `json:"topic"`
+	// Dir overrides the directory of a "file" sink
+	Dir string `json:"dir"`
+}
+
+// Decode implements envconfig.Decoder
+func (s *TrackingSinks) Decode(value string) error {
+	return json.Unmarshal([]byte(value), s)
+}
+
+// UnmarshalJSON decodes a sink with the timeout as a duration string, e.g. "2s"
+func (s *TrackingSink) UnmarshalJSON(data []byte) error {
+	type sink TrackingSink
+	value := struct {
+		*sink
+		Timeout string `json:"timeout"`
+	}{sink: (*sink)(s)}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	if value.Timeout == "" {
+		return nil
+	}
+	timeout, err := time.ParseDuration(value.Timeout)
+	if err != nil {
+		return fmt.Errorf("sink %q: invalid timeout: %w", s.Name, err)
+	}
+	s.Timeout = timeout
+	return nil
 }
 
 // Load loads the configuration from environment variables
@@ -1,12 +1,20 @@
 package handler
 
 import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"sweng-task/internal/model"
 	"sweng-task/internal/service"
 
 	"github.com/gofiber/fiber/v2"
 	"go.uber.org/zap"
 )
 
+// maxTargetingValues limits the number of categories and keywords of an ads request
+const maxTargetingValues = 50
+
 // AdHandler handles HTTP requests related to ads
 type AdHandler struct {
 	service *service.AdService
@@ -33,13 +41,48 @@ func (h *AdHandler) GetWinningAds(c *fiber.Ctx) error {
 		return BadRequestResponse(c, "'limit' must me in the range [1-10]", nil)
 	}
 
-	category := c.Query("category")
-	keyword := c.Query("keyword")
+	categories := queryValues(c, "category")
+	if len(categories) > maxTargetingValues {
+		return BadRequestResponse(c, fmt.Sprintf("Too many 'category' values, at most %d are allowed", maxTargetingValues), nil)
+	}
+	keywords := queryValues(c, "keyword")
+	if len(keywords) > maxTargetingValues {
+		return BadRequestResponse(c, fmt.Sprintf("Too many 'keyword' values, at most %d are allowed", maxTargetingValues), nil)
+	}
 
-	ads, err := h.service.GetWinningAds(placement, category, keyword, limit)
+	match := model.MatchMode(c.Query("match", string(model.MatchAny)))
+	if match != model.MatchAny && match != model.MatchAll {
+		return BadRequestResponse(c, "'match' must be one of: any, all", nil)
+	}
+
+	ads, err := h.service.GetWinningAds(model.AdRequest{
+		TargetingQuery: model.TargetingQuery{
+			Placement:  placement,
+			Categories: categories,
+			Keywords:   keywords,
+			Match:      match,
+		},
+		Limit:  limit,
+		UserID: c.Query("user_id"),
+	})
 	if err != nil {
 		return InternalServerErrorResponse(c, "Failed to get winning ads", err.Error())
 	}
 
 	return c.Status(fiber.StatusOK).JSON(ads)
 }
+
+// queryValues returns distinct values of a query parameter which may be repeated
+// and may contain comma-separated values: ?keyword=a,b&keyword=c
+func queryValues(c *fiber.Ctx, key string) []string {
+	var values []string
+	for _, raw := range c.Context().QueryArgs().PeekMulti(key) {
+		for _, v := range strings.Split(string(raw), ",") {
+			v = strings.TrimSpace(v)
+			if v != "" && !slices.Contains(values, v) {
+				values = append(values, v)
+			}
+		}
+	}
+	return values
+}
@@ -5,7 +5,7 @@ import "github.com/gofiber/fiber/v2"
 func ErrorResponse(c *fiber.Ctx, status int, message string, details interface{}) error {
 	jsonResponse := fiber.Map{
 		"code":    status,
-		"message": "Missing line item ID",
+		"message": message,
 	}
 	if details != nil {
 		jsonResponse["details"] = details
@@ -17,6 +17,18 @@ func BadRequestResponse(c *fiber.Ctx, message string, details interface{}) error
 	return ErrorResponse(c, fiber.StatusBadRequest, message, details)
 }
 
+func NotFoundResponse(c *fiber.Ctx, message string, details interface{}) error {
+	return ErrorResponse(c, fiber.StatusNotFound, message, details)
+}
+
+func ConflictResponse(c *fiber.Ctx, message string, details interface{}) error {
+	return ErrorResponse(c, fiber.StatusConflict, message, details)
+}
+
 func InternalServerErrorResponse(c *fiber.Ctx, message string, details interface{}) error {
 	return ErrorResponse(c, fiber.StatusInternalServerError, message, details)
 }
+
+func ServiceUnavailableResponse(c *fiber.Ctx, message string, details interface{}) error {
+	return ErrorResponse(c, fiber.StatusServiceUnavailable, message, details)
+}
@@ -1,6 +1,11 @@
 package handler
 
 import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
 	"sweng-task/internal/model"
 
 	"sweng-task/internal/service"
@@ -47,15 +52,12 @@ func (h *LineItemHandler) Create(c *fiber.Ctx) error {
 	// Go Parse, Don't Validate
 	// https://totallygamerjet.hashnode.dev/go-parse-dont-validate
 
-	lineItem, err := h.service.Create(input)
+	lineItem, err := h.service.Create(input, changeMeta(c))
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"code":    fiber.StatusInternalServerError,
-			"message": "Failed to create line item",
-			"details": err.Error(),
-		})
+		return h.lineItemChangeErrorResponse(c, "Failed to create line item", err)
 	}
 
+	setETag(c, lineItem)
 	return c.Status(fiber.StatusCreated).JSON(lineItem)
 }
 
@@ -84,6 +86,7 @@ func (h *LineItemHandler) GetByID(c *fiber.Ctx) error {
 		})
 	}
 
+	setETag(c, lineItem)
 	return c.Status(fiber.StatusOK).JSON(lineItem)
 }
 
@@ -103,3 +106,169 @@ func (h *LineItemHandler) GetAll(c *fiber.Ctx) error {
 
 	return c.Status(fiber.StatusOK).JSON(lineItems)
 }
+
+// Update handles a partial update of a line item.
+// 'If-Match' header with the current ETag of the line item is required,
+// so concurrent editors cannot silently overwrite each other.
+func (h *LineItemHandler) Update(c *fiber.Ctx) error {
+	version, ok, err := ifMatchVersion(c)
+	if err != nil {
+		return BadRequestResponse(c, "Invalid 'If-Match' header", err.Error())
+	}
+	if !ok {
+		return ErrorResponse(c, fiber.StatusPreconditionRequired, "'If-Match' header is required", nil)
+	}
+
+	var input model.LineItemUpdate
+	if err := c.BodyParser(&input); err != nil {
+		return BadRequestResponse(c, "Invalid request body", err.Error())
+	}
+
+	lineItem, err := h.service.Update(c.Params("id"), input, version, changeMeta(c))
+	if err != nil {
+		return h.lineItemChangeErrorResponse(c, "Failed to update line item", err)
+	}
+
+	setETag(c, lineItem)
+	return c.Status(fiber.StatusOK).JSON(lineItem)
+}
+
+// Pause handles pausing of an active line item
+func (h *LineItemHandler) Pause(c *fiber.Ctx) error {
+	return h.setStatus(c, model.LineItemStatusPaused)
+}
+
+// Resume handles resuming of a paused line item
+func (h *LineItemHandler) Resume(c *fiber.Ctx) error {
+	return h.setStatus(c, model.LineItemStatusActive)
+}
+
+// Complete handles completion of a line item, completed line items cannot be resumed
+func (h *LineItemHandler) Complete(c *fiber.Ctx) error {
+	return h.setStatus(c, model.LineItemStatusCompleted)
+}
+
+// Archive handles soft deletion of a line item
+func (h *LineItemHandler) Archive(c *fiber.Ctx) error {
+	return h.setStatus(c, model.LineItemStatusArchived)
+}
+
+// History handles paging through the change history of a line item.
+// Changes are ordered by version, the next page starts after the last returned version.
+func (h *LineItemHandler) History(c *fiber.Ctx) error {
+	after := c.QueryInt("after", 0)
+	if after < 0 {
+		return BadRequestResponse(c, "'after' must be a non-negative version", nil)
+	}
+	limit := c.QueryInt("limit", 20)
+	if limit < 1 || limit > 100 {
+		return BadRequestResponse(c, "'limit' must be in the range [1-100]", nil)
+	}
+
+	changes, err := h.service.History(c.Params("id"), int64(after), limit)
+	if err != nil {
+		if errors.Is(err, service.ErrLineItemNotFound) {
+			return NotFoundResponse(c, "Line item not found", nil)
+		}
+		return InternalServerErrorResponse(c, "Failed to retrieve line item history", err.Error())
+	}
+
+	response := fiber.Map{
+		"changes": changes,
+	}
+	if len(changes) == limit {
+		response["next_after"] = changes[len(changes)-1].Version
+	}
+	return c.Status(fiber.StatusOK).JSON(response)
+}
+
+// Restore handles restoring a line item to one of its previous revisions
+func (h *LineItemHandler) Restore(c *fiber.Ctx) error {
+	revision, err := strconv.ParseInt(c.Params("version"), 10, 64)
+	if err != nil || revision < 1 {
+		return BadRequestResponse(c, "Invalid revision version", nil)
+	}
+	version, _, err := ifMatchVersion(c)
+	if err != nil {
+		return BadRequestResponse(c, "Invalid 'If-Match' header", err.Error())
+	}
+
+	lineItem, err := h.service.Restore(c.Params("id"), revision, version, changeMeta(c))
+	if err != nil {
+		return h.lineItemChangeErrorResponse(c, "Failed to restore line item", err)
+	}
+
+	setETag(c, lineItem)
+	return c.Status(fiber.StatusOK).JSON(lineItem)
+}
+
+// setStatus changes a line item status, 'If-Match' header is optional here
+func (h *LineItemHandler) setStatus(c *fiber.Ctx, status model.LineItemStatus) error {
+	version, _, err := ifMatchVersion(c)
+	if err != nil {
+		return BadRequestResponse(c, "Invalid 'If-Match' header", err.Error())
+	}
+
+	lineItem, err := h.service.SetStatus(c.Params("id"), status, version, changeMeta(c))
+	if err != nil {
+		return h.lineItemChangeErrorResponse(c, "Failed to change line item status", err)
+	}
+
+	setETag(c, lineItem)
+	return c.Status(fiber.StatusOK).JSON(lineItem)
+}
+
+// lineItemChangeErrorResponse maps errors of line item modifications to responses
+func (h *LineItemHandler) lineItemChangeErrorResponse(c *fiber.Ctx, message string, err error) error {
+	switch {
+	case errors.Is(err, service.ErrLineItemNotFound):
+		return NotFoundResponse(c, "Line item not found", nil)
+	case errors.Is(err, service.ErrInvalidLineItem):
+		return BadRequestResponse(c, message, err.Error())
+	case errors.Is(err, service.ErrVersionMismatch):
+		return ErrorResponse(c, fiber.StatusPreconditionFailed, "Line item was modified concurrently", err.Error())
+	case errors.Is(err, service.ErrLineItemNotEditable), errors.Is(err, service.ErrInvalidStatusTransition):
+		return ConflictResponse(c, message, err.Error())
+	default:
+		return InternalServerErrorResponse(c, message, err.Error())
+	}
+}
+
+// setETag sets the line item version as an ETag
+func setETag(c *fiber.Ctx, item *model.LineItem) {
+	c.Set(fiber.HeaderETag, `"`+strconv.FormatInt(item.Version, 10)+`"`)
+}
+
+// ifMatchVersion parses the line item version from the 'If-Match' header.
+// Returns false if the header is absent, '*' matches any version and gives zero version.
+func ifMatchVersion(c *fiber.Ctx) (int64, bool, error) {
+	header := strings.TrimSpace(c.Get(fiber.HeaderIfMatch))
+	switch header {
+	case "":
+		return 0, false, nil
+	case "*":
+		return 0, true, nil
+	}
+
+	// only strong entity tags are issued, so weak ones are rejected as well
+	tag := header
+	if len(tag) < 2 || tag[0] != '"' || tag[len(tag)-1] != '"' {
+		return 0, false, fmt.Errorf("malformed entity tag: %s", header)
+	}
+
+	version, err := strconv.ParseInt(tag[1:len(tag)-1], 10, 64)
+	if err != nil || version < 1 {
+		return 0, false, fmt.Errorf("unknown entity tag: %s", header)
+	}
+	return version, true, nil
+}
+
+// changeMeta describes the author of a change: the actor comes from the 'X-Actor' header,
+// the request ID is set by the requestid middleware
+func changeMeta(c *fiber.Ctx) model.ChangeMeta {
+	requestID, _ := c.Locals("requestid").(string)
+	return model.ChangeMeta{
+		Actor:     c.Get("X-Actor"),
+		RequestID: requestID,
+	}
+}
@@ -0,0 +1,39 @@
+package handler
+
+import (
+	"errors"
+
+	"sweng-task/internal/service"
+
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
+)
+
+// PacingHandler handles HTTP requests related to budget pacing
+type PacingHandler struct {
+	lineItems *service.LineItemService
+	pacing    *service.PacingService
+	log       *zap.SugaredLogger
+}
+
+// NewPacingHandler creates a new PacingHandler
+func NewPacingHandler(lineItems *service.LineItemService, pacing *service.PacingService, log *zap.SugaredLogger) *PacingHandler {
+	return &PacingHandler{
+		lineItems: lineItems,
+		pacing:    pacing,
+		log:       log,
+	}
+}
+
+// GetState handles retrieving the current pacing state of a line item
+func (h *PacingHandler) GetState(c *fiber.Ctx) error {
+	lineItem, err := h.lineItems.GetByID(c.Params("id"))
+	if err != nil {
+		if errors.Is(err, service.ErrLineItemNotFound) {
+			return NotFoundResponse(c, "Line item not found", nil)
+		}
+		return InternalServerErrorResponse(c, "Failed to retrieve line item", err.Error())
+	}
+
+	return c.Status(fiber.StatusOK).JSON(h.pacing.State(lineItem))
+}
@@ -0,0 +1,125 @@
+package handler
+
+import (
+	"bytes"
+	"errors"
+	"html/template"
+	"net/url"
+
+	"sweng-task/internal/service"
+	"sweng-task/internal/signing"
+
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
+)
+
+// transparentGIF is a 1×1 transparent GIF image
+var transparentGIF = []byte{
+	0x47, 0x49, 0x46, 0x38, 0x39, 0x61, 0x01, 0x00, 0x01, 0x00, 0x80, 0x00, 0x00, 0x00, 0x00, 0x00,
+	0xff, 0xff, 0xff, 0x21, 0xf9, 0x04, 0x01, 0x00, 0x00, 0x00, 0x00, 0x2c, 0x00, 0x00, 0x00, 0x00,
+	0x01, 0x00, 0x01, 0x00, 0x00, 0x02, 0x02, 0x44, 0x01, 0x00, 0x3b,
+}
+
+// adTemplate is the markup of a served ad, it requests the impression pixel and links to the click redirect
+var adTemplate = template.Must(template.New("ad").Parse(`<!DOCTYPE html>
+<html>
+<body style="margin:0">
+<a href="{{.ClickURL}}" target="_top">{{.Name}}</a>
+<img src="{{.ImpressionURL}}" width="1" height="1" alt="" style="position:absolute">
+</body>
+</html>
+`))
+
+// PixelHandler handles impression pixels and click redirects of served ads
+type PixelHandler struct {
+	service *service.SignedTrackingService
+	log     *zap.SugaredLogger
+}
+
+// NewPixelHandler creates a new PixelHandler
+func NewPixelHandler(service *service.SignedTrackingService, log *zap.SugaredLogger) *PixelHandler {
+	return &PixelHandler{
+		service: service,
+		log:     log,
+	}
+}
+
+// Serve returns the markup of a served ad, its impression and click URLs carry the same token
+func (h *PixelHandler) Serve(c *fiber.Ctx) error {
+	token := c.Query("t")
+	if token == "" {
+		return BadRequestResponse(c, "'t' query parameter is empty", nil)
+	}
+
+	item, err := h.service.Serve(token)
+	if err != nil {
+		return h.errorResponse(c, "Failed to serve ad", err)
+	}
+
+	var body bytes.Buffer
+	err = adTemplate.Execute(&body, map[string]string{
+		"Name":          item.Name,
+		"ImpressionURL": service.ImpressionPath + "?t=" + url.QueryEscape(token),
+		"ClickURL":      service.ClickPath + "?t=" + url.QueryEscape(token),
+	})
+	if err != nil {
+		return InternalServerErrorResponse(c, "Failed to serve ad", err.Error())
+	}
+
+	c.Set(fiber.HeaderCacheControl, "no-store")
+	c.Set(fiber.HeaderContentType, fiber.MIMETextHTMLCharsetUTF8)
+	return c.Status(fiber.StatusOK).Send(body.Bytes())
+}
+
+// Impression records an impression and returns a transparent pixel
+func (h *PixelHandler) Impression(c *fiber.Ctx) error {
+	token := c.Query("t")
+	if token == "" {
+		return BadRequestResponse(c, "'t' query parameter is empty", nil)
+	}
+
+	if _, err := h.service.RecordImpression(token); err != nil {
+		return h.errorResponse(c, "Failed to track impression", err)
+	}
+
+	c.Set(fiber.HeaderCacheControl, "no-store")
+	c.Set(fiber.HeaderContentType, "image/gif")
+	return c.Status(fiber.StatusOK).Send(transparentGIF)
+}
+
+// Click records a click and redirects to the landing page of the line item
+func (h *PixelHandler) Click(c *fiber.Ctx) error {
+	token := c.Query("t")
+	if token == "" {
+		return BadRequestResponse(c, "'t' query parameter is empty", nil)
+	}
+
+	landingURL, _, err := h.service.RecordClick(token)
+	if err != nil {
+		return h.errorResponse(c, "Failed to track click", err)
+	}
+
+	c.Set(fiber.HeaderCacheControl, "no-store")
+	if landingURL == "" {
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+	return c.Redirect(landingURL, fiber.StatusFound)
+}
+
+// errorResponse maps token verification errors, forged tokens are rejected without details
+func (h *PixelHandler) errorResponse(c *fiber.Ctx, message string, err error) error {
+	switch {
+	case errors.Is(err, signing.ErrExpiredToken):
+		return ErrorResponse(c, fiber.StatusGone, "Token expired", nil)
+	case errors.Is(err, signing.ErrInvalidToken), errors.Is(err, signing.ErrUnknownKey):
+		h.log.Debugw("Invalid tracking token",
+			"path", c.Path(),
+			"error", err,
+		)
+		return ErrorResponse(c, fiber.StatusForbidden, "Invalid token", nil)
+	case errors.Is(err, service.ErrLineItemNotFound):
+		return NotFoundResponse(c, "Line item not found", nil)
+	default:
+		return InternalServerErrorResponse(c, message, err.Error())
+	}
+}
@@ -0,0 +1,111 @@
+package handler
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"testing"
+	"time"
+
+	"sweng-task/internal/model"
+	"sweng-task/internal/service"
+	"sweng-task/internal/signing"
+
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
+)
+
+type trackingEventsRecorder struct {
+	events []model.TrackingEvent
+}
+
+func (r *trackingEventsRecorder) OnTrackingEvent(event model.TrackingEvent) {
+	r.events = append(r.events, event)
+}
+
+func TestPixelHandler(t *testing.T) {
+	log := zap.NewNop().Sugar()
+	signer, err := signing.NewSigner("k1", []signing.Key{{ID: "k1", Secret: []byte("0123456789abcdef")}})
+	if err != nil {
+		t.Fatalf("Create signer: %v", err)
+	}
+
+	lineItems := service.NewLineItemService(service.NewMemoryLineItemRepository(), service.NewMemoryLineItemHistory(), service.SystemClock{}, log)
+	item, err := lineItems.Create(model.LineItemCreate{Name: "Summer <sale>", Bid: 2, Budget: 100, Placement: "header", LandingURL: "https://example.com/sale"}, model.ChangeMeta{})
+	if err != nil {
+		t.Fatalf("Create line item: %v", err)
+	}
+
+	recorder := &trackingEventsRecorder{}
+	tracking := service.NewTrackingService(10, nil, time.Second, log, service.WithTrackingEventListener(recorder))
+	ads := service.NewAdService(lineItems, log, service.WithAdURLs(service.AdURLs{
+		BaseURL: "http://ads.example.com",
+		Signer:  signer,
+		TTL:     time.Hour,
+		Clock:   service.SystemClock{},
+	}))
+
+	app := fiber.New()
+	pixelHandler := NewPixelHandler(service.NewSignedTrackingService(tracking, lineItems, signer, service.SystemClock{}, log), log)
+	app.Get(service.ServePath, pixelHandler.Serve)
+	app.Get(service.ImpressionPath, pixelHandler.Impression)
+	app.Get(service.ClickPath, pixelHandler.Click)
+
+	get := func(target string) *http.Response {
+		t.Helper()
+		resp, err := app.Test(httptest.NewRequest(http.MethodGet, target, nil))
+		if err != nil {
+			t.Fatalf("GET %s: %v", target, err)
+		}
+		return resp
+	}
+
+	winners, err := ads.GetWinningAds(model.AdRequest{TargetingQuery: model.TargetingQuery{Placement: "header"}, Limit: 1})
+	if err != nil || len(winners) != 1 {
+		t.Fatalf("Get winning ads: %v, %v", winners, err)
+	}
+	serveURL, err := url.Parse(winners[0].ServeURL)
+	if err != nil {
+		t.Fatalf("Parse serve URL: %v", err)
+	}
+
+	// the served markup links to the pixel and the click redirect of the same ad
+	resp := get(serveURL.RequestURI())
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != fiber.StatusOK {
+		t.Fatalf("Wrong serve status: %d, %s", resp.StatusCode, body)
+	}
+	if !regexp.MustCompile(`>Summer &lt;sale&gt;</a>`).Match(body) {
+		t.Errorf("Name of the ad must be escaped: %s", body)
+	}
+	impression := regexp.MustCompile(`src="([^"]+)"`).FindSubmatch(body)
+	click := regexp.MustCompile(`href="([^"]+)"`).FindSubmatch(body)
+	if impression == nil || click == nil {
+		t.Fatalf("Served ad has no pixel or link: %s", body)
+	}
+	if len(recorder.events) != 0 {
+		t.Errorf("Serving must not record events: %+v", recorder.events)
+	}
+
+	if resp := get(string(impression[1])); resp.StatusCode != fiber.StatusOK {
+		t.Errorf("Wrong impression status: %d", resp.StatusCode)
+	}
+	resp = get(string(click[1]))
+	if resp.StatusCode != fiber.StatusFound || resp.Header.Get(fiber.HeaderLocation) != "https://example.com/sale" {
+		t.Errorf("Wrong click response: %d, %q", resp.StatusCode, resp.Header.Get(fiber.HeaderLocation))
+	}
+	if len(recorder.events) != 2 || recorder.events[0].EventType != model.TrackingEventTypeImpression ||
+		recorder.events[1].EventType != model.TrackingEventTypeClick || recorder.events[0].LineItemID != item.ID {
+		t.Errorf("Wrong recorded events: %+v", recorder.events)
+	}
+
+	// tampered tokens are rejected
+	if resp := get(service.ServePath + "?t=" + url.QueryEscape(serveURL.Query().Get("t")+"x")); resp.StatusCode != fiber.StatusForbidden {
+		t.Errorf("Wrong status for a tampered token: %d", resp.StatusCode)
+	}
+	if resp := get(service.ServePath); resp.StatusCode != fiber.StatusBadRequest {
+		t.Errorf("Wrong status without a token: %d", resp.StatusCode)
+	}
+}
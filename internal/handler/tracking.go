@@ -1,13 +1,29 @@
 package handler
 
 import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
 	"sweng-task/internal/model"
 	"sweng-task/internal/service"
 
+	"time"
+
 	"github.com/gofiber/fiber/v2"
 	"go.uber.org/zap"
 )
 
+// maxTrackingBatchSize limits the number of events of a tracking batch
+const maxTrackingBatchSize = 1000
+
+// trackingRetryAfter is how long clients should wait before sending events again if tracking is overloaded
+const trackingRetryAfter = time.Second
+
 // TrackingHandler handles HTTP requests related to tracking
 type TrackingHandler struct {
 	service *service.TrackingService
@@ -22,7 +38,7 @@ func NewTrackingHandler(service *service.TrackingService, log *zap.SugaredLogger
 	}
 }
 
-// TrackingHandler tracks events
+// TrackEvent tracks an event reported by a client, unsigned events don't spend budgets or count toward frequency caps
 func (h *TrackingHandler) TrackEvent(c *fiber.Ctx) error {
 	var input model.TrackingEvent
 	err := c.BodyParser(&input)
@@ -30,7 +46,14 @@ func (h *TrackingHandler) TrackEvent(c *fiber.Ctx) error {
 		return BadRequestResponse(c, "Invalid request body", err.Error())
 	}
 
-	ok, err := h.service.RecordAdInteraction(input)
+	ok, err := h.service.RecordUnsignedAdInteraction(input)
+	if errors.Is(err, service.ErrInvalidTrackingEvent) {
+		return BadRequestResponse(c, "Invalid tracking event", err.Error())
+	}
+	if errors.Is(err, service.ErrTrackingOverloaded) {
+		c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(trackingRetryAfter.Seconds())))
+		return ServiceUnavailableResponse(c, "Tracking is overloaded, retry later", err.Error())
+	}
 	if err != nil {
 		return InternalServerErrorResponse(c, "Failed to track event", err.Error())
 	}
@@ -39,3 +62,75 @@ func (h *TrackingHandler) TrackEvent(c *fiber.Ctx) error {
 		"success": ok,
 	})
 }
+
+// TrackBatch tracks a batch of events sent as a JSON array or as NDJSON (one event per line,
+// 'Content-Type: application/x-ndjson'). Events are validated and recorded individually,
+// the result of every event tells if it was accepted or should be sent again.
+func (h *TrackingHandler) TrackBatch(c *fiber.Ctx) error {
+	var (
+		raw []json.RawMessage
+		err error
+	)
+	if contentType := strings.ToLower(c.Get(fiber.HeaderContentType)); strings.HasPrefix(contentType, "application/x-ndjson") {
+		raw, err = splitNDJSON(c.Body())
+	} else {
+		err = json.Unmarshal(c.Body(), &raw)
+	}
+	if err != nil {
+		return BadRequestResponse(c, "Invalid request body", err.Error())
+	}
+	if len(raw) == 0 {
+		return BadRequestResponse(c, "Batch is empty", nil)
+	}
+	if len(raw) > maxTrackingBatchSize {
+		return BadRequestResponse(c, fmt.Sprintf("Too many events, at most %d are allowed", maxTrackingBatchSize), nil)
+	}
+
+	var accepted int
+	results := make([]model.TrackingEventResult, len(raw))
+	for i, data := range raw {
+		results[i] = h.trackBatchEvent(i, data)
+		if results[i].Accepted {
+			accepted++
+		}
+	}
+
+	return c.Status(fiber.StatusOK).JSON(map[string]any{
+		"accepted": accepted,
+		"rejected": len(results) - accepted,
+		"results":  results,
+	})
+}
+
+func (h *TrackingHandler) trackBatchEvent(index int, data json.RawMessage) model.TrackingEventResult {
+	result := model.TrackingEventResult{Index: index}
+
+	var event model.TrackingEvent
+	if err := json.Unmarshal(data, &event); err != nil {
+		result.Error = fmt.Sprintf("invalid event: %v", err)
+		return result
+	}
+	result.EventID = event.ID
+
+	if _, err := h.service.RecordUnsignedAdInteraction(event); err != nil {
+		result.Retry = !errors.Is(err, service.ErrInvalidTrackingEvent)
+		result.Error = err.Error()
+		return result
+	}
+	result.Accepted = true
+	return result
+}
+
+// splitNDJSON splits newline delimited JSON into values, blank lines are skipped
+func splitNDJSON(body []byte) ([]json.RawMessage, error) {
+	var values []json.RawMessage
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	scanner.Buffer(nil, len(body)+1)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) > 0 {
+			values = append(values, json.RawMessage(bytes.Clone(line)))
+		}
+	}
+	return values, scanner.Err()
+}
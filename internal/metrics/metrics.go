@@ -0,0 +1,65 @@
+// Package metrics defines Prometheus metrics of the service, they are exposed at /metrics
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const namespace = "ad_service"
+
+// TrackingEventsDuplicates counts tracking events dropped as duplicates by event type
+var TrackingEventsDuplicates = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Subsystem: "tracking",
+	Name:      "duplicate_events_total",
+	Help:      "Number of tracking events dropped as duplicates of recently accepted events.",
+}, []string{"event_type"})
+
+// TrackingEventsDropped counts tracking events rejected because the events buffer is full by reason
+var TrackingEventsDropped = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Subsystem: "tracking",
+	Name:      "dropped_events_total",
+	Help:      "Number of tracking events rejected because the events buffer is full.",
+}, []string{"reason"})
+
+// TrackingEventsSpilled counts tracking events written to the spill queue
+var TrackingEventsSpilled = promauto.NewCounter(prometheus.CounterOpts{
+	Namespace: namespace,
+	Subsystem: "tracking",
+	Name:      "spilled_events_total",
+	Help:      "Number of tracking events written to the spill queue because the events buffer is full.",
+})
+
+// TrackingFlushRetries counts retried writes of tracking events batches
+var TrackingFlushRetries = promauto.NewCounter(prometheus.CounterOpts{
+	Namespace: namespace,
+	Subsystem: "tracking",
+	Name:      "flush_retries_total",
+	Help:      "Number of retried writes of tracking events batches to the storage.",
+})
+
+// TrackingDeadLetterEvents counts tracking events put to the dead-letter store
+var TrackingDeadLetterEvents = promauto.NewCounter(prometheus.CounterOpts{
+	Namespace: namespace,
+	Subsystem: "tracking",
+	Name:      "dead_letter_events_total",
+	Help:      "Number of tracking events put to the dead-letter store after all write attempts failed.",
+})
+
+// TrackingCircuitBreakerState is the state of the tracking events storage circuit breaker
+var TrackingCircuitBreakerState = promauto.NewGauge(prometheus.GaugeOpts{
+	Namespace: namespace,
+	Subsystem: "tracking",
+	Name:      "circuit_breaker_state",
+	Help:      "State of the tracking events storage circuit breaker: 0 closed, 1 open, 2 half-open.",
+})
+
+// TrackingSinkErrors counts failed writes of tracking events batches by fan-out sink
+var TrackingSinkErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Subsystem: "tracking",
+	Name:      "sink_errors_total",
+	Help:      "Number of failed writes of tracking events batches by fan-out sink.",
+}, []string{"sink"})
@@ -0,0 +1,41 @@
+package model
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// LineItemChangeAction represents the kind of a line item change
+type LineItemChangeAction string
+
+const (
+	LineItemChangeActionCreate  LineItemChangeAction = "create"
+	LineItemChangeActionUpdate  LineItemChangeAction = "update"
+	LineItemChangeActionStatus  LineItemChangeAction = "status"
+	LineItemChangeActionRestore LineItemChangeAction = "restore"
+)
+
+// ChangeMeta describes who and within which request changes something
+type ChangeMeta struct {
+	Actor     string
+	RequestID string
+}
+
+// LineItemChange is an immutable audit record of a single line item change
+type LineItemChange struct {
+	LineItemID string               `json:"line_item_id"`
+	Version    int64                `json:"version"` // version of the line item produced by the change
+	Action     LineItemChangeAction `json:"action"`
+	Actor      string               `json:"actor,omitempty"`
+	RequestID  string               `json:"request_id,omitempty"`
+	Timestamp  time.Time            `json:"timestamp"`
+	Changes    []FieldChange        `json:"changes"`
+}
+
+// FieldChange is a change of a single line item field.
+// Values are JSON encoded the same way as in the LineItem.
+type FieldChange struct {
+	Field string          `json:"field"`
+	Old   json.RawMessage `json:"old,omitempty"`
+	New   json.RawMessage `json:"new,omitempty"`
+}
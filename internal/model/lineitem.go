@@ -1,6 +1,7 @@
 package model
 
 import (
+	"encoding/json"
 	"time"
 )
 
@@ -11,42 +12,171 @@ const (
 	LineItemStatusActive    LineItemStatus = "active"
 	LineItemStatusPaused    LineItemStatus = "paused"
 	LineItemStatusCompleted LineItemStatus = "completed"
+	LineItemStatusArchived  LineItemStatus = "archived"
+)
+
+// PacingMode defines how a line item spends its daily budget
+type PacingMode string
+
+const (
+	// PacingModeASAP takes part in every auction until the daily budget is spent
+	PacingModeASAP PacingMode = "asap"
+	// PacingModeEven spreads the daily budget evenly across the day
+	PacingModeEven PacingMode = "even"
 )
 
 // LineItem represents an advertisement with associated bid information
 type LineItem struct {
-	ID           string         `json:"id"`
-	Name         string         `json:"name"`
-	AdvertiserID string         `json:"advertiser_id"`
-	Bid          float64        `json:"bid"`
-	Budget       float64        `json:"budget"`
-	Placement    string         `json:"placement"`
-	Categories   []string       `json:"categories,omitempty"`
-	Keywords     []string       `json:"keywords,omitempty"`
-	Status       LineItemStatus `json:"status"`
-	CreatedAt    time.Time      `json:"created_at"`
-	UpdatedAt    time.Time      `json:"updated_at"`
+	ID            string         `json:"id"`
+	Name          string         `json:"name"`
+	AdvertiserID  string         `json:"advertiser_id"`
+	Bid           float64        `json:"bid"`
+	Budget        float64        `json:"budget"`
+	Placement     string         `json:"placement"`
+	Categories    []string       `json:"categories,omitempty"`
+	Keywords      []string       `json:"keywords,omitempty"`
+	LandingURL    string         `json:"landing_url,omitempty"`
+	StartAt       *time.Time     `json:"start_at,omitempty"`
+	EndAt         *time.Time     `json:"end_at,omitempty"`
+	Schedule      *Schedule      `json:"schedule,omitempty"`
+	Pacing        PacingMode     `json:"pacing"`
+	FrequencyCaps []FrequencyCap `json:"frequency_caps,omitempty"`
+	Status        LineItemStatus `json:"status"`
+	Version       int64          `json:"version"` // incremented on every change, used for optimistic concurrency
+	CreatedAt     time.Time      `json:"created_at"`
+	UpdatedAt     time.Time      `json:"updated_at"`
 }
 
 // LineItemCreate represents the data needed to create a new line item
 type LineItemCreate struct {
-	Name         string   `json:"name"`
-	AdvertiserID string   `json:"advertiser_id"`
-	Bid          float64  `json:"bid"`
-	Budget       float64  `json:"budget"`
-	Placement    string   `json:"placement"`
-	Categories   []string `json:"categories,omitempty"`
-	Keywords     []string `json:"keywords,omitempty"`
+	Name          string         `json:"name"`
+	AdvertiserID  string         `json:"advertiser_id"`
+	Bid           float64        `json:"bid"`
+	Budget        float64        `json:"budget"`
+	Placement     string         `json:"placement"`
+	Categories    []string       `json:"categories,omitempty"`
+	Keywords      []string       `json:"keywords,omitempty"`
+	LandingURL    string         `json:"landing_url,omitempty"` // clicks are redirected to it
+	StartAt       *time.Time     `json:"start_at,omitempty"`
+	EndAt         *time.Time     `json:"end_at,omitempty"`
+	Schedule      *Schedule      `json:"schedule,omitempty"`
+	Pacing        PacingMode     `json:"pacing,omitempty"` // "asap" by default
+	FrequencyCaps []FrequencyCap `json:"frequency_caps,omitempty"`
+}
+
+// LineItemUpdate represents a partial update of a line item.
+// Only non-nil fields are applied, the flight and the schedule are cleared with an explicit null.
+type LineItemUpdate struct {
+	Name          *string             `json:"name,omitempty"`
+	Bid           *float64            `json:"bid,omitempty"`
+	Budget        *float64            `json:"budget,omitempty"`
+	Placement     *string             `json:"placement,omitempty"`
+	Categories    *[]string           `json:"categories,omitempty"`
+	Keywords      *[]string           `json:"keywords,omitempty"`
+	LandingURL    *string             `json:"landing_url,omitempty"` // empty string removes the landing page
+	StartAt       Nullable[time.Time] `json:"start_at"`
+	EndAt         Nullable[time.Time] `json:"end_at"`
+	Schedule      Nullable[Schedule]  `json:"schedule"`
+	Pacing        *PacingMode         `json:"pacing,omitempty"`
+	FrequencyCaps *[]FrequencyCap     `json:"frequency_caps,omitempty"`
+}
+
+// Nullable is a field of a partial update which can be cleared with an explicit null
+type Nullable[T any] struct {
+	Set   bool // the field is present in the update
+	Value *T   // nil clears the field
+}
+
+// NullableValue returns a Nullable setting the field to the value
+func NullableValue[T any](value T) Nullable[T] {
+	return Nullable[T]{Set: true, Value: &value}
+}
+
+// UnmarshalJSON implements json.Unmarshaler, it is called for present fields only
+func (n *Nullable[T]) UnmarshalJSON(data []byte) error {
+	n.Set = true
+	n.Value = nil
+	if string(data) == "null" {
+		return nil
+	}
+	return json.Unmarshal(data, &n.Value)
+}
+
+// MarshalJSON implements json.Marshaler, a field which is not set is encoded as null as well
+func (n Nullable[T]) MarshalJSON() ([]byte, error) {
+	return json.Marshal(n.Value)
+}
+
+// Schedule restricts serving of a line item to days of week and hours of day (dayparting)
+type Schedule struct {
+	// Timezone is an IANA time zone name days and hours are evaluated in, UTC by default
+	Timezone string `json:"timezone,omitempty"`
+	// Days are lowercase short weekday names: "mon", "tue", ..., "sun". Empty means every day.
+	Days []string `json:"days,omitempty"`
+	// Hours are hours of day in the range [0-23]. Empty means the whole day.
+	Hours []int `json:"hours,omitempty"`
+}
+
+// FrequencyCap limits the number of impressions of a line item shown to the same user within a time window.
+// Windows are fixed intervals aligned to the Unix epoch, e.g. a day window starts at midnight UTC.
+type FrequencyCap struct {
+	Impressions   int   `json:"impressions"`    // at most this many impressions per user in a window
+	WindowSeconds int64 `json:"window_seconds"` // length of the window
+}
+
+// PacingState describes the budget pacing of a line item at the moment
+type PacingState struct {
+	LineItemID  string     `json:"line_item_id"`
+	Mode        PacingMode `json:"mode"`
+	Budget      float64    `json:"budget"`
+	Spend       float64    `json:"spend"`        // spent today
+	TargetSpend float64    `json:"target_spend"` // expected to be spent by now
+	DayElapsed  float64    `json:"day_elapsed"`  // elapsed fraction of the budget day
+	Throttled   bool       `json:"throttled"`    // the line item doesn't take part in auctions
+}
+
+// MatchMode defines how several requested categories or keywords match line items
+type MatchMode string
+
+const (
+	// MatchAny matches line items targeting at least one of the values
+	MatchAny MatchMode = "any"
+	// MatchAll matches line items targeting all the values
+	MatchAll MatchMode = "all"
+)
+
+// TargetingQuery selects active line items of a placement by their targeting.
+// Empty categories or keywords don't restrict the selection,
+// otherwise both categories and keywords must match according to the match mode.
+type TargetingQuery struct {
+	Placement  string
+	Categories []string
+	Keywords   []string
+	Match      MatchMode // MatchAny by default
+}
+
+// AdRequest represents a request for ads of a placement
+type AdRequest struct {
+	TargetingQuery
+	Limit  int
+	UserID string // optional, frequency caps apply only to requests of known users
 }
 
 // Ad represents an advertisement ready to be served
 type Ad struct {
-	ID           string  `json:"id"`
-	Name         string  `json:"name"`
-	AdvertiserID string  `json:"advertiser_id"`
-	Bid          float64 `json:"bid"`
-	Placement    string  `json:"placement"`
-	ServeURL     string  `json:"serve_url"`
+	ID            string  `json:"id"`
+	Name          string  `json:"name"`
+	AdvertiserID  string  `json:"advertiser_id"`
+	Bid           float64 `json:"bid"`
+	ClearingPrice float64 `json:"clearing_price"` // price (CPM) the advertiser pays for an impression
+	Relevance     float64 `json:"relevance"`      // relevance of the ad to the request in the range (0-1]
+	MatchedTerms  int     `json:"matched_terms"`  // number of requested categories and keywords targeted by the ad
+	Score         float64 `json:"score"`          // rank of the ad in the auction, combines bid and relevance
+	Placement     string  `json:"placement"`
+	AuctionID     string  `json:"auction_id"`     // the auction the ad won, shared by all ads of a response
+	ServeURL      string  `json:"serve_url"`      // signed URL of the served ad
+	ImpressionURL string  `json:"impression_url"` // signed impression pixel, requested when the ad is rendered
+	ClickURL      string  `json:"click_url"`      // signed click redirect, the ad links to it
 }
 
 // TrackingEventType represents the type of tracking event
@@ -60,10 +190,22 @@ const (
 
 // TrackingEvent represents a user interaction with an ad
 type TrackingEvent struct {
-	EventType  TrackingEventType `json:"event_type"`
-	LineItemID string            `json:"line_item_id"`
-	Timestamp  time.Time         `json:"timestamp,omitempty"`
-	Placement  string            `json:"placement,omitempty"`
-	UserID     string            `json:"user_id,omitempty"`
-	Metadata   map[string]string `json:"metadata,omitempty"`
+	ID            string            `json:"event_id,omitempty"` // idempotency key, retries of an event carry the same ID
+	EventType     TrackingEventType `json:"event_type"`
+	LineItemID    string            `json:"line_item_id"`
+	Timestamp     time.Time         `json:"timestamp,omitempty"`
+	Placement     string            `json:"placement,omitempty"`
+	UserID        string            `json:"user_id,omitempty"`
+	AuctionID     string            `json:"auction_id,omitempty"`
+	ClearingPrice float64           `json:"clearing_price,omitempty"` // price (CPM) of the served ad
+	Metadata      map[string]string `json:"metadata,omitempty"`
+}
+
+// TrackingEventResult is the outcome of an event of a tracking batch
+type TrackingEventResult struct {
+	Index    int    `json:"index"` // position of the event in the batch
+	EventID  string `json:"event_id,omitempty"`
+	Accepted bool   `json:"accepted"`
+	Retry    bool   `json:"retry,omitempty"` // the event is rejected temporarily and should be sent again
+	Error    string `json:"error,omitempty"`
 }
@@ -2,52 +2,171 @@ package service
 
 import (
 	"fmt"
-	"sort"
+	"math"
+	"slices"
 	"sweng-task/internal/model"
 
+	"github.com/google/uuid"
 	"go.uber.org/zap"
 )
 
 // AdService provides operations for ads
 type AdService struct {
 	lineItemsService *LineItemService
+	filters          []LineItemFilter
+	frequencyCaps    *FrequencyCapService
+	auction          Auction
+	diversity        *Diversity
+	scorer           Scorer
+	urls             *AdURLs
+	relevanceWeight  float64
 	log              *zap.SugaredLogger
 }
 
+// LineItemFilter decides if a matching line item may take part in the auction
+type LineItemFilter interface {
+	Allow(item *model.LineItem) bool
+}
+
+// AdServiceOption configures optional parts of AdService
+type AdServiceOption func(*AdService)
+
+// WithLineItemFilter excludes line items rejected by the filter from the winning ads
+func WithLineItemFilter(filter LineItemFilter) AdServiceOption {
+	return func(s *AdService) {
+		s.filters = append(s.filters, filter)
+	}
+}
+
+// WithFrequencyCaps excludes line items which have reached their frequency caps for the requesting user
+func WithFrequencyCaps(frequencyCaps *FrequencyCapService) AdServiceOption {
+	return func(s *AdService) {
+		s.frequencyCaps = frequencyCaps
+	}
+}
+
+// WithAuction sets the auction selecting winning ads, second-price auction without floors is used by default
+func WithAuction(auction Auction) AdServiceOption {
+	return func(s *AdService) {
+		s.auction = auction
+	}
+}
+
+// WithDiversity limits ads of the same advertiser and of competitors in one response
+func WithDiversity(diversity Diversity) AdServiceOption {
+	return func(s *AdService) {
+		s.diversity = &diversity
+	}
+}
+
+// WithAdURLs adds signed serve, impression and click URLs to ads, without it the URLs are empty
+func WithAdURLs(urls AdURLs) AdServiceOption {
+	return func(s *AdService) {
+		s.urls = &urls
+	}
+}
+
+// WithScorer ranks ads by bid × relevance^weight, so weight 0 ranks by bids only
+// and greater weights prefer relevance over bids. Without a scorer all ads are equally relevant.
+func WithScorer(scorer Scorer, weight float64) AdServiceOption {
+	return func(s *AdService) {
+		s.scorer = scorer
+		s.relevanceWeight = weight
+	}
+}
+
 // NewAdService creates a new AdService
-func NewAdService(lineItemsService *LineItemService, log *zap.SugaredLogger) *AdService {
-	return &AdService{
+func NewAdService(lineItemsService *LineItemService, log *zap.SugaredLogger, opts ...AdServiceOption) *AdService {
+	s := &AdService{
 		lineItemsService: lineItemsService,
+		auction:          Auction{Type: AuctionTypeSecondPrice},
 		log:              log,
 	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
 }
 
 // GetWinningAds returns winning ads
-func (s *AdService) GetWinningAds(placement string, category string, keyword string, limit int) ([]model.Ad, error) {
-	// for better optimization we can add sorting inside of this method
-	items, err := s.lineItemsService.FindMatchingLineItems(placement, category, keyword)
+func (s *AdService) GetWinningAds(request model.AdRequest) ([]model.Ad, error) {
+	items, err := s.lineItemsService.FindMatchingLineItems(request.TargetingQuery)
 	if err != nil {
 		return nil, fmt.Errorf("find matching line items: %w", err)
 	}
+	items = slices.DeleteFunc(items, func(item *model.LineItem) bool { return !s.allow(request, item) })
 
-	// reversed sorting - from biggest bid to lowest
-	// it is based on the assumption that we would like to prioritise ads with higher bid
-	sort.Slice(items, func(i, j int) bool { return items[i].Bid >= items[j].Bid })
+	bidders := make([]Bidder, len(items))
+	relevance := make(map[string]float64, len(items))
+	for i, item := range items {
+		relevance[item.ID] = s.relevance(request, item)
+		bidders[i] = Bidder{LineItem: item, Quality: math.Pow(relevance[item.ID], s.relevanceWeight)}
+	}
 
-	if len(items) > limit {
-		items = items[:limit]
+	ranked := s.auction.Rank(request.Placement, bidders)
+	if s.diversity != nil {
+		ranked = s.diversity.Apply(ranked, request.Limit)
 	}
+	winners := s.auction.Price(request.Placement, ranked, request.Limit)
+	auctionID := "auc_" + uuid.New().String()
 
-	ads := make([]model.Ad, len(items))
-	for i, item := range items {
+	keywords := requestKeywords(request.Keywords)
+	ads := make([]model.Ad, len(winners))
+	for i, winner := range winners {
+		item := winner.LineItem
 		ads[i] = model.Ad{
-			ID:           item.ID,
-			Name:         item.Name,
-			AdvertiserID: item.AdvertiserID,
-			Bid:          item.Bid,
-			Placement:    item.Placement,
-			ServeURL:     "", // TODO: add serve URL
+			ID:            item.ID,
+			Name:          item.Name,
+			AdvertiserID:  item.AdvertiserID,
+			Bid:           item.Bid,
+			ClearingPrice: winner.ClearingPrice,
+			Relevance:     relevance[item.ID],
+			MatchedTerms:  s.matchedTerms(request.TargetingQuery, keywords, item),
+			Score:         winner.Score(),
+			Placement:     item.Placement,
+			AuctionID:     auctionID,
+		}
+		if s.urls != nil {
+			if err := s.urls.set(&ads[i], request.UserID); err != nil {
+				return nil, fmt.Errorf("sign ad urls: %w", err)
+			}
 		}
 	}
 	return ads, nil
 }
+
+// relevance returns the relevance of a line item to the request
+func (s *AdService) relevance(request model.AdRequest, item *model.LineItem) float64 {
+	if s.scorer == nil {
+		return 1
+	}
+	return s.scorer.Score(request, item)
+}
+
+// allow reports if a line item passes all the filters and frequency caps of the requesting user
+func (s *AdService) allow(request model.AdRequest, item *model.LineItem) bool {
+	for _, filter := range s.filters {
+		if !filter.Allow(item) {
+			return false
+		}
+	}
+	return s.frequencyCaps == nil || s.frequencyCaps.Allow(request.UserID, item)
+}
+
+// matchedTerms returns the number of requested categories and keywords targeted by a line item,
+// 'keywords' are the normalized keywords of the query
+func (s *AdService) matchedTerms(query model.TargetingQuery, keywords []RequestKeyword, item *model.LineItem) int {
+	var matched int
+	for _, category := range query.Categories {
+		if slices.Contains(item.Categories, category) {
+			matched++
+		}
+	}
+	parsed := s.lineItemsService.keywords.get(item)
+	for _, keyword := range keywords {
+		if matchKeywords(parsed, []RequestKeyword{keyword}, model.MatchAny) {
+			matched++
+		}
+	}
+	return matched
+}
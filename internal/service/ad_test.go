@@ -1,8 +1,12 @@
 package service
 
 import (
+	"maps"
+	"net/url"
 	"sweng-task/internal/model"
+	"sweng-task/internal/signing"
 	"testing"
+	"time"
 
 	"go.uber.org/zap"
 )
@@ -12,7 +16,7 @@ func TestAdService_GetWinningAds_Sorting(t *testing.T) {
 	category := "toys"
 	keyword := "summer"
 
-	lineItemsService := NewLineItemService(zap.NewNop().Sugar())
+	lineItemsService := NewLineItemService(NewMemoryLineItemRepository(), NewMemoryLineItemHistory(), SystemClock{}, zap.NewNop().Sugar())
 	adService := NewAdService(lineItemsService, zap.NewNop().Sugar())
 
 	_, err := lineItemsService.Create(model.LineItemCreate{
@@ -23,7 +27,7 @@ func TestAdService_GetWinningAds_Sorting(t *testing.T) {
 		Placement:    placement,
 		Categories:   []string{category},
 		Keywords:     []string{keyword},
-	})
+	}, model.ChangeMeta{})
 	if err != nil {
 		t.Errorf("Create line item: %v", err)
 	}
@@ -35,7 +39,7 @@ func TestAdService_GetWinningAds_Sorting(t *testing.T) {
 		Placement:    placement,
 		Categories:   []string{category},
 		Keywords:     []string{keyword},
-	})
+	}, model.ChangeMeta{})
 	if err != nil {
 		t.Errorf("Create line item: %v", err)
 	}
@@ -47,12 +51,15 @@ func TestAdService_GetWinningAds_Sorting(t *testing.T) {
 		Placement:    placement,
 		Categories:   []string{category},
 		Keywords:     []string{keyword},
-	})
+	}, model.ChangeMeta{})
 	if err != nil {
 		t.Errorf("Create line item: %v", err)
 	}
 
-	ads, err := adService.GetWinningAds(placement, category, keyword, 2)
+	ads, err := adService.GetWinningAds(model.AdRequest{
+		TargetingQuery: model.TargetingQuery{Placement: placement, Categories: []string{category}, Keywords: []string{keyword}},
+		Limit:          2,
+	})
 	if err != nil {
 		t.Errorf("Create line item: %v", err)
 	}
@@ -68,3 +75,142 @@ func TestAdService_GetWinningAds_Sorting(t *testing.T) {
 		t.Errorf("Wrong second winning ad: %v.Name != 'test_1'", ads[1])
 	}
 }
+
+func TestAdService_GetWinningAds_ClearingPrice(t *testing.T) {
+	lineItemsService := NewLineItemService(NewMemoryLineItemRepository(), NewMemoryLineItemHistory(), SystemClock{}, zap.NewNop().Sugar())
+	adService := NewAdService(lineItemsService, zap.NewNop().Sugar(), WithAuction(Auction{
+		Type:   AuctionTypeSecondPrice,
+		Floors: map[string]float64{"header": 1.5},
+	}))
+
+	for _, bid := range []float64{1, 2, 3} {
+		_, err := lineItemsService.Create(model.LineItemCreate{Name: "test", Bid: bid, Budget: 1000, Placement: "header"}, model.ChangeMeta{})
+		if err != nil {
+			t.Fatalf("Create line item: %v", err)
+		}
+	}
+
+	ads, err := adService.GetWinningAds(model.AdRequest{TargetingQuery: model.TargetingQuery{Placement: "header"}, Limit: 3})
+	if err != nil {
+		t.Fatalf("Get winning ads: %v", err)
+	}
+	if len(ads) != 2 {
+		t.Fatalf("Line item below the floor must not win: %d != 2", len(ads))
+	}
+	if ads[0].Bid != 3 || ads[0].ClearingPrice != 2 {
+		t.Errorf("Wrong first winning ad: %+v", ads[0])
+	}
+	if ads[1].Bid != 2 || ads[1].ClearingPrice != 1.5 {
+		t.Errorf("Wrong second winning ad: %+v", ads[1])
+	}
+}
+
+func TestAdService_GetWinningAds_MatchedTerms(t *testing.T) {
+	lineItemsService := NewLineItemService(NewMemoryLineItemRepository(), NewMemoryLineItemHistory(), SystemClock{}, zap.NewNop().Sugar())
+	adService := NewAdService(lineItemsService, zap.NewNop().Sugar())
+
+	_, err := lineItemsService.Create(model.LineItemCreate{Name: "test_1", Bid: 1, Budget: 100, Placement: "header", Categories: []string{"toys"}, Keywords: []string{"lego", "summer"}}, model.ChangeMeta{})
+	if err != nil {
+		t.Fatalf("Create line item: %v", err)
+	}
+	_, err = lineItemsService.Create(model.LineItemCreate{Name: "test_2", Bid: 2, Budget: 100, Placement: "header", Categories: []string{"books"}, Keywords: []string{"summer"}}, model.ChangeMeta{})
+	if err != nil {
+		t.Fatalf("Create line item: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		match      model.MatchMode
+		categories []string
+		want       map[string]int // matched terms by name
+	}{
+		{"any", model.MatchAny, []string{"toys", "books"}, map[string]int{"test_1": 3, "test_2": 2}},
+		{"all", model.MatchAll, []string{"toys"}, map[string]int{"test_1": 3}},
+		{"all categories", model.MatchAll, []string{"toys", "books"}, map[string]int{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ads, err := adService.GetWinningAds(model.AdRequest{
+				TargetingQuery: model.TargetingQuery{
+					Placement:  "header",
+					Categories: tt.categories,
+					Keywords:   []string{"lego", "summer"},
+					Match:      tt.match,
+				},
+				Limit: 10,
+			})
+			if err != nil {
+				t.Fatalf("Get winning ads: %v", err)
+			}
+
+			got := make(map[string]int)
+			for _, ad := range ads {
+				got[ad.Name] = ad.MatchedTerms
+			}
+			if !maps.Equal(got, tt.want) {
+				t.Errorf("Wrong matched terms: %v != %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAdService_GetWinningAds_URLs(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2025, 6, 2, 10, 0, 0, 0, time.UTC)}
+	signer, err := signing.NewSigner("k1", []signing.Key{{ID: "k1", Secret: []byte("0123456789abcdef")}})
+	if err != nil {
+		t.Fatalf("Create signer: %v", err)
+	}
+
+	lineItemsService := NewLineItemService(NewMemoryLineItemRepository(), NewMemoryLineItemHistory(), clock, zap.NewNop().Sugar())
+	adService := NewAdService(lineItemsService, zap.NewNop().Sugar(), WithAdURLs(AdURLs{
+		BaseURL: "https://ads.example.com/",
+		Signer:  signer,
+		TTL:     time.Hour,
+		Clock:   clock,
+	}))
+
+	for _, bid := range []float64{3, 2} {
+		if _, err := lineItemsService.Create(model.LineItemCreate{Name: "test", Bid: bid, Budget: 100, Placement: "header"}, model.ChangeMeta{}); err != nil {
+			t.Fatalf("Create line item: %v", err)
+		}
+	}
+
+	ads, err := adService.GetWinningAds(model.AdRequest{TargetingQuery: model.TargetingQuery{Placement: "header"}, Limit: 2, UserID: "user_1"})
+	if err != nil {
+		t.Fatalf("Get winning ads: %v", err)
+	}
+	if len(ads) != 2 {
+		t.Fatalf("Wrong amount of winning ads: %d != 2", len(ads))
+	}
+	if ads[0].AuctionID == "" || ads[0].AuctionID != ads[1].AuctionID {
+		t.Errorf("Ads of a response must share the auction ID: %q, %q", ads[0].AuctionID, ads[1].AuctionID)
+	}
+
+	for _, ad := range ads {
+		for path, raw := range map[string]string{ServePath: ad.ServeURL, ImpressionPath: ad.ImpressionURL, ClickPath: ad.ClickURL} {
+			u, err := url.Parse(raw)
+			if err != nil {
+				t.Fatalf("Parse URL: %v", err)
+			}
+			if u.Host != "ads.example.com" || u.Path != path {
+				t.Errorf("Wrong URL: %s", raw)
+			}
+
+			claims, err := signer.Verify(u.Query().Get("t"), clock.now)
+			if err != nil {
+				t.Fatalf("Verify token: %v", err)
+			}
+			want := signing.Claims{
+				LineItemID:    ad.ID,
+				Placement:     "header",
+				AuctionID:     ad.AuctionID,
+				ClearingPrice: ad.ClearingPrice,
+				UserID:        "user_1",
+				ExpiresAt:     claims.ExpiresAt,
+			}
+			if claims != want || !claims.ExpiresAt.Equal(clock.now.Add(time.Hour)) {
+				t.Errorf("Wrong claims: %+v", claims)
+			}
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package service
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"sweng-task/internal/model"
+	"sweng-task/internal/signing"
+)
+
+// Paths of the URLs of served ads, the signed token is passed in the 't' query parameter
+const (
+	ServePath      = "/t/serve"
+	ImpressionPath = "/t/imp"
+	ClickPath      = "/t/click"
+)
+
+// AdURLs builds URLs of served ads carrying signed tokens,
+// so the tracking side trusts the line item, the auction and the clearing price coming back from clients
+type AdURLs struct {
+	BaseURL string // public URL of the service, e.g. "https://ads.example.com"
+	Signer  *signing.Signer
+	TTL     time.Duration // lifetime of the tokens
+	Clock   Clock
+}
+
+// set signs the ad and sets its URLs
+func (u *AdURLs) set(ad *model.Ad, userID string) error {
+	token, err := u.Signer.Sign(signing.Claims{
+		LineItemID:    ad.ID,
+		Placement:     ad.Placement,
+		AuctionID:     ad.AuctionID,
+		ClearingPrice: ad.ClearingPrice,
+		UserID:        userID,
+		ExpiresAt:     u.Clock.Now().Add(u.TTL),
+	})
+	if err != nil {
+		return err
+	}
+
+	ad.ServeURL = u.url(ServePath, token)
+	ad.ImpressionURL = u.url(ImpressionPath, token)
+	ad.ClickURL = u.url(ClickPath, token)
+	return nil
+}
+
+func (u *AdURLs) url(path, token string) string {
+	return fmt.Sprintf("%s%s?t=%s", strings.TrimSuffix(u.BaseURL, "/"), path, url.QueryEscape(token))
+}
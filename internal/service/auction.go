@@ -0,0 +1,109 @@
+package service
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strings"
+
+	"sweng-task/internal/model"
+)
+
+// AuctionType defines how clearing prices of auction winners are computed
+type AuctionType string
+
+const (
+	// AuctionTypeFirstPrice makes winners pay their own bids
+	AuctionTypeFirstPrice AuctionType = "first_price"
+	// AuctionTypeSecondPrice is the generalized second-price auction,
+	// every winner pays the minimal bid which would keep its slot or the floor price
+	AuctionTypeSecondPrice AuctionType = "second_price"
+)
+
+// Auction ranks line items competing for slots of a placement and computes clearing prices of the winners.
+// Bidders are ranked by their scores, bids weighted by quality (relevance).
+// Line items bidding below the floor price of the placement don't take part.
+type Auction struct {
+	Type AuctionType
+	// Floors are reserve prices (CPM) by placement, placements without a floor have no reserve price
+	Floors map[string]float64
+}
+
+// Bidder is a line item taking part in an auction
+type Bidder struct {
+	LineItem *model.LineItem
+	Quality  float64 // positive multiplier of the bid, 1 ranks by bids only
+}
+
+// Score returns the rank score of the bidder
+func (b Bidder) Score() float64 {
+	return b.LineItem.Bid * b.Quality
+}
+
+// AuctionWinner is a bidder which won a slot
+type AuctionWinner struct {
+	Bidder
+	ClearingPrice float64
+}
+
+// Validate validates the auction configuration
+func (a Auction) Validate() error {
+	if a.Type != AuctionTypeFirstPrice && a.Type != AuctionTypeSecondPrice {
+		return fmt.Errorf("unknown auction type %q, expected one of: first_price, second_price", a.Type)
+	}
+	for placement, floor := range a.Floors {
+		if floor < 0 {
+			return fmt.Errorf("floor price of placement %q must not be negative", placement)
+		}
+	}
+	return nil
+}
+
+// Run runs an auction for up to 'slots' slots of a placement, winners are ordered by slots.
+// In the second-price auction a winner pays the minimal bid which would keep its slot:
+// the score of the next bidder divided by the winner's quality, but not less than the floor.
+func (a Auction) Run(placement string, bidders []Bidder, slots int) []AuctionWinner {
+	return a.Price(placement, a.Rank(placement, bidders), slots)
+}
+
+// Rank orders bidders by their scores and drops the ones bidding below the floor of the placement.
+// Ties are broken by line item IDs, so the ranking is deterministic: of two tied line items
+// the one with the lower ID always wins. IDs are random UUIDs, so which one that is doesn't depend on the line items.
+func (a Auction) Rank(placement string, bidders []Bidder) []Bidder {
+	floor := a.Floors[placement]
+
+	ranked := make([]Bidder, 0, len(bidders))
+	for _, bidder := range bidders {
+		if bidder.LineItem.Bid >= floor {
+			ranked = append(ranked, bidder)
+		}
+	}
+	slices.SortFunc(ranked, func(x, y Bidder) int {
+		return cmp.Or(cmp.Compare(y.Score(), x.Score()), strings.Compare(x.LineItem.ID, y.LineItem.ID))
+	})
+	return ranked
+}
+
+// Price assigns up to 'slots' slots to ranked bidders and computes their clearing prices,
+// a bidder following the last winner only sets its price
+func (a Auction) Price(placement string, ranked []Bidder, slots int) []AuctionWinner {
+	floor := a.Floors[placement]
+
+	winners := make([]AuctionWinner, 0, min(slots, len(ranked)))
+	for i, bidder := range ranked[:min(slots, len(ranked))] {
+		price := bidder.LineItem.Bid
+		if a.Type == AuctionTypeSecondPrice {
+			price = floor
+			if i+1 < len(ranked) {
+				// the next score never exceeds the own one, min only guards against rounding
+				price = max(price, min(ranked[i+1].Score()/bidder.Quality, bidder.LineItem.Bid))
+			}
+		}
+
+		winners = append(winners, AuctionWinner{
+			Bidder:        bidder,
+			ClearingPrice: price,
+		})
+	}
+	return winners
+}
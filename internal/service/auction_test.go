@@ -0,0 +1,78 @@
+package service
+
+import (
+	"slices"
+	"testing"
+
+	"sweng-task/internal/model"
+)
+
+func TestAuction_Run(t *testing.T) {
+	bidders := func(bids ...float64) []Bidder {
+		result := make([]Bidder, len(bids))
+		for i, bid := range bids {
+			result[i] = Bidder{LineItem: &model.LineItem{ID: "li_" + string(rune('a'+i)), Bid: bid}, Quality: 1}
+		}
+		return result
+	}
+	floors := map[string]float64{"header": 1.5}
+
+	tests := []struct {
+		name       string
+		auction    Auction
+		placement  string
+		bidders    []Bidder
+		slots      int
+		wantIDs    []string
+		wantPrices []float64
+	}{
+		{"no bidders", Auction{Type: AuctionTypeSecondPrice}, "header", nil, 1, []string{}, []float64{}},
+		{"first price", Auction{Type: AuctionTypeFirstPrice}, "header", bidders(2, 3, 1), 2, []string{"li_b", "li_a"}, []float64{3, 2}},
+		{"second price", Auction{Type: AuctionTypeSecondPrice}, "header", bidders(2, 3, 1), 2, []string{"li_b", "li_a"}, []float64{2, 1}},
+		{"second price single bidder", Auction{Type: AuctionTypeSecondPrice}, "header", bidders(2), 1, []string{"li_a"}, []float64{0}},
+		{"second price single bidder with floor", Auction{Type: AuctionTypeSecondPrice, Floors: floors}, "header", bidders(2), 1, []string{"li_a"}, []float64{1.5}},
+		{"first price single bidder with floor", Auction{Type: AuctionTypeFirstPrice, Floors: floors}, "header", bidders(2), 1, []string{"li_a"}, []float64{2}},
+		{"last slot pays floor", Auction{Type: AuctionTypeSecondPrice, Floors: floors}, "header", bidders(2, 3), 2, []string{"li_b", "li_a"}, []float64{2, 1.5}},
+		{"below floor", Auction{Type: AuctionTypeSecondPrice, Floors: floors}, "header", bidders(1, 1.4), 1, []string{}, []float64{}},
+		{"below floor doesn't set price", Auction{Type: AuctionTypeSecondPrice, Floors: floors}, "header", bidders(2, 1), 1, []string{"li_a"}, []float64{1.5}},
+		{"at floor", Auction{Type: AuctionTypeSecondPrice, Floors: floors}, "header", bidders(1.5), 1, []string{"li_a"}, []float64{1.5}},
+		{"floor of another placement", Auction{Type: AuctionTypeSecondPrice, Floors: floors}, "sidebar", bidders(1), 1, []string{"li_a"}, []float64{0}},
+		{"tie second price", Auction{Type: AuctionTypeSecondPrice}, "header", bidders(2, 2, 1), 1, []string{"li_a"}, []float64{2}},
+		{"tie broken by id", Auction{Type: AuctionTypeFirstPrice}, "header", []Bidder{{&model.LineItem{ID: "li_z", Bid: 2}, 1}, {&model.LineItem{ID: "li_y", Bid: 2}, 1}}, 2, []string{"li_y", "li_z"}, []float64{2, 2}},
+		{"quality ranks", Auction{Type: AuctionTypeFirstPrice}, "header", []Bidder{{&model.LineItem{ID: "li_a", Bid: 3}, 0.5}, {&model.LineItem{ID: "li_b", Bid: 2}, 1}}, 2, []string{"li_b", "li_a"}, []float64{2, 3}},
+		// li_b keeps the slot while its score is above 1.5, so it pays 1.5/1; li_a pays the floor
+		{"quality second price", Auction{Type: AuctionTypeSecondPrice}, "header", []Bidder{{&model.LineItem{ID: "li_a", Bid: 3}, 0.5}, {&model.LineItem{ID: "li_b", Bid: 2}, 1}}, 2, []string{"li_b", "li_a"}, []float64{1.5, 0}},
+		{"quality price", Auction{Type: AuctionTypeSecondPrice}, "header", []Bidder{{&model.LineItem{ID: "li_a", Bid: 3}, 1}, {&model.LineItem{ID: "li_b", Bid: 4}, 0.5}}, 1, []string{"li_a"}, []float64{2}},
+		{"more slots than bidders", Auction{Type: AuctionTypeSecondPrice}, "header", bidders(1, 2), 5, []string{"li_b", "li_a"}, []float64{1, 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			winners := tt.auction.Run(tt.placement, tt.bidders, tt.slots)
+
+			ids := make([]string, len(winners))
+			prices := make([]float64, len(winners))
+			for i, winner := range winners {
+				ids[i] = winner.LineItem.ID
+				prices[i] = winner.ClearingPrice
+			}
+			if !slices.Equal(ids, tt.wantIDs) {
+				t.Errorf("Wrong winners: %v != %v", ids, tt.wantIDs)
+			}
+			if !slices.Equal(prices, tt.wantPrices) {
+				t.Errorf("Wrong clearing prices: %v != %v", prices, tt.wantPrices)
+			}
+		})
+	}
+}
+
+func TestAuction_Validate(t *testing.T) {
+	if err := (Auction{Type: AuctionTypeFirstPrice}).Validate(); err != nil {
+		t.Errorf("Valid auction: %v", err)
+	}
+	if err := (Auction{Type: "vickrey"}).Validate(); err == nil {
+		t.Errorf("Unknown auction type must be rejected")
+	}
+	if err := (Auction{Type: AuctionTypeSecondPrice, Floors: map[string]float64{"header": -1}}).Validate(); err == nil {
+		t.Errorf("Negative floor must be rejected")
+	}
+}
@@ -0,0 +1,101 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"sweng-task/internal/metrics"
+	"sweng-task/internal/model"
+)
+
+// ErrCircuitOpen is returned by CircuitBreaker while the storage is considered unavailable
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+// CircuitBreaker protects a tracking events storage which keeps failing.
+// After 'failureThreshold' consecutive failures writes fail fast with ErrCircuitOpen for 'openTimeout',
+// then a single write probes the storage: a success closes the circuit, a failure opens it again.
+type CircuitBreaker struct {
+	storage          TrackingEventsStorage
+	clock            Clock
+	failureThreshold int
+	openTimeout      time.Duration
+
+	mu       sync.Mutex
+	state    breakerState
+	failures int
+	openedAt time.Time
+}
+
+// NewCircuitBreaker creates a new CircuitBreaker around a storage
+func NewCircuitBreaker(storage TrackingEventsStorage, clock Clock, failureThreshold int, openTimeout time.Duration) (*CircuitBreaker, error) {
+	if failureThreshold < 1 || openTimeout <= 0 {
+		return nil, fmt.Errorf("failure threshold and open timeout must be positive")
+	}
+	return &CircuitBreaker{
+		storage:          storage,
+		clock:            clock,
+		failureThreshold: failureThreshold,
+		openTimeout:      openTimeout,
+	}, nil
+}
+
+// Write writes events to the storage unless the circuit is open, implements TrackingEventsStorage
+func (b *CircuitBreaker) Write(ctx context.Context, events []model.TrackingEvent) error {
+	if err := b.acquire(); err != nil {
+		return err
+	}
+	err := b.storage.Write(ctx, events)
+	b.release(err)
+	return err
+}
+
+// acquire allows a write, in the half-open state only the probing write is allowed
+func (b *CircuitBreaker) acquire() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case breakerOpen:
+		if b.clock.Now().Sub(b.openedAt) < b.openTimeout {
+			return ErrCircuitOpen
+		}
+		b.setState(breakerHalfOpen)
+		return nil
+	case breakerHalfOpen:
+		return fmt.Errorf("%w: storage is being probed", ErrCircuitOpen)
+	default:
+		return nil
+	}
+}
+
+func (b *CircuitBreaker) release(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err == nil {
+		b.failures = 0
+		b.setState(breakerClosed)
+		return
+	}
+	b.failures++
+	if b.state == breakerHalfOpen || b.failures >= b.failureThreshold {
+		b.openedAt = b.clock.Now()
+		b.setState(breakerOpen)
+	}
+}
+
+func (b *CircuitBreaker) setState(state breakerState) {
+	b.state = state
+	metrics.TrackingCircuitBreakerState.Set(float64(state))
+}
@@ -0,0 +1,64 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"sweng-task/internal/model"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2025, 6, 2, 10, 0, 0, 0, time.UTC)}
+	errUnavailable := errors.New("storage is unavailable")
+	var (
+		writes  int
+		failing = true
+	)
+	storage := TrackingEventsStorageFunc(func(context.Context, []model.TrackingEvent) error {
+		writes++
+		if failing {
+			return errUnavailable
+		}
+		return nil
+	})
+	breaker, err := NewCircuitBreaker(storage, clock, 3, time.Minute)
+	if err != nil {
+		t.Fatalf("Create circuit breaker: %v", err)
+	}
+
+	for range 3 {
+		if err := breaker.Write(t.Context(), nil); !errors.Is(err, errUnavailable) {
+			t.Fatalf("Write must fail with the storage error: %v", err)
+		}
+	}
+
+	// the circuit is open, writes fail fast
+	if err := breaker.Write(t.Context(), nil); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("Circuit must be open: %v", err)
+	}
+	if writes != 3 {
+		t.Errorf("Storage must not be called while the circuit is open: %d writes", writes)
+	}
+
+	// a failed probe opens the circuit again
+	clock.now = clock.now.Add(time.Minute)
+	if err := breaker.Write(t.Context(), nil); !errors.Is(err, errUnavailable) {
+		t.Errorf("Probe must reach the storage: %v", err)
+	}
+	if err := breaker.Write(t.Context(), nil); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("Circuit must be open after a failed probe: %v", err)
+	}
+
+	// a successful probe closes it
+	clock.now = clock.now.Add(time.Minute)
+	failing = false
+	if err := breaker.Write(t.Context(), nil); err != nil {
+		t.Errorf("Probe must succeed: %v", err)
+	}
+	failing = true
+	if err := breaker.Write(t.Context(), nil); !errors.Is(err, errUnavailable) {
+		t.Errorf("Circuit must be closed: %v", err)
+	}
+}
@@ -0,0 +1,220 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"sync"
+	"time"
+
+	"sweng-task/internal/model"
+
+	"go.uber.org/zap"
+)
+
+// SpendStore persists the daily spend ledger of line items, so budgets are not reset by restarts.
+// Days are dates in the budget time zone, e.g. "2025-06-02".
+type SpendStore interface {
+	// LoadSpend returns the spend of line items on a day
+	LoadSpend(day string) (map[string]float64, error)
+	// AddSpend adds amounts to the spend of line items on a day, spends of earlier days are removed
+	AddSpend(day string, amounts map[string]float64) error
+}
+
+// BudgetService keeps the daily spend ledger of line items and enforces their daily budgets.
+// Prices are CPM, so every impression costs its clearing price divided by 1000.
+// The ledger is reset at midnight in the configured time zone. Charges are kept in memory
+// and added to the store by Persist, the spend of the day is loaded from the store once the day starts.
+type BudgetService struct {
+	lineItems *LineItemService
+	store     SpendStore
+	clock     Clock
+	location  *time.Location
+
+	mu      sync.Mutex
+	day     time.Time // midnight of the current ledger day
+	spend   map[string]float64
+	pending map[string]float64 // charges which are not persisted yet
+
+	log *zap.SugaredLogger
+}
+
+// NewBudgetService creates a new BudgetService
+func NewBudgetService(lineItems *LineItemService, store SpendStore, clock Clock, location *time.Location, log *zap.SugaredLogger) *BudgetService {
+	return &BudgetService{
+		lineItems: lineItems,
+		store:     store,
+		clock:     clock,
+		location:  location,
+		spend:     make(map[string]float64),
+		pending:   make(map[string]float64),
+		log:       log,
+	}
+}
+
+// OnTrackingEvent charges line items for impressions, implements TrackingEventListener
+func (s *BudgetService) OnTrackingEvent(event model.TrackingEvent) {
+	if event.EventType != model.TrackingEventTypeImpression {
+		return
+	}
+
+	item, err := s.lineItems.GetByID(event.LineItemID)
+	if err != nil {
+		s.log.Debugw("Impression of unknown line item is not charged",
+			"line_item_id", event.LineItemID,
+			"error", err,
+		)
+		return
+	}
+
+	// the price comes from the client, a line item never pays more than it bids
+	price := item.Bid
+	if event.ClearingPrice > 0 {
+		price = min(event.ClearingPrice, item.Bid)
+	}
+	s.Charge(item.ID, price/1000)
+}
+
+// Charge adds an amount to the today's spend of a line item
+func (s *BudgetService) Charge(lineItemID string, amount float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.rollover()
+	s.spend[lineItemID] += amount
+	s.pending[lineItemID] += amount
+}
+
+// Spend returns the today's spend of a line item
+func (s *BudgetService) Spend(lineItemID string) float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.rollover()
+	return s.spend[lineItemID]
+}
+
+// Allow excludes line items which have spent their daily budget, implements LineItemFilter
+func (s *BudgetService) Allow(item *model.LineItem) bool {
+	return s.Spend(item.ID) < item.Budget
+}
+
+// Persist adds charges made since the last call to the store
+func (s *BudgetService) Persist() error {
+	s.mu.Lock()
+	s.rollover()
+	day, pending := s.day, s.pending
+	s.pending = make(map[string]float64)
+	s.mu.Unlock()
+
+	if len(pending) == 0 {
+		return nil
+	}
+	if err := s.store.AddSpend(spendDay(day), pending); err != nil {
+		// the charges are persisted with the next call, unless the day is over
+		s.mu.Lock()
+		if day.Equal(s.day) {
+			for id, amount := range pending {
+				s.pending[id] += amount
+			}
+		}
+		s.mu.Unlock()
+		return fmt.Errorf("add spend: %w", err)
+	}
+	return nil
+}
+
+// PersistLoop periodically adds charges to the store, the last ones must be persisted with Persist on shutdown
+func (s *BudgetService) PersistLoop(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.Persist(); err != nil {
+				s.log.Errorw("Cannot persist spend of line items",
+					"error", err,
+				)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// rollover starts the ledger of a new day with the spend persisted for it, must be called under the lock
+func (s *BudgetService) rollover() {
+	day := startOfDay(s.clock.Now(), s.location)
+	if day.Equal(s.day) {
+		return
+	}
+
+	s.day = day
+	clear(s.spend)
+	clear(s.pending)
+	spend, err := s.store.LoadSpend(spendDay(day))
+	if err != nil {
+		// budgets are not enforced by the spend of other instances or before a restart until the next day
+		s.log.Errorw("Cannot load spend of line items",
+			"day", spendDay(day),
+			"error", err,
+		)
+		return
+	}
+	maps.Copy(s.spend, spend)
+}
+
+// spendDay returns the day of a SpendStore
+func spendDay(day time.Time) string {
+	return day.Format(time.DateOnly)
+}
+
+// startOfDay returns the midnight of the day of 't' in the given location
+func startOfDay(t time.Time, loc *time.Location) time.Time {
+	year, month, day := t.In(loc).Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, loc)
+}
+
+// MemorySpendStore is a SpendStore keeping the spend in memory, it doesn't survive restarts
+type MemorySpendStore struct {
+	mu    sync.Mutex
+	day   string
+	spend map[string]float64
+}
+
+// NewMemorySpendStore creates a new MemorySpendStore
+func NewMemorySpendStore() *MemorySpendStore {
+	return &MemorySpendStore{
+		spend: make(map[string]float64),
+	}
+}
+
+// LoadSpend returns the spend of line items on a day
+func (s *MemorySpendStore) LoadSpend(day string) (map[string]float64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if day != s.day {
+		return nil, nil
+	}
+	return maps.Clone(s.spend), nil
+}
+
+// AddSpend adds amounts to the spend of line items on a day, only the spend of the latest day is kept
+func (s *MemorySpendStore) AddSpend(day string, amounts map[string]float64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch {
+	case day < s.day:
+		return nil
+	case day > s.day:
+		s.day = day
+		clear(s.spend)
+	}
+	for id, amount := range amounts {
+		s.spend[id] += amount
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package service
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"sweng-task/internal/model"
+
+	"go.uber.org/zap"
+)
+
+func TestBudgetService_Enforcement(t *testing.T) {
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		t.Fatalf("Load location: %v", err)
+	}
+	// 23:00 in Tokyo
+	clock := &fakeClock{now: time.Date(2025, 6, 2, 14, 0, 0, 0, time.UTC)}
+	log := zap.NewNop().Sugar()
+
+	lineItems := NewLineItemService(NewMemoryLineItemRepository(), NewMemoryLineItemHistory(), clock, log)
+	budget := NewBudgetService(lineItems, NewMemorySpendStore(), clock, tokyo, log)
+	ads := NewAdService(lineItems, log, WithLineItemFilter(budget))
+	tracking := NewTrackingService(10, nil, time.Second, log, WithTrackingEventListener(budget))
+
+	item, err := lineItems.Create(model.LineItemCreate{Name: "test_1", Bid: 1000, Budget: 2, Placement: "header"}, model.ChangeMeta{})
+	if err != nil {
+		t.Fatalf("Create line item: %v", err)
+	}
+
+	serve := func() int {
+		t.Helper()
+		got, err := ads.GetWinningAds(model.AdRequest{TargetingQuery: model.TargetingQuery{Placement: "header"}, Limit: 10})
+		if err != nil {
+			t.Fatalf("Get winning ads: %v", err)
+		}
+		return len(got)
+	}
+	track := func(eventType model.TrackingEventType) {
+		t.Helper()
+		ok, err := tracking.RecordAdInteraction(model.TrackingEvent{EventType: eventType, LineItemID: item.ID})
+		if !ok || err != nil {
+			t.Fatalf("Record ad interaction: %v, %v", ok, err)
+		}
+	}
+
+	track(model.TrackingEventTypeImpression)
+	track(model.TrackingEventTypeClick)
+	if spend := budget.Spend(item.ID); math.Abs(spend-1) > 1e-9 {
+		t.Errorf("Only impressions must be charged: %v != 1", spend)
+	}
+	if serve() != 1 {
+		t.Errorf("Line item with budget left must be served")
+	}
+
+	// clearing price is charged instead of the bid
+	ok, err := tracking.RecordAdInteraction(model.TrackingEvent{EventType: model.TrackingEventTypeImpression, LineItemID: item.ID, ClearingPrice: 500})
+	if !ok || err != nil {
+		t.Fatalf("Record ad interaction: %v, %v", ok, err)
+	}
+	if spend := budget.Spend(item.ID); math.Abs(spend-1.5) > 1e-9 {
+		t.Errorf("Clearing price must be charged: %v != 1.5", spend)
+	}
+	if serve() != 1 {
+		t.Errorf("Line item with budget left must be served")
+	}
+
+	track(model.TrackingEventTypeImpression)
+	if serve() != 0 {
+		t.Errorf("Line item with spent budget must not be served")
+	}
+
+	// 00:00 in Tokyo
+	clock.now = clock.now.Add(time.Hour)
+	if spend := budget.Spend(item.ID); spend != 0 {
+		t.Errorf("Spend must be reset at midnight: %v", spend)
+	}
+	if serve() != 1 {
+		t.Errorf("Line item must be served on the next day")
+	}
+}
+
+// failingSpendStore fails to add spend, like an unavailable database
+type failingSpendStore struct {
+	*MemorySpendStore
+	fail bool
+}
+
+func (s *failingSpendStore) AddSpend(day string, amounts map[string]float64) error {
+	if s.fail {
+		return errors.New("database is unavailable")
+	}
+	return s.MemorySpendStore.AddSpend(day, amounts)
+}
+
+func TestBudgetService_Persist(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2025, 6, 2, 12, 0, 0, 0, time.UTC)}
+	log := zap.NewNop().Sugar()
+	store := &failingSpendStore{MemorySpendStore: NewMemorySpendStore()}
+
+	budget := NewBudgetService(nil, store, clock, time.UTC, log)
+	budget.Charge("li_1", 1)
+	budget.Charge("li_2", 2)
+	if err := budget.Persist(); err != nil {
+		t.Fatalf("Persist: %v", err)
+	}
+
+	// failed charges are persisted with the next call
+	budget.Charge("li_1", 0.5)
+	store.fail = true
+	if err := budget.Persist(); err == nil {
+		t.Fatalf("Persist must fail")
+	}
+	store.fail = false
+	if err := budget.Persist(); err != nil {
+		t.Fatalf("Persist: %v", err)
+	}
+
+	// the spend survives a restart
+	restarted := NewBudgetService(nil, store, clock, time.UTC, log)
+	if spend := restarted.Spend("li_1"); math.Abs(spend-1.5) > 1e-9 {
+		t.Errorf("Wrong spend after a restart: %v != 1.5", spend)
+	}
+	if spend := restarted.Spend("li_2"); spend != 2 {
+		t.Errorf("Wrong spend after a restart: %v != 2", spend)
+	}
+
+	// the persisted spend of the previous day is not loaded
+	clock.now = clock.now.Add(24 * time.Hour)
+	if spend := NewBudgetService(nil, store, clock, time.UTC, log).Spend("li_1"); spend != 0 {
+		t.Errorf("Spend must be reset at midnight: %v", spend)
+	}
+}
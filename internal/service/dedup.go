@@ -0,0 +1,131 @@
+package service
+
+import (
+	"fmt"
+	"hash/maphash"
+	"math"
+	"sync"
+	"time"
+)
+
+// EventDeduplicator remembers IDs of recently accepted tracking events
+type EventDeduplicator interface {
+	// Seen reports if an event ID was added recently
+	Seen(id string) bool
+	// Add remembers an event ID
+	Add(id string)
+}
+
+// bloomBuckets is the number of buckets a dedup window is split into,
+// one more bucket keeps IDs of the partially expired one
+const bloomBuckets = 4
+
+// BloomDeduplicator remembers event IDs of a time window in time-bucketed bloom filters.
+// The window is split into buckets with a bloom filter each, the oldest bucket is cleared
+// once a new one starts, so memory doesn't depend on the number of events.
+// IDs are remembered between 'window' and window×(1+1/buckets).
+// Bloom filters may report false positives, so an event is dropped as a duplicate
+// with the configured probability if more than the expected number of events arrives.
+type BloomDeduplicator struct {
+	clock  Clock
+	bucket time.Duration // length of a bucket
+	bits   uint64        // number of bits of a bloom filter
+	hashes int           // number of hash functions
+	seeds  [2]maphash.Seed
+
+	mu      sync.Mutex
+	buckets [bloomBuckets + 1]bloomBucket
+}
+
+type bloomBucket struct {
+	epoch int64 // number of the bucket since the Unix epoch
+	bits  []uint64
+}
+
+// NewBloomDeduplicator creates a new BloomDeduplicator remembering up to 'capacity' event IDs per window
+// with at most 'falsePositiveRate' probability of a false duplicate
+func NewBloomDeduplicator(clock Clock, window time.Duration, capacity int, falsePositiveRate float64) (*BloomDeduplicator, error) {
+	switch {
+	case window < bloomBuckets:
+		return nil, fmt.Errorf("dedup window %v is too short", window)
+	case capacity <= 0:
+		return nil, fmt.Errorf("dedup capacity must be a positive number")
+	case falsePositiveRate <= 0 || falsePositiveRate >= 1:
+		return nil, fmt.Errorf("dedup false positive rate must be in the range (0-1)")
+	}
+
+	// a bucket takes a share of the window events, and every lookup checks all the buckets,
+	// so each of them gets a share of the false positive rate
+	n := float64(capacity) / bloomBuckets
+	p := falsePositiveRate / (bloomBuckets + 1)
+	bits := math.Ceil(-n * math.Log(p) / (math.Ln2 * math.Ln2))
+	words := uint64(math.Ceil(bits / 64))
+
+	d := &BloomDeduplicator{
+		clock:  clock,
+		bucket: window / bloomBuckets,
+		bits:   words * 64,
+		hashes: max(1, int(math.Round(bits/n*math.Ln2))),
+		seeds:  [2]maphash.Seed{maphash.MakeSeed(), maphash.MakeSeed()},
+	}
+	for i := range d.buckets {
+		d.buckets[i] = bloomBucket{epoch: math.MinInt64, bits: make([]uint64, words)}
+	}
+	return d, nil
+}
+
+// Seen reports if an event ID was added within the window
+func (d *BloomDeduplicator) Seen(id string) bool {
+	h1, h2 := d.hash(id)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	epoch := d.epoch()
+	for i := range d.buckets {
+		b := &d.buckets[i]
+		if b.epoch >= epoch-bloomBuckets && b.epoch <= epoch && d.contains(b, h1, h2) {
+			return true
+		}
+	}
+	return false
+}
+
+// Add remembers an event ID in the current bucket
+func (d *BloomDeduplicator) Add(id string) {
+	h1, h2 := d.hash(id)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	epoch := d.epoch()
+	b := &d.buckets[uint64(epoch)%uint64(len(d.buckets))]
+	if b.epoch != epoch {
+		clear(b.bits)
+		b.epoch = epoch
+	}
+	for i := range d.hashes {
+		bit := (h1 + uint64(i)*h2) % d.bits
+		b.bits[bit/64] |= 1 << (bit % 64)
+	}
+}
+
+func (d *BloomDeduplicator) contains(b *bloomBucket, h1, h2 uint64) bool {
+	for i := range d.hashes {
+		bit := (h1 + uint64(i)*h2) % d.bits
+		if b.bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// epoch returns the number of the current bucket
+func (d *BloomDeduplicator) epoch() int64 {
+	return d.clock.Now().UnixNano() / int64(d.bucket)
+}
+
+// hash returns two independent hashes of an ID, the hash functions of the filter are h1 + i×h2
+func (d *BloomDeduplicator) hash(id string) (uint64, uint64) {
+	return maphash.String(d.seeds[0], id), maphash.String(d.seeds[1], id) | 1
+}
@@ -0,0 +1,131 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"sweng-task/internal/metrics"
+	"sweng-task/internal/model"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.uber.org/zap"
+)
+
+func TestBloomDeduplicator(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2025, 6, 2, 10, 0, 0, 0, time.UTC)}
+	dedup, err := NewBloomDeduplicator(clock, 4*time.Minute, 1000, 0.001)
+	if err != nil {
+		t.Fatalf("Create deduplicator: %v", err)
+	}
+
+	dedup.Add("evt_1")
+	if !dedup.Seen("evt_1") {
+		t.Errorf("Added ID must be seen")
+	}
+	if dedup.Seen("evt_2") {
+		t.Errorf("Unknown ID must not be seen")
+	}
+
+	// IDs are remembered for the whole window
+	clock.now = clock.now.Add(3 * time.Minute)
+	dedup.Add("evt_2")
+	clock.now = clock.now.Add(time.Minute + 59*time.Second)
+	if !dedup.Seen("evt_1") || !dedup.Seen("evt_2") {
+		t.Errorf("IDs must be seen within the window")
+	}
+
+	// and forgotten after it
+	clock.now = clock.now.Add(time.Second)
+	if dedup.Seen("evt_1") {
+		t.Errorf("ID must be forgotten after the window")
+	}
+	if !dedup.Seen("evt_2") {
+		t.Errorf("ID must be seen within the window")
+	}
+	clock.now = clock.now.Add(time.Hour)
+	if dedup.Seen("evt_2") {
+		t.Errorf("ID must be forgotten after the window")
+	}
+}
+
+func TestBloomDeduplicator_FalsePositiveRate(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2025, 6, 2, 10, 0, 0, 0, time.UTC)}
+	capacity := 20000
+	dedup, err := NewBloomDeduplicator(clock, 4*time.Minute, capacity, 0.01)
+	if err != nil {
+		t.Fatalf("Create deduplicator: %v", err)
+	}
+
+	// the capacity is spread over the window
+	for i := range capacity {
+		clock.now = clock.now.Add(4 * time.Minute / time.Duration(capacity))
+		dedup.Add(fmt.Sprintf("evt_%d", i))
+	}
+
+	var falsePositives int
+	for i := range capacity {
+		if dedup.Seen(fmt.Sprintf("unknown_%d", i)) {
+			falsePositives++
+		}
+	}
+	if rate := float64(falsePositives) / float64(capacity); rate > 0.01 {
+		t.Errorf("False positive rate is too high: %v", rate)
+	}
+}
+
+func TestTrackingService_RecordAdInteraction_Dedup(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2025, 6, 2, 10, 0, 0, 0, time.UTC)}
+	dedup, err := NewBloomDeduplicator(clock, time.Minute, 1000, 0.001)
+	if err != nil {
+		t.Fatalf("Create deduplicator: %v", err)
+	}
+	recorder := &trackingEventsRecorder{}
+	tracking := NewTrackingService(3, nil, time.Second, zap.NewNop().Sugar(), WithTrackingEventListener(recorder), WithEventDeduplicator(dedup))
+	duplicates := testutil.ToFloat64(metrics.TrackingEventsDuplicates.WithLabelValues(string(model.TrackingEventTypeImpression)))
+
+	record := func(event model.TrackingEvent) bool {
+		t.Helper()
+		ok, err := tracking.RecordAdInteraction(event)
+		if err != nil && !errors.Is(err, ErrTrackingOverloaded) {
+			t.Fatalf("Record ad interaction: %v", err)
+		}
+		return ok
+	}
+
+	impression := model.TrackingEvent{EventType: model.TrackingEventTypeImpression, LineItemID: "li_1", AuctionID: "auc_1"}
+	for range 3 {
+		if !record(impression) {
+			t.Errorf("Duplicate must be reported as accepted")
+		}
+	}
+	record(model.TrackingEvent{EventType: model.TrackingEventTypeImpression, ID: "evt_1"})
+	record(model.TrackingEvent{EventType: model.TrackingEventTypeImpression, ID: "evt_1"})
+
+	if len(recorder.events) != 2 {
+		t.Fatalf("Wrong amount of recorded events: %d != 2", len(recorder.events))
+	}
+	if recorder.events[0].ID != "impression:auc_1:li_1" {
+		t.Errorf("Wrong derived event ID: %q", recorder.events[0].ID)
+	}
+	if got := testutil.ToFloat64(metrics.TrackingEventsDuplicates.WithLabelValues(string(model.TrackingEventTypeImpression))) - duplicates; got != 3 {
+		t.Errorf("Wrong amount of duplicates: %v != 3", got)
+	}
+
+	// the click of the same ad and events without IDs are not duplicates
+	record(model.TrackingEvent{EventType: model.TrackingEventTypeClick, LineItemID: "li_1", AuctionID: "auc_1"})
+	if len(recorder.events) != 3 {
+		t.Fatalf("Wrong amount of recorded events: %d != 3", len(recorder.events))
+	}
+
+	// the buffer is full, a rejected event is accepted on retry
+	event := model.TrackingEvent{EventType: model.TrackingEventTypeImpression, ID: "evt_2"}
+	if record(event) {
+		t.Fatalf("Buffer must be full")
+	}
+	<-tracking.shards[0]
+	if !record(event) || len(recorder.events) != 4 {
+		t.Errorf("Retry of a rejected event must be recorded")
+	}
+}
@@ -0,0 +1,84 @@
+package service
+
+import (
+	"fmt"
+	"slices"
+
+	"sweng-task/internal/model"
+)
+
+// Diversity limits ads of the same advertiser and of competitors in one response.
+// It is applied to ranked bidders, a skipped bidder leaves its slot to the next eligible one.
+type Diversity struct {
+	// MaxAdsPerAdvertiser limits ads of an advertiser, 0 means no limit.
+	// Line items without an advertiser are not limited.
+	MaxAdsPerAdvertiser int
+	// ExclusionGroups are groups of competitors, ads of different advertisers of a group never appear together
+	ExclusionGroups []ExclusionGroup
+}
+
+// ExclusionGroup is a group of competing line items: the ones of its advertisers or targeting its categories
+type ExclusionGroup struct {
+	Name        string
+	Advertisers []string
+	Categories  []string
+}
+
+// Validate validates the diversity configuration
+func (d Diversity) Validate() error {
+	if d.MaxAdsPerAdvertiser < 0 {
+		return fmt.Errorf("max ads per advertiser must not be negative")
+	}
+	for _, group := range d.ExclusionGroups {
+		if len(group.Advertisers) == 0 && len(group.Categories) == 0 {
+			return fmt.Errorf("exclusion group %q has neither advertisers nor categories", group.Name)
+		}
+	}
+	return nil
+}
+
+// Apply selects ranked bidders which may appear together, keeping their order.
+// At most slots+1 bidders are selected: the last one sets the price of the last slot.
+func (d Diversity) Apply(ranked []Bidder, slots int) []Bidder {
+	selected := make([]Bidder, 0, min(slots+1, len(ranked)))
+	perAdvertiser := make(map[string]int)
+	for _, bidder := range ranked {
+		if len(selected) > slots {
+			break
+		}
+
+		item := bidder.LineItem
+		if d.MaxAdsPerAdvertiser > 0 && item.AdvertiserID != "" && perAdvertiser[item.AdvertiserID] >= d.MaxAdsPerAdvertiser {
+			continue
+		}
+		if slices.ContainsFunc(selected, func(other Bidder) bool { return d.competing(item, other.LineItem) }) {
+			continue
+		}
+
+		selected = append(selected, bidder)
+		perAdvertiser[item.AdvertiserID]++
+	}
+	return selected
+}
+
+// competing reports if line items of different advertisers belong to the same exclusion group
+func (d Diversity) competing(x, y *model.LineItem) bool {
+	if x.AdvertiserID != "" && x.AdvertiserID == y.AdvertiserID {
+		return false
+	}
+	for _, group := range d.ExclusionGroups {
+		if group.contains(x) && group.contains(y) {
+			return true
+		}
+	}
+	return false
+}
+
+func (g ExclusionGroup) contains(item *model.LineItem) bool {
+	if item.AdvertiserID != "" && slices.Contains(g.Advertisers, item.AdvertiserID) {
+		return true
+	}
+	return slices.ContainsFunc(item.Categories, func(category string) bool {
+		return slices.Contains(g.Categories, category)
+	})
+}
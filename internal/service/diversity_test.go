@@ -0,0 +1,106 @@
+package service
+
+import (
+	"slices"
+	"testing"
+
+	"sweng-task/internal/model"
+
+	"go.uber.org/zap"
+)
+
+func TestDiversity_Apply(t *testing.T) {
+	bidder := func(id, advertiserID string, categories ...string) Bidder {
+		return Bidder{LineItem: &model.LineItem{ID: id, AdvertiserID: advertiserID, Categories: categories}, Quality: 1}
+	}
+	ranked := []Bidder{
+		bidder("li_1", "coke", "soft_drinks"),
+		bidder("li_2", "coke", "soft_drinks"),
+		bidder("li_3", "pepsi", "soft_drinks"),
+		bidder("li_4", "lego", "toys"),
+		bidder("li_5", "", "toys"),
+		bidder("li_6", "", "toys"),
+	}
+	cola := ExclusionGroup{Name: "cola", Advertisers: []string{"coke", "pepsi"}}
+	softDrinks := ExclusionGroup{Name: "soft_drinks", Categories: []string{"soft_drinks"}}
+
+	tests := []struct {
+		name      string
+		diversity Diversity
+		slots     int
+		want      []string
+	}{
+		{"no rules", Diversity{}, 3, []string{"li_1", "li_2", "li_3", "li_4"}},
+		{"one per advertiser", Diversity{MaxAdsPerAdvertiser: 1}, 3, []string{"li_1", "li_3", "li_4", "li_5"}},
+		{"two per advertiser", Diversity{MaxAdsPerAdvertiser: 2}, 2, []string{"li_1", "li_2", "li_3"}},
+		{"unknown advertisers are not limited", Diversity{MaxAdsPerAdvertiser: 1}, 5, []string{"li_1", "li_3", "li_4", "li_5", "li_6"}},
+		{"advertisers group", Diversity{ExclusionGroups: []ExclusionGroup{cola}}, 3, []string{"li_1", "li_2", "li_4", "li_5"}},
+		{"categories group", Diversity{MaxAdsPerAdvertiser: 1, ExclusionGroups: []ExclusionGroup{softDrinks}}, 3, []string{"li_1", "li_4", "li_5", "li_6"}},
+		{"unknown advertisers compete", Diversity{ExclusionGroups: []ExclusionGroup{{Name: "toys", Categories: []string{"toys"}}}}, 5, []string{"li_1", "li_2", "li_3", "li_4"}},
+		{"not enough bidders", Diversity{MaxAdsPerAdvertiser: 1, ExclusionGroups: []ExclusionGroup{cola}}, 10, []string{"li_1", "li_4", "li_5", "li_6"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ids []string
+			for _, bidder := range tt.diversity.Apply(ranked, tt.slots) {
+				ids = append(ids, bidder.LineItem.ID)
+			}
+			if !slices.Equal(ids, tt.want) {
+				t.Errorf("Wrong selected bidders: %v != %v", ids, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiversity_Validate(t *testing.T) {
+	if err := (Diversity{MaxAdsPerAdvertiser: 1, ExclusionGroups: []ExclusionGroup{{Name: "cola", Advertisers: []string{"coke"}}}}).Validate(); err != nil {
+		t.Errorf("Valid diversity: %v", err)
+	}
+	if err := (Diversity{MaxAdsPerAdvertiser: -1}).Validate(); err == nil {
+		t.Errorf("Negative max ads per advertiser must be rejected")
+	}
+	if err := (Diversity{ExclusionGroups: []ExclusionGroup{{Name: "empty"}}}).Validate(); err == nil {
+		t.Errorf("Empty exclusion group must be rejected")
+	}
+}
+
+func TestAdService_GetWinningAds_Diversity(t *testing.T) {
+	lineItemsService := NewLineItemService(NewMemoryLineItemRepository(), NewMemoryLineItemHistory(), SystemClock{}, zap.NewNop().Sugar())
+	adService := NewAdService(lineItemsService, zap.NewNop().Sugar(), WithDiversity(Diversity{
+		MaxAdsPerAdvertiser: 1,
+		ExclusionGroups:     []ExclusionGroup{{Name: "cola", Advertisers: []string{"coke", "pepsi"}}},
+	}))
+
+	for _, input := range []model.LineItemCreate{
+		{Name: "coke_1", AdvertiserID: "coke", Bid: 5},
+		{Name: "coke_2", AdvertiserID: "coke", Bid: 4},
+		{Name: "pepsi", AdvertiserID: "pepsi", Bid: 3},
+		{Name: "lego", AdvertiserID: "lego", Bid: 2},
+		{Name: "hasbro", AdvertiserID: "hasbro", Bid: 1},
+	} {
+		input.Budget = 1000
+		input.Placement = "header"
+		if _, err := lineItemsService.Create(input, model.ChangeMeta{}); err != nil {
+			t.Fatalf("Create line item: %v", err)
+		}
+	}
+
+	ads, err := adService.GetWinningAds(model.AdRequest{TargetingQuery: model.TargetingQuery{Placement: "header"}, Limit: 2})
+	if err != nil {
+		t.Fatalf("Get winning ads: %v", err)
+	}
+
+	// skipped ads don't set prices, the backfilled slot pays the bid of the next eligible ad
+	var names []string
+	var prices []float64
+	for _, ad := range ads {
+		names = append(names, ad.Name)
+		prices = append(prices, ad.ClearingPrice)
+	}
+	if !slices.Equal(names, []string{"coke_1", "lego"}) {
+		t.Errorf("Wrong winning ads: %v", names)
+	}
+	if !slices.Equal(prices, []float64{2, 1}) {
+		t.Errorf("Wrong clearing prices: %v", prices)
+	}
+}
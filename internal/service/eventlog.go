@@ -0,0 +1,86 @@
+package service
+
+import (
+	"context"
+
+	"sweng-task/internal/model"
+)
+
+// EventLog is a write-ahead log of accepted tracking events, events are appended before they are
+// acknowledged and committed once they are written to the storage, so a crash doesn't lose them
+type EventLog interface {
+	// Append durably appends an event and returns its offset, offsets start at 1
+	Append(event model.TrackingEvent, signed bool) (uint64, error)
+	// Commit marks events as written to the storage, they are not returned by Pending after a restart
+	Commit(offsets []uint64) error
+	// Pending returns events which were appended but not committed before the log was opened
+	Pending() []LoggedEvent
+}
+
+// LoggedEvent is an event of the EventLog
+type LoggedEvent struct {
+	Offset uint64
+	Event  model.TrackingEvent
+	Signed bool // the event came from a signed URL
+}
+
+// queuedEvent is an event passed to the worker, offset is 0 for events which are not in the EventLog
+type queuedEvent struct {
+	model.TrackingEvent
+	offset uint64
+}
+
+// WithEventLog appends accepted events to the write-ahead log, without it events buffered
+// in memory are lost on a crash
+func WithEventLog(log EventLog) TrackingServiceOption {
+	return func(s *TrackingService) {
+		s.eventLog = log
+	}
+}
+
+// ReplayEventLog passes events which were not written to the storage before a restart to the worker,
+// it waits for free space in the buffer, so the worker must be running.
+// Listeners were notified once the events were accepted, so they are not notified again.
+func (s *TrackingService) ReplayEventLog(ctx context.Context) (int, error) {
+	if s.eventLog == nil {
+		return 0, nil
+	}
+
+	pending := s.eventLog.Pending()
+	for i, logged := range pending {
+		if s.dedup != nil && logged.Event.ID != "" {
+			s.dedupMu.Lock()
+			s.dedup.Add(dedupKey(logged.Event, logged.Signed))
+			s.dedupMu.Unlock()
+		}
+		select {
+		case s.shard(logged.Event) <- queuedEvent{TrackingEvent: logged.Event, offset: logged.Offset}:
+		case <-ctx.Done():
+			return i, ctx.Err()
+		}
+	}
+	return len(pending), nil
+}
+
+// commitEventLog commits events which don't have to be replayed after a restart,
+// a failed commit only replays them again
+func (s *TrackingService) commitEventLog(events ...queuedEvent) {
+	if s.eventLog == nil {
+		return
+	}
+	offsets := make([]uint64, 0, len(events))
+	for _, event := range events {
+		if event.offset != 0 {
+			offsets = append(offsets, event.offset)
+		}
+	}
+	if len(offsets) == 0 {
+		return
+	}
+	if err := s.eventLog.Commit(offsets); err != nil {
+		s.log.Errorw("Cannot commit events to the event log",
+			"events", len(offsets),
+			"error", err,
+		)
+	}
+}
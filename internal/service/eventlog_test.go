@@ -0,0 +1,160 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"sweng-task/internal/model"
+
+	"go.uber.org/zap"
+)
+
+type memoryEventLog struct {
+	mu        sync.Mutex
+	events    []model.TrackingEvent
+	committed []uint64
+	pending   []LoggedEvent
+	err       error
+}
+
+func (l *memoryEventLog) Append(event model.TrackingEvent, _ bool) (uint64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.err != nil {
+		return 0, l.err
+	}
+	l.events = append(l.events, event)
+	return uint64(len(l.events)), nil
+}
+
+func (l *memoryEventLog) Commit(offsets []uint64) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.committed = append(l.committed, offsets...)
+	return nil
+}
+
+func (l *memoryEventLog) Pending() []LoggedEvent {
+	return l.pending
+}
+
+func (l *memoryEventLog) committedOffsets() []uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return slices.Sorted(slices.Values(l.committed))
+}
+
+func TestTrackingService_EventLog(t *testing.T) {
+	eventLog := &memoryEventLog{}
+	flushed := make(chan struct{})
+	storage := TrackingEventsStorageFunc(func(context.Context, []model.TrackingEvent) error {
+		<-flushed
+		return nil
+	})
+	tracking := NewTrackingService(2, storage, time.Second, zap.NewNop().Sugar(), WithEventLog(eventLog))
+
+	// events are appended before they are accepted, a rejected event is committed right away
+	for _, id := range []string{"evt_1", "evt_2", "evt_3"} {
+		_, _ = tracking.RecordAdInteraction(model.TrackingEvent{ID: id})
+	}
+	if len(eventLog.events) != 3 {
+		t.Fatalf("Events must be appended: %d != 3", len(eventLog.events))
+	}
+	if got := eventLog.committedOffsets(); !slices.Equal(got, []uint64{3}) {
+		t.Errorf("Only the rejected event must be committed: %v", got)
+	}
+
+	// events are committed once they are flushed
+	ctx, stop := context.WithCancel(t.Context())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if err := tracking.TrackingEventsWorker(ctx, 2, time.Second); err != nil {
+			t.Errorf("Tracking events worker stopped with an error: %v", err)
+		}
+	}()
+	close(flushed)
+	stop()
+	<-done
+	if got := eventLog.committedOffsets(); !slices.Equal(got, []uint64{1, 2, 3}) {
+		t.Errorf("Flushed events must be committed: %v", got)
+	}
+
+	// nothing is accepted if the event cannot be appended
+	eventLog.err = errors.New("disk is full")
+	if ok, err := tracking.RecordAdInteraction(model.TrackingEvent{ID: "evt_4"}); ok || !errors.Is(err, eventLog.err) {
+		t.Errorf("Event must not be accepted: %v, %v", ok, err)
+	}
+	if len(tracking.shards[0]) != 0 {
+		t.Errorf("Event must not be passed to the worker")
+	}
+}
+
+func TestTrackingService_EventLogNotCommittedOnFailure(t *testing.T) {
+	eventLog := &memoryEventLog{}
+	storage := TrackingEventsStorageFunc(func(context.Context, []model.TrackingEvent) error {
+		return errors.New("storage is unavailable")
+	})
+	tracking := NewTrackingService(2, storage, time.Second, zap.NewNop().Sugar(), WithEventLog(eventLog))
+
+	if _, err := tracking.RecordAdInteraction(model.TrackingEvent{ID: "evt_1"}); err != nil {
+		t.Fatalf("Record event: %v", err)
+	}
+	if err := tracking.TrackingEventsWorker(t.Context(), 1, time.Second); err == nil {
+		t.Fatalf("Worker must stop")
+	}
+	if got := eventLog.committedOffsets(); len(got) != 0 {
+		t.Errorf("Events which are not written must not be committed: %v", got)
+	}
+}
+
+func TestTrackingService_ReplayEventLog(t *testing.T) {
+	eventLog := &memoryEventLog{pending: []LoggedEvent{
+		{Offset: 7, Event: model.TrackingEvent{ID: "evt_1"}, Signed: true},
+		{Offset: 8, Event: model.TrackingEvent{ID: "evt_2"}},
+	}}
+	dedup, err := NewBloomDeduplicator(&fakeClock{now: time.Date(2025, 6, 2, 10, 0, 0, 0, time.UTC)}, time.Minute, 1000, 0.001)
+	if err != nil {
+		t.Fatalf("Create deduplicator: %v", err)
+	}
+	recorder := &trackingEventsRecorder{}
+	tracking := NewTrackingService(1, nil, time.Second, zap.NewNop().Sugar(),
+		WithEventLog(eventLog),
+		WithEventDeduplicator(dedup),
+		WithTrackingEventListener(recorder))
+
+	// the buffer is smaller than the log, replay waits for the worker
+	replayed := make(chan int)
+	go func() {
+		n, err := tracking.ReplayEventLog(t.Context())
+		if err != nil {
+			t.Errorf("Replay: %v", err)
+		}
+		replayed <- n
+	}()
+	for i, want := range eventLog.pending {
+		select {
+		case event := <-tracking.shards[0]:
+			if event.ID != want.Event.ID || event.offset != want.Offset {
+				t.Errorf("Wrong event %d: %+v", i, event)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Event %d is not replayed", i)
+		}
+	}
+	if n := <-replayed; n != 2 {
+		t.Errorf("Wrong amount of replayed events: %d != 2", n)
+	}
+	if len(recorder.events) != 0 {
+		t.Errorf("Listeners must not be notified about replayed events")
+	}
+
+	// retries of replayed events are duplicates
+	if ok, err := tracking.RecordAdInteraction(model.TrackingEvent{ID: "evt_1"}); !ok || err != nil || len(tracking.shards[0]) != 0 {
+		t.Errorf("Retry of a replayed event must be dropped: %v, %v", ok, err)
+	}
+}
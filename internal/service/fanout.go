@@ -0,0 +1,220 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"sweng-task/internal/metrics"
+	"sweng-task/internal/model"
+
+	"go.uber.org/zap"
+)
+
+// FanOutSink is a storage events are fanned out to
+type FanOutSink struct {
+	Name    string
+	Storage TrackingEventsStorage
+	// Required sinks fail the write, failures of best-effort sinks are only logged and counted
+	Required bool
+	// Timeout limits a write to the sink, 0 keeps the deadline of the write
+	Timeout time.Duration
+	// EventTypes the sink receives, all of them if empty
+	EventTypes []model.TrackingEventType
+}
+
+// FanOutStorage writes batches of events to several storages in parallel.
+// A write fails only if a required sink fails, retries of the write send the batch to all sinks again,
+// so sinks must tolerate duplicates identified by event IDs.
+type FanOutStorage struct {
+	sinks []FanOutSink
+
+	log *zap.SugaredLogger
+}
+
+// NewFanOutStorage creates a new FanOutStorage, at least one sink must be required
+func NewFanOutStorage(log *zap.SugaredLogger, sinks ...FanOutSink) (*FanOutStorage, error) {
+	names := make(map[string]struct{}, len(sinks))
+	var required bool
+	for _, sink := range sinks {
+		switch {
+		case sink.Name == "":
+			return nil, fmt.Errorf("sink name must not be empty")
+		case sink.Storage == nil:
+			return nil, fmt.Errorf("sink %q has no storage", sink.Name)
+		case sink.Timeout < 0:
+			return nil, fmt.Errorf("timeout of sink %q must not be negative", sink.Name)
+		}
+		if _, ok := names[sink.Name]; ok {
+			return nil, fmt.Errorf("sink %q is duplicated", sink.Name)
+		}
+		names[sink.Name] = struct{}{}
+		for _, eventType := range sink.EventTypes {
+			switch eventType {
+			case model.TrackingEventTypeImpression, model.TrackingEventTypeClick, model.TrackingEventTypeConversion:
+			default:
+				return nil, fmt.Errorf("sink %q: unknown event type %q, expected one of: impression, click, conversion", sink.Name, eventType)
+			}
+		}
+		required = required || sink.Required
+	}
+	if !required {
+		return nil, fmt.Errorf("at least one sink must be required, otherwise events may be lost silently")
+	}
+
+	return &FanOutStorage{
+		sinks: sinks,
+		log:   log,
+	}, nil
+}
+
+// Write writes events to all sinks in parallel, implements TrackingEventsStorage.
+// Events which failed in required sinks are returned as PartialWriteError if the rest was written.
+func (s *FanOutStorage) Write(ctx context.Context, events []model.TrackingEvent) error {
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		failed = make(map[int]struct{}) // indexes of events which failed in required sinks
+		errs   []error
+	)
+	for _, sink := range s.sinks {
+		indexes, sinkEvents := sink.filter(events)
+		if len(sinkEvents) == 0 {
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			err := sink.write(ctx, sinkEvents)
+			if err == nil {
+				return
+			}
+			metrics.TrackingSinkErrors.WithLabelValues(sink.Name).Inc()
+			if !sink.Required {
+				s.log.Warnw("Cannot write events to a best-effort sink",
+					"sink", sink.Name,
+					"events", len(sinkEvents),
+					"error", err,
+				)
+				return
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			var partial *PartialWriteError
+			if !errors.As(err, &partial) {
+				errs = append(errs, &SinkWriteError{Sink: sink.Name, Failed: sinkEvents, Err: err})
+				for _, i := range indexes {
+					failed[i] = struct{}{}
+				}
+				return
+			}
+			// the failed events of the sink are mapped to the batch, so the partial error of the sink must not leak
+			errs = append(errs, &SinkWriteError{
+				Sink:   sink.Name,
+				Failed: partial.Failed,
+				Err:    fmt.Errorf("%d events are not written: %w", len(partial.Failed), partial.Err),
+			})
+			for _, i := range partial.Indexes {
+				failed[indexes[i]] = struct{}{}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(errs) == 0 {
+		return nil
+	}
+	err := errors.Join(errs...)
+	if len(failed) == 0 || len(failed) == len(events) {
+		return err
+	}
+	partial := &PartialWriteError{Err: err}
+	for i, event := range events {
+		if _, ok := failed[i]; ok {
+			partial.Failed = append(partial.Failed, event)
+			partial.Indexes = append(partial.Indexes, i)
+		}
+	}
+	return partial
+}
+
+// Sink returns the storage of the sink with the given name
+func (s *FanOutStorage) Sink(name string) (TrackingEventsStorage, bool) {
+	for _, sink := range s.sinks {
+		if sink.Name == name {
+			return sink.Storage, true
+		}
+	}
+	return nil, false
+}
+
+// SinkWriteError is a failed write to a required sink of FanOutStorage
+type SinkWriteError struct {
+	Sink   string
+	Failed []model.TrackingEvent // events which are not written to the sink
+	Err    error
+}
+
+func (e *SinkWriteError) Error() string {
+	return fmt.Sprintf("sink %q: %v", e.Sink, e.Err)
+}
+
+func (e *SinkWriteError) Unwrap() error {
+	return e.Err
+}
+
+// sinkWriteErrors returns failed writes to sinks of FanOutStorage reported by err
+func sinkWriteErrors(err error) []*SinkWriteError {
+	switch err := err.(type) {
+	case nil:
+		return nil
+	case *SinkWriteError:
+		return []*SinkWriteError{err}
+	case interface{ Unwrap() []error }:
+		var sinkErrs []*SinkWriteError
+		for _, err := range err.Unwrap() {
+			sinkErrs = append(sinkErrs, sinkWriteErrors(err)...)
+		}
+		return sinkErrs
+	default:
+		return sinkWriteErrors(errors.Unwrap(err))
+	}
+}
+
+// filter returns the events the sink receives with their indexes in the batch
+func (sink FanOutSink) filter(events []model.TrackingEvent) ([]int, []model.TrackingEvent) {
+	if len(sink.EventTypes) == 0 {
+		indexes := make([]int, len(events))
+		for i := range indexes {
+			indexes[i] = i
+		}
+		return indexes, events
+	}
+
+	var (
+		indexes    []int
+		sinkEvents []model.TrackingEvent
+	)
+	for i, event := range events {
+		if slices.Contains(sink.EventTypes, event.EventType) {
+			indexes = append(indexes, i)
+			sinkEvents = append(sinkEvents, event)
+		}
+	}
+	return indexes, sinkEvents
+}
+
+func (sink FanOutSink) write(ctx context.Context, events []model.TrackingEvent) error {
+	if sink.Timeout > 0 {
+		var stop context.CancelFunc
+		ctx, stop = context.WithTimeout(ctx, sink.Timeout)
+		defer stop()
+	}
+	return sink.Storage.Write(ctx, events)
+}
@@ -0,0 +1,130 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"sweng-task/internal/model"
+
+	"go.uber.org/zap"
+)
+
+type sinkRecorder struct {
+	mu     sync.Mutex
+	events []model.TrackingEvent
+	err    error
+}
+
+func (r *sinkRecorder) Write(_ context.Context, events []model.TrackingEvent) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return r.err
+	}
+	r.events = append(r.events, events...)
+	return nil
+}
+
+func TestFanOutStorage(t *testing.T) {
+	durable := &sinkRecorder{}
+	attribution := &sinkRecorder{}
+	realtime := &sinkRecorder{err: errors.New("aggregator is unavailable")}
+	fanOut, err := NewFanOutStorage(zap.NewNop().Sugar(),
+		FanOutSink{Name: "durable", Storage: durable, Required: true},
+		FanOutSink{Name: "attribution", Storage: attribution, Required: true, EventTypes: []model.TrackingEventType{model.TrackingEventTypeClick, model.TrackingEventTypeConversion}},
+		FanOutSink{Name: "realtime", Storage: realtime},
+	)
+	if err != nil {
+		t.Fatalf("Create fan-out storage: %v", err)
+	}
+
+	events := []model.TrackingEvent{
+		{ID: "evt_1", EventType: model.TrackingEventTypeImpression},
+		{ID: "evt_2", EventType: model.TrackingEventTypeClick},
+		{ID: "evt_3", EventType: model.TrackingEventTypeConversion},
+	}
+
+	// failures of best-effort sinks don't fail the write
+	if err := fanOut.Write(t.Context(), events); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if len(durable.events) != 3 {
+		t.Errorf("Durable sink must receive all events: %d", len(durable.events))
+	}
+	if len(attribution.events) != 2 || attribution.events[0].ID != "evt_2" || attribution.events[1].ID != "evt_3" {
+		t.Errorf("Attribution sink must receive clicks and conversions only: %+v", attribution.events)
+	}
+
+	// events failed in a required sink are reported
+	attribution.err = errors.New("attribution is unavailable")
+	err = fanOut.Write(t.Context(), events)
+	var partial *PartialWriteError
+	if !errors.As(err, &partial) {
+		t.Fatalf("Write must fail partially: %v", err)
+	}
+	if len(partial.Failed) != 2 || partial.Indexes[0] != 1 || partial.Indexes[1] != 2 {
+		t.Errorf("Wrong failed events: %+v", partial)
+	}
+
+	// partial writes of a sink are mapped to the batch
+	attribution.err = &PartialWriteError{Failed: events[2:], Indexes: []int{1}, Err: errors.New("partition is unavailable")}
+	err = fanOut.Write(t.Context(), events)
+	if !errors.As(err, &partial) || len(partial.Indexes) != 1 || partial.Indexes[0] != 2 {
+		t.Errorf("Wrong failed events: %v", err)
+	}
+
+	durable.err = errors.New("disk is full")
+	err = fanOut.Write(t.Context(), events)
+	if err == nil || errors.As(err, &partial) {
+		t.Errorf("Write must fail completely: %v", err)
+	}
+}
+
+func TestFanOutStorage_Timeout(t *testing.T) {
+	slow := TrackingEventsStorageFunc(func(ctx context.Context, _ []model.TrackingEvent) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	durable := &sinkRecorder{}
+	fanOut, err := NewFanOutStorage(zap.NewNop().Sugar(),
+		FanOutSink{Name: "durable", Storage: durable, Required: true},
+		FanOutSink{Name: "slow", Storage: slow, Timeout: 10 * time.Millisecond},
+	)
+	if err != nil {
+		t.Fatalf("Create fan-out storage: %v", err)
+	}
+
+	start := time.Now()
+	if err := fanOut.Write(t.Context(), []model.TrackingEvent{{ID: "evt_1"}}); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Slow sink must time out: %v", elapsed)
+	}
+	if len(durable.events) != 1 {
+		t.Errorf("Durable sink must receive the event")
+	}
+}
+
+func TestNewFanOutStorage(t *testing.T) {
+	sink := &sinkRecorder{}
+	tests := []struct {
+		name  string
+		sinks []FanOutSink
+	}{
+		{"no required sink", []FanOutSink{{Name: "a", Storage: sink}}},
+		{"duplicated name", []FanOutSink{{Name: "a", Storage: sink, Required: true}, {Name: "a", Storage: sink}}},
+		{"unknown event type", []FanOutSink{{Name: "a", Storage: sink, Required: true, EventTypes: []model.TrackingEventType{"view"}}}},
+		{"no storage", []FanOutSink{{Name: "a", Required: true}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewFanOutStorage(zap.NewNop().Sugar(), tt.sinks...); err == nil {
+				t.Errorf("Sinks must be invalid")
+			}
+		})
+	}
+}
@@ -0,0 +1,212 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"sweng-task/internal/model"
+
+	"go.uber.org/zap"
+)
+
+// maxFrequencyCaps limits the number of frequency caps of a line item
+const maxFrequencyCaps = 5
+
+// FrequencyCounterStore keeps impression counters of users.
+// Counters of several service instances may be shared by an external store,
+// e.g. Redis INCR with EXPIREAT implements it.
+type FrequencyCounterStore interface {
+	// Increment increments a counter, the counter may be evicted after 'expireAt'
+	Increment(key string, expireAt time.Time) error
+	// Count returns the value of a counter, missing and expired counters are 0
+	Count(key string) (int, error)
+}
+
+// FrequencyCapService counts impressions of line items by users
+// and excludes line items which have reached their frequency caps.
+type FrequencyCapService struct {
+	lineItems *LineItemService
+	store     FrequencyCounterStore
+	clock     Clock
+
+	log *zap.SugaredLogger
+}
+
+// NewFrequencyCapService creates a new FrequencyCapService
+func NewFrequencyCapService(lineItems *LineItemService, store FrequencyCounterStore, clock Clock, log *zap.SugaredLogger) *FrequencyCapService {
+	return &FrequencyCapService{
+		lineItems: lineItems,
+		store:     store,
+		clock:     clock,
+		log:       log,
+	}
+}
+
+// OnTrackingEvent counts impressions of known users, implements TrackingEventListener
+func (s *FrequencyCapService) OnTrackingEvent(event model.TrackingEvent) {
+	if event.EventType != model.TrackingEventTypeImpression || event.UserID == "" {
+		return
+	}
+
+	item, err := s.lineItems.GetByID(event.LineItemID)
+	if err != nil {
+		s.log.Debugw("Impression of unknown line item is not counted",
+			"line_item_id", event.LineItemID,
+			"error", err,
+		)
+		return
+	}
+
+	// impressions are counted when they are tracked, the event timestamp comes from the client
+	now := s.clock.Now()
+	for _, fc := range item.FrequencyCaps {
+		start := frequencyWindowStart(now, fc)
+		if err := s.store.Increment(frequencyCounterKey(event.UserID, item.ID, fc, start), start.Add(frequencyWindow(fc))); err != nil {
+			s.log.Errorw("Cannot count impression",
+				"line_item_id", item.ID,
+				"error", err,
+			)
+		}
+	}
+}
+
+// Allow reports if a user may be shown a line item.
+// Line items are allowed if the counters are unavailable, capping is best effort.
+func (s *FrequencyCapService) Allow(userID string, item *model.LineItem) bool {
+	if userID == "" {
+		return true
+	}
+
+	now := s.clock.Now()
+	for _, fc := range item.FrequencyCaps {
+		count, err := s.store.Count(frequencyCounterKey(userID, item.ID, fc, frequencyWindowStart(now, fc)))
+		if err != nil {
+			s.log.Errorw("Cannot get impressions count",
+				"line_item_id", item.ID,
+				"error", err,
+			)
+			continue
+		}
+		if count >= fc.Impressions {
+			return false
+		}
+	}
+	return true
+}
+
+// frequencyCounterKey identifies the counter of a user, a line item and a window
+func frequencyCounterKey(userID, lineItemID string, fc model.FrequencyCap, start time.Time) string {
+	return strings.Join([]string{
+		lineItemID,
+		strconv.FormatInt(fc.WindowSeconds, 10),
+		strconv.FormatInt(start.Unix(), 10),
+		userID,
+	}, ":")
+}
+
+// frequencyWindowStart returns the start of the window of a frequency cap containing 't'
+func frequencyWindowStart(t time.Time, fc model.FrequencyCap) time.Time {
+	return t.Truncate(frequencyWindow(fc))
+}
+
+func frequencyWindow(fc model.FrequencyCap) time.Duration {
+	return time.Duration(fc.WindowSeconds) * time.Second
+}
+
+func validateFrequencyCaps(caps []model.FrequencyCap) error {
+	if len(caps) > maxFrequencyCaps {
+		return fmt.Errorf("%w: at most %d frequency caps are allowed", ErrInvalidLineItem, maxFrequencyCaps)
+	}
+	for _, fc := range caps {
+		if fc.Impressions <= 0 {
+			return fmt.Errorf("%w: frequency cap impressions must be a positive number", ErrInvalidLineItem)
+		}
+		if fc.WindowSeconds <= 0 {
+			return fmt.Errorf("%w: frequency cap window must be a positive number of seconds", ErrInvalidLineItem)
+		}
+	}
+	return nil
+}
+
+// MemoryFrequencyCounterStore keeps frequency counters in memory of a single service instance
+type MemoryFrequencyCounterStore struct {
+	clock Clock
+
+	mu       sync.Mutex
+	counters map[string]frequencyCounter
+}
+
+type frequencyCounter struct {
+	count    int
+	expireAt time.Time
+}
+
+// NewMemoryFrequencyCounterStore creates a new MemoryFrequencyCounterStore
+func NewMemoryFrequencyCounterStore(clock Clock) *MemoryFrequencyCounterStore {
+	return &MemoryFrequencyCounterStore{
+		clock:    clock,
+		counters: make(map[string]frequencyCounter),
+	}
+}
+
+// Increment increments a counter
+func (s *MemoryFrequencyCounterStore) Increment(key string, expireAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	counter, ok := s.counters[key]
+	if !ok || !s.clock.Now().Before(counter.expireAt) {
+		counter = frequencyCounter{}
+	}
+	counter.count++
+	counter.expireAt = expireAt
+	s.counters[key] = counter
+	return nil
+}
+
+// Count returns the value of a counter
+func (s *MemoryFrequencyCounterStore) Count(key string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	counter, ok := s.counters[key]
+	if !ok || !s.clock.Now().Before(counter.expireAt) {
+		return 0, nil
+	}
+	return counter.count, nil
+}
+
+// Evict deletes expired counters and returns the number of deleted ones
+func (s *MemoryFrequencyCounterStore) Evict() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.clock.Now()
+	var evicted int
+	for key, counter := range s.counters {
+		if !now.Before(counter.expireAt) {
+			delete(s.counters, key)
+			evicted++
+		}
+	}
+	return evicted
+}
+
+// EvictLoop periodically deletes expired counters
+func (s *MemoryFrequencyCounterStore) EvictLoop(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.Evict()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
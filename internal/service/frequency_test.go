@@ -0,0 +1,152 @@
+package service
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"sweng-task/internal/model"
+
+	"go.uber.org/zap"
+)
+
+func TestFrequencyCapService_Enforcement(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2025, 6, 2, 10, 30, 0, 0, time.UTC)}
+	log := zap.NewNop().Sugar()
+
+	lineItems := NewLineItemService(NewMemoryLineItemRepository(), NewMemoryLineItemHistory(), clock, log)
+	counters := NewMemoryFrequencyCounterStore(clock)
+	frequencyCaps := NewFrequencyCapService(lineItems, counters, clock, log)
+	ads := NewAdService(lineItems, log, WithFrequencyCaps(frequencyCaps))
+	tracking := NewTrackingService(100, nil, time.Second, log, WithTrackingEventListener(frequencyCaps))
+
+	// 2 impressions per hour and 3 per day
+	item, err := lineItems.Create(model.LineItemCreate{
+		Name:      "test_1",
+		Bid:       1,
+		Budget:    100,
+		Placement: "header",
+		FrequencyCaps: []model.FrequencyCap{
+			{Impressions: 2, WindowSeconds: 3600},
+			{Impressions: 3, WindowSeconds: 86400},
+		},
+	}, model.ChangeMeta{})
+	if err != nil {
+		t.Fatalf("Create line item: %v", err)
+	}
+
+	serve := func(userID string) int {
+		t.Helper()
+		got, err := ads.GetWinningAds(model.AdRequest{TargetingQuery: model.TargetingQuery{Placement: "header"}, Limit: 10, UserID: userID})
+		if err != nil {
+			t.Fatalf("Get winning ads: %v", err)
+		}
+		return len(got)
+	}
+	track := func(eventType model.TrackingEventType, userID string) {
+		t.Helper()
+		ok, err := tracking.RecordAdInteraction(model.TrackingEvent{EventType: eventType, LineItemID: item.ID, UserID: userID})
+		if !ok || err != nil {
+			t.Fatalf("Record ad interaction: %v, %v", ok, err)
+		}
+	}
+
+	track(model.TrackingEventTypeImpression, "user_1")
+	track(model.TrackingEventTypeClick, "user_1")
+	track(model.TrackingEventTypeImpression, "")
+	if serve("user_1") != 1 {
+		t.Errorf("Line item below the caps must be served")
+	}
+
+	track(model.TrackingEventTypeImpression, "user_1")
+	if serve("user_1") != 0 {
+		t.Errorf("Line item must not be served above the hourly cap")
+	}
+	if serve("user_2") != 1 {
+		t.Errorf("Caps of one user must not affect other users")
+	}
+	if serve("") != 1 {
+		t.Errorf("Caps must not apply to unknown users")
+	}
+
+	// the next hour window
+	clock.now = clock.now.Add(30 * time.Minute)
+	if serve("user_1") != 1 {
+		t.Errorf("Line item must be served in the next hour")
+	}
+
+	track(model.TrackingEventTypeImpression, "user_1")
+	if serve("user_1") != 0 {
+		t.Errorf("Line item must not be served above the daily cap")
+	}
+
+	// the hourly counter of the first hour has expired, the daily one is still used
+	if evicted := counters.Evict(); evicted != 1 {
+		t.Errorf("Wrong amount of evicted counters: %d != 1", evicted)
+	}
+	if serve("user_1") != 0 {
+		t.Errorf("Line item must not be served above the daily cap")
+	}
+
+	// the next day
+	clock.now = time.Date(2025, 6, 3, 0, 0, 0, 0, time.UTC)
+	if serve("user_1") != 1 {
+		t.Errorf("Line item must be served on the next day")
+	}
+	if evicted := counters.Evict(); evicted != 2 {
+		t.Errorf("Wrong amount of evicted counters: %d != 2", evicted)
+	}
+}
+
+func TestFrequencyCapService_StoreError(t *testing.T) {
+	store := failingFrequencyCounterStore{}
+	frequencyCaps := NewFrequencyCapService(nil, store, SystemClock{}, zap.NewNop().Sugar())
+
+	item := &model.LineItem{ID: "li_1", FrequencyCaps: []model.FrequencyCap{{Impressions: 1, WindowSeconds: 60}}}
+	if !frequencyCaps.Allow("user_1", item) {
+		t.Errorf("Line item must be allowed if counters are unavailable")
+	}
+}
+
+type failingFrequencyCounterStore struct{}
+
+func (failingFrequencyCounterStore) Increment(string, time.Time) error {
+	return errors.New("unavailable")
+}
+
+func (failingFrequencyCounterStore) Count(string) (int, error) {
+	return 0, errors.New("unavailable")
+}
+
+func TestLineItemService_FrequencyCapsValidation(t *testing.T) {
+	s := NewLineItemService(NewMemoryLineItemRepository(), NewMemoryLineItemHistory(), SystemClock{}, zap.NewNop().Sugar())
+
+	tests := []struct {
+		name    string
+		caps    []model.FrequencyCap
+		wantErr bool
+	}{
+		{"no caps", nil, false},
+		{"valid", []model.FrequencyCap{{Impressions: 3, WindowSeconds: 86400}}, false},
+		{"zero impressions", []model.FrequencyCap{{Impressions: 0, WindowSeconds: 86400}}, true},
+		{"negative window", []model.FrequencyCap{{Impressions: 3, WindowSeconds: -1}}, true},
+		{"too many", make([]model.FrequencyCap, maxFrequencyCaps+1), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			item, err := s.Create(model.LineItemCreate{Name: "test", Bid: 1, Budget: 100, Placement: "header", FrequencyCaps: tt.caps}, model.ChangeMeta{})
+			if tt.wantErr != errors.Is(err, ErrInvalidLineItem) {
+				t.Fatalf("Wrong create error: %v", err)
+			}
+			if err != nil {
+				return
+			}
+
+			caps := []model.FrequencyCap{{Impressions: 1}}
+			_, err = s.Update(item.ID, model.LineItemUpdate{FrequencyCaps: &caps}, item.Version, model.ChangeMeta{})
+			if !errors.Is(err, ErrInvalidLineItem) {
+				t.Errorf("Wrong update error: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,152 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+
+	"sweng-task/internal/model"
+)
+
+// LineItemHistory stores immutable audit records of line item changes.
+// Implementations must be safe for concurrent use.
+type LineItemHistory interface {
+	Append(change model.LineItemChange) error
+	// List returns up to 'limit' changes of a line item with versions greater than 'afterVersion',
+	// ordered by version
+	List(lineItemID string, afterVersion int64, limit int) ([]model.LineItemChange, error)
+}
+
+// MemoryLineItemHistory keeps line item changes in memory
+type MemoryLineItemHistory struct {
+	changes map[string][]model.LineItemChange
+	mu      sync.RWMutex
+}
+
+// NewMemoryLineItemHistory creates a new MemoryLineItemHistory
+func NewMemoryLineItemHistory() *MemoryLineItemHistory {
+	return &MemoryLineItemHistory{
+		changes: make(map[string][]model.LineItemChange),
+	}
+}
+
+// Append appends a change, changes of a line item are expected to be appended in the order of versions
+func (h *MemoryLineItemHistory) Append(change model.LineItemChange) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.changes[change.LineItemID] = append(h.changes[change.LineItemID], change)
+	return nil
+}
+
+// List returns up to 'limit' changes of a line item with versions greater than 'afterVersion'
+func (h *MemoryLineItemHistory) List(lineItemID string, afterVersion int64, limit int) ([]model.LineItemChange, error) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	changes := h.changes[lineItemID]
+	start := sort.Search(len(changes), func(i int) bool { return changes[i].Version > afterVersion })
+	end := min(start+limit, len(changes))
+
+	return append([]model.LineItemChange(nil), changes[start:end]...), nil
+}
+
+// auditedFields are line item fields tracked by the history, named after their JSON keys
+var auditedFields = []struct {
+	name  string
+	value func(item *model.LineItem) any
+}{
+	{"name", func(item *model.LineItem) any { return item.Name }},
+	{"advertiser_id", func(item *model.LineItem) any { return item.AdvertiserID }},
+	{"bid", func(item *model.LineItem) any { return item.Bid }},
+	{"budget", func(item *model.LineItem) any { return item.Budget }},
+	{"placement", func(item *model.LineItem) any { return item.Placement }},
+	{"categories", func(item *model.LineItem) any { return item.Categories }},
+	{"keywords", func(item *model.LineItem) any { return item.Keywords }},
+	{"landing_url", func(item *model.LineItem) any { return item.LandingURL }},
+	{"start_at", func(item *model.LineItem) any { return item.StartAt }},
+	{"end_at", func(item *model.LineItem) any { return item.EndAt }},
+	{"schedule", func(item *model.LineItem) any { return item.Schedule }},
+	{"pacing", func(item *model.LineItem) any { return item.Pacing }},
+	{"frequency_caps", func(item *model.LineItem) any { return item.FrequencyCaps }},
+	{"status", func(item *model.LineItem) any { return item.Status }},
+}
+
+// diffLineItems returns changed fields between two revisions of a line item,
+// nil 'old' means the line item is created and all fields are reported
+func diffLineItems(old, new *model.LineItem) ([]model.FieldChange, error) {
+	var changes []model.FieldChange
+	for _, field := range auditedFields {
+		newValue, err := json.Marshal(field.value(new))
+		if err != nil {
+			return nil, fmt.Errorf("encode field %q: %w", field.name, err)
+		}
+
+		var oldValue json.RawMessage
+		if old != nil {
+			oldValue, err = json.Marshal(field.value(old))
+			if err != nil {
+				return nil, fmt.Errorf("encode field %q: %w", field.name, err)
+			}
+			if bytes.Equal(oldValue, newValue) {
+				continue
+			}
+		}
+
+		changes = append(changes, model.FieldChange{
+			Field: field.name,
+			Old:   oldValue,
+			New:   newValue,
+		})
+	}
+	return changes, nil
+}
+
+// lineItemRevision rebuilds audited fields of a line item as they were at 'version'
+// by replaying its history from the creation. It also returns the fields recorded in the history,
+// revisions recorded before a field was audited don't have it.
+func lineItemRevision(history LineItemHistory, id string, version int64) (*model.LineItem, map[string]json.RawMessage, error) {
+	fields := make(map[string]json.RawMessage)
+
+	var after int64
+pages:
+	for after < version {
+		changes, err := history.List(id, after, 100)
+		if err != nil {
+			return nil, nil, fmt.Errorf("list history: %w", err)
+		}
+		if len(changes) == 0 {
+			break
+		}
+
+		for _, change := range changes {
+			if change.Version > version {
+				break pages
+			}
+			if after == 0 && change.Action != model.LineItemChangeActionCreate {
+				// line items created before the history was recorded have diffs of later changes only,
+				// the rest of their fields is unknown
+				return nil, nil, fmt.Errorf("%w: revision %d cannot be restored, the creation of the line item is not recorded", ErrInvalidLineItem, version)
+			}
+			for _, fc := range change.Changes {
+				fields[fc.Field] = fc.New
+			}
+			after = change.Version
+		}
+	}
+	if version < 1 || after != version {
+		return nil, nil, fmt.Errorf("%w: revision %d is not found", ErrInvalidLineItem, version)
+	}
+
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return nil, nil, fmt.Errorf("encode revision: %w", err)
+	}
+	var item model.LineItem
+	if err := json.Unmarshal(data, &item); err != nil {
+		return nil, nil, fmt.Errorf("decode revision: %w", err)
+	}
+	return &item, fields, nil
+}
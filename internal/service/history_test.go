@@ -0,0 +1,225 @@
+package service
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	"sweng-task/internal/model"
+
+	"go.uber.org/zap"
+)
+
+func TestLineItemService_History(t *testing.T) {
+	s := NewLineItemService(NewMemoryLineItemRepository(), NewMemoryLineItemHistory(), SystemClock{}, zap.NewNop().Sugar())
+	item := newTestLineItem(t, s)
+
+	meta := model.ChangeMeta{Actor: "alice", RequestID: "req_1"}
+	bid := 5.0
+	updated, err := s.Update(item.ID, model.LineItemUpdate{Bid: &bid}, item.Version, meta)
+	if err != nil {
+		t.Fatalf("Update line item: %v", err)
+	}
+	if _, err := s.SetStatus(item.ID, model.LineItemStatusPaused, updated.Version, meta); err != nil {
+		t.Fatalf("Pause line item: %v", err)
+	}
+
+	changes, err := s.History(item.ID, 0, 10)
+	if err != nil {
+		t.Fatalf("List history: %v", err)
+	}
+	if len(changes) != 3 {
+		t.Fatalf("Wrong amount of changes: %d != 3", len(changes))
+	}
+
+	actions := []model.LineItemChangeAction{changes[0].Action, changes[1].Action, changes[2].Action}
+	if !slices.Equal(actions, []model.LineItemChangeAction{model.LineItemChangeActionCreate, model.LineItemChangeActionUpdate, model.LineItemChangeActionStatus}) {
+		t.Errorf("Wrong actions: %v", actions)
+	}
+
+	bidChange := changes[1]
+	if bidChange.Actor != "alice" || bidChange.RequestID != "req_1" || bidChange.Version != 2 {
+		t.Errorf("Wrong change meta: %+v", bidChange)
+	}
+	if len(bidChange.Changes) != 1 || bidChange.Changes[0].Field != "bid" ||
+		string(bidChange.Changes[0].Old) != "2" || string(bidChange.Changes[0].New) != "5" {
+		t.Errorf("Wrong field diff: %+v", bidChange.Changes)
+	}
+
+	// paging
+	page, _ := s.History(item.ID, 1, 1)
+	if len(page) != 1 || page[0].Version != 2 {
+		t.Errorf("Wrong page: %+v", page)
+	}
+}
+
+func TestLineItemService_Restore(t *testing.T) {
+	s := NewLineItemService(NewMemoryLineItemRepository(), NewMemoryLineItemHistory(), SystemClock{}, zap.NewNop().Sugar())
+	item := newTestLineItem(t, s)
+
+	bid := 5.0
+	keywords := []string{"winter"}
+	updated, err := s.Update(item.ID, model.LineItemUpdate{Bid: &bid, Keywords: &keywords}, item.Version, model.ChangeMeta{})
+	if err != nil {
+		t.Fatalf("Update line item: %v", err)
+	}
+	paused, err := s.SetStatus(item.ID, model.LineItemStatusPaused, updated.Version, model.ChangeMeta{})
+	if err != nil {
+		t.Fatalf("Pause line item: %v", err)
+	}
+
+	restored, err := s.Restore(item.ID, 1, paused.Version, model.ChangeMeta{})
+	if err != nil {
+		t.Fatalf("Restore line item: %v", err)
+	}
+	if restored.Bid != item.Bid || !slices.Equal(restored.Keywords, item.Keywords) {
+		t.Errorf("Wrong restored line item: %+v", restored)
+	}
+	if restored.Status != model.LineItemStatusPaused {
+		t.Errorf("Status must not be restored: %s", restored.Status)
+	}
+	if restored.Version != paused.Version+1 {
+		t.Errorf("Restore must produce a new version: %d", restored.Version)
+	}
+
+	_, err = s.Restore(item.ID, 100, 0, model.ChangeMeta{})
+	if err == nil {
+		t.Errorf("Unknown revision is restored")
+	}
+}
+
+func TestLineItemService_RestoreWithoutCreation(t *testing.T) {
+	repo := NewMemoryLineItemRepository()
+	s := NewLineItemService(repo, NewMemoryLineItemHistory(), SystemClock{}, zap.NewNop().Sugar())
+
+	// the line item was stored before its history was recorded
+	item := &model.LineItem{ID: "li_1", Name: "test_1", Bid: 2, Budget: 1000, Placement: "header", Pacing: model.PacingModeASAP, Status: model.LineItemStatusActive, Version: 1}
+	if err := repo.Create(item); err != nil {
+		t.Fatalf("Create line item: %v", err)
+	}
+	bid := 5.0
+	updated, err := s.Update(item.ID, model.LineItemUpdate{Bid: &bid}, item.Version, model.ChangeMeta{})
+	if err != nil {
+		t.Fatalf("Update line item: %v", err)
+	}
+
+	// the revision has the bid only, the rest of its fields is unknown
+	if _, err := s.Restore(item.ID, 2, updated.Version, model.ChangeMeta{}); !errors.Is(err, ErrInvalidLineItem) {
+		t.Errorf("Wrong error: %v", err)
+	}
+	if stored, _ := repo.GetByID(item.ID); stored.Name != "test_1" || stored.Placement != "header" || stored.Budget != 1000 || stored.Version != updated.Version {
+		t.Errorf("Line item must not be changed: %+v", stored)
+	}
+}
+
+func TestLineItemService_RestoreLandingURL(t *testing.T) {
+	s := NewLineItemService(NewMemoryLineItemRepository(), NewMemoryLineItemHistory(), SystemClock{}, zap.NewNop().Sugar())
+	item := newTestLineItem(t, s)
+
+	// a landing page only edit is recorded
+	landingURL := "https://example.com/summer"
+	updated, err := s.Update(item.ID, model.LineItemUpdate{LandingURL: &landingURL}, item.Version, model.ChangeMeta{})
+	if err != nil {
+		t.Fatalf("Update line item: %v", err)
+	}
+	changes, _ := s.History(item.ID, item.Version, 10)
+	if len(changes) != 1 || len(changes[0].Changes) != 1 || changes[0].Changes[0].Field != "landing_url" {
+		t.Fatalf("Landing page change must be recorded: %+v", changes)
+	}
+
+	// the landing page survives a restore of an edit of other fields
+	bid := 5.0
+	updated, err = s.Update(item.ID, model.LineItemUpdate{Bid: &bid}, updated.Version, model.ChangeMeta{})
+	if err != nil {
+		t.Fatalf("Update line item: %v", err)
+	}
+	restored, err := s.Restore(item.ID, 2, updated.Version, model.ChangeMeta{})
+	if err != nil {
+		t.Fatalf("Restore line item: %v", err)
+	}
+	if restored.Bid != 2 || restored.LandingURL != landingURL {
+		t.Errorf("Landing page must survive the restore: %+v", restored)
+	}
+
+	// the creation had no landing page
+	restored, err = s.Restore(item.ID, 1, restored.Version, model.ChangeMeta{})
+	if err != nil {
+		t.Fatalf("Restore line item: %v", err)
+	}
+	if restored.LandingURL != "" {
+		t.Errorf("Landing page must be restored: %q", restored.LandingURL)
+	}
+}
+
+func TestLineItemService_RestoreFrequencyCaps(t *testing.T) {
+	s := NewLineItemService(NewMemoryLineItemRepository(), NewMemoryLineItemHistory(), SystemClock{}, zap.NewNop().Sugar())
+	item, err := s.Create(model.LineItemCreate{
+		Name:          "test_1",
+		AdvertiserID:  "ad_1",
+		Bid:           2,
+		Budget:        1000,
+		Placement:     "header",
+		FrequencyCaps: []model.FrequencyCap{{Impressions: 3, WindowSeconds: 3600}},
+	}, model.ChangeMeta{})
+	if err != nil {
+		t.Fatalf("Create line item: %v", err)
+	}
+
+	// a caps-only edit is recorded
+	caps := []model.FrequencyCap{{Impressions: 1, WindowSeconds: 60}}
+	updated, err := s.Update(item.ID, model.LineItemUpdate{FrequencyCaps: &caps}, item.Version, model.ChangeMeta{})
+	if err != nil {
+		t.Fatalf("Update line item: %v", err)
+	}
+	changes, _ := s.History(item.ID, item.Version, 10)
+	if len(changes) != 1 || len(changes[0].Changes) != 1 || changes[0].Changes[0].Field != "frequency_caps" {
+		t.Fatalf("Caps change must be recorded: %+v", changes)
+	}
+
+	// caps survive a restore of an edit of other fields
+	bid := 5.0
+	updated, err = s.Update(item.ID, model.LineItemUpdate{Bid: &bid}, updated.Version, model.ChangeMeta{})
+	if err != nil {
+		t.Fatalf("Update line item: %v", err)
+	}
+	restored, err := s.Restore(item.ID, 2, updated.Version, model.ChangeMeta{})
+	if err != nil {
+		t.Fatalf("Restore line item: %v", err)
+	}
+	if restored.Bid != 2 || !slices.Equal(restored.FrequencyCaps, caps) {
+		t.Errorf("Caps must survive the restore: %+v", restored)
+	}
+
+	// the caps of the creation are restored
+	restored, err = s.Restore(item.ID, 1, restored.Version, model.ChangeMeta{})
+	if err != nil {
+		t.Fatalf("Restore line item: %v", err)
+	}
+	if !slices.Equal(restored.FrequencyCaps, item.FrequencyCaps) {
+		t.Errorf("Caps must be restored: %+v", restored.FrequencyCaps)
+	}
+}
+
+type failingHistory struct {
+	*MemoryLineItemHistory
+}
+
+func (failingHistory) Append(model.LineItemChange) error {
+	return errors.New("disk is full")
+}
+
+func TestLineItemService_HistoryFailure(t *testing.T) {
+	repo := NewMemoryLineItemRepository()
+	s := NewLineItemService(repo, failingHistory{NewMemoryLineItemHistory()}, SystemClock{}, zap.NewNop().Sugar())
+	item := newTestLineItem(t, s)
+
+	// the line item is stored already, so the change is reported as successful
+	bid := 5.0
+	updated, err := s.Update(item.ID, model.LineItemUpdate{Bid: &bid}, item.Version, model.ChangeMeta{})
+	if err != nil {
+		t.Fatalf("Update must succeed: %v", err)
+	}
+	if stored, _ := repo.GetByID(item.ID); stored.Bid != 5 || stored.Version != updated.Version {
+		t.Errorf("Line item must be updated: %+v", stored)
+	}
+}
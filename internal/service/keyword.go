@@ -0,0 +1,272 @@
+package service
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"unicode"
+
+	"sweng-task/internal/model"
+
+	"github.com/kljensen/snowball/english"
+)
+
+// KeywordMatchType defines how a line item keyword matches requested keywords
+type KeywordMatchType string
+
+const (
+	// KeywordMatchBroad matches requests containing all words of the keyword in any order,
+	// words are compared by their English stems: "running shoes" matches "shoe run"
+	KeywordMatchBroad KeywordMatchType = "broad"
+	// KeywordMatchPhrase matches requests containing the words of the keyword in the same order:
+	// "summer sale" matches "big summer sale", but not "sale summer"
+	KeywordMatchPhrase KeywordMatchType = "phrase"
+	// KeywordMatchExact matches requests consisting of the words of the keyword only
+	KeywordMatchExact KeywordMatchType = "exact"
+)
+
+// maxKeywordLength limits the length of a line item keyword
+const maxKeywordLength = 100
+
+// Keyword is a parsed line item keyword.
+// Keywords are written in the notation of search ads:
+// "summer sale" (with quotes) is a phrase match, [summer sale] is an exact match,
+// summer sale without quotes or brackets is a broad match.
+// A leading minus makes a negative keyword: -free, -"free shipping", -[free].
+type Keyword struct {
+	Match    KeywordMatchType
+	Negative bool
+	words    []string // normalized words
+	stems    []string // stems of the words, used by broad matches
+}
+
+// ParseKeyword parses a line item keyword, all errors wrap ErrInvalidLineItem
+func ParseKeyword(s string) (Keyword, error) {
+	raw := s
+	var kw Keyword
+
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, "-") {
+		kw.Negative = true
+		s = strings.TrimSpace(s[1:])
+		if strings.HasPrefix(s, "-") {
+			return Keyword{}, fmt.Errorf("%w: keyword %q has a double minus", ErrInvalidLineItem, raw)
+		}
+	}
+
+	switch {
+	case strings.HasPrefix(s, "[") || strings.HasSuffix(s, "]"):
+		if len(s) < 2 || !strings.HasPrefix(s, "[") || !strings.HasSuffix(s, "]") {
+			return Keyword{}, fmt.Errorf("%w: keyword %q has an unbalanced bracket", ErrInvalidLineItem, raw)
+		}
+		kw.Match = KeywordMatchExact
+		s = s[1 : len(s)-1]
+	case strings.HasPrefix(s, `"`) || strings.HasSuffix(s, `"`):
+		if len(s) < 2 || !strings.HasPrefix(s, `"`) || !strings.HasSuffix(s, `"`) {
+			return Keyword{}, fmt.Errorf("%w: keyword %q has an unbalanced quote", ErrInvalidLineItem, raw)
+		}
+		kw.Match = KeywordMatchPhrase
+		s = s[1 : len(s)-1]
+	default:
+		kw.Match = KeywordMatchBroad
+	}
+
+	if strings.ContainsAny(s, `[]"`) {
+		return Keyword{}, fmt.Errorf("%w: keyword %q contains a misplaced bracket or quote", ErrInvalidLineItem, raw)
+	}
+	if len(s) > maxKeywordLength {
+		return Keyword{}, fmt.Errorf("%w: keyword %q is longer than %d characters", ErrInvalidLineItem, raw, maxKeywordLength)
+	}
+
+	kw.words = normalizeWords(s)
+	if len(kw.words) == 0 {
+		return Keyword{}, fmt.Errorf("%w: keyword %q has no words", ErrInvalidLineItem, raw)
+	}
+	kw.stems = stemWords(kw.words)
+	return kw, nil
+}
+
+// Matches reports if the keyword matches a requested keyword.
+// Negative keywords match the same way, it is up to the caller to exclude the line item.
+func (kw Keyword) Matches(request RequestKeyword) bool {
+	switch kw.Match {
+	case KeywordMatchExact:
+		return slices.Equal(kw.words, request.words)
+	case KeywordMatchPhrase:
+		for i := 0; i+len(kw.words) <= len(request.words); i++ {
+			if slices.Equal(kw.words, request.words[i:i+len(kw.words)]) {
+				return true
+			}
+		}
+		return false
+	default:
+		for _, stem := range kw.stems {
+			if !slices.Contains(request.stems, stem) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// RequestKeyword is a normalized keyword of an ad request
+type RequestKeyword struct {
+	words []string
+	stems []string
+}
+
+// NewRequestKeyword normalizes a keyword of an ad request, it is matched literally, without any notation
+func NewRequestKeyword(s string) RequestKeyword {
+	words := normalizeWords(s)
+	return RequestKeyword{
+		words: words,
+		stems: stemWords(words),
+	}
+}
+
+// KeywordTerms returns the stems of the words of a line item keyword or a requested keyword,
+// a line item keyword may match a request only if they have a common term.
+// The notation of line item keywords is ignored.
+func KeywordTerms(s string) []string {
+	return stemWords(normalizeWords(s))
+}
+
+// lineItemKeywords are parsed keywords of a line item, invalid keywords are skipped
+type lineItemKeywords struct {
+	raw      []string
+	positive []Keyword
+	negative []Keyword
+	terms    []string // distinct terms of positive keywords
+}
+
+// parseLineItemKeywords parses keywords of a line item, they are expected to be validated
+func parseLineItemKeywords(keywords []string) *lineItemKeywords {
+	parsed := &lineItemKeywords{raw: keywords}
+	for _, s := range keywords {
+		kw, err := ParseKeyword(s)
+		if err == nil && kw.Negative {
+			parsed.negative = append(parsed.negative, kw)
+			continue
+		}
+		if err == nil {
+			parsed.positive = append(parsed.positive, kw)
+		}
+		for _, term := range KeywordTerms(s) {
+			if !slices.Contains(parsed.terms, term) {
+				parsed.terms = append(parsed.terms, term)
+			}
+		}
+	}
+	return parsed
+}
+
+// keywordCache keeps parsed keywords of line items by their IDs, so keywords are parsed
+// once they change instead of on every ad request. Entries are removed once their line items change,
+// so only line items served since their last change are kept.
+type keywordCache struct {
+	mu    sync.RWMutex
+	items map[string]*lineItemKeywords
+}
+
+func newKeywordCache() *keywordCache {
+	return &keywordCache{
+		items: make(map[string]*lineItemKeywords),
+	}
+}
+
+// get returns parsed keywords of a line item, they are parsed again if the keywords have changed
+func (c *keywordCache) get(item *model.LineItem) *lineItemKeywords {
+	c.mu.RLock()
+	parsed, ok := c.items[item.ID]
+	c.mu.RUnlock()
+	if ok && slices.Equal(parsed.raw, item.Keywords) {
+		return parsed
+	}
+
+	parsed = parseLineItemKeywords(item.Keywords)
+	c.mu.Lock()
+	c.items[item.ID] = parsed
+	c.mu.Unlock()
+	return parsed
+}
+
+// invalidate removes parsed keywords of a changed line item
+func (c *keywordCache) invalidate(id string) {
+	c.mu.Lock()
+	delete(c.items, id)
+	c.mu.Unlock()
+}
+
+// KeywordIndexTerms returns distinct terms of positive keywords of a line item,
+// the line item may match requested keywords only if it has one of their terms
+func KeywordIndexTerms(item *model.LineItem) []string {
+	return parseLineItemKeywords(item.Keywords).terms
+}
+
+// matchKeywords reports if line item keywords match requested keywords:
+// none of the negative keywords may match any requested keyword, and
+// every (MatchAll) or some (MatchAny) requested keyword must be matched by a positive keyword.
+func matchKeywords(keywords *lineItemKeywords, requested []RequestKeyword, match model.MatchMode) bool {
+	if len(requested) == 0 {
+		return true
+	}
+
+	for _, kw := range keywords.negative {
+		if slices.ContainsFunc(requested, kw.Matches) {
+			return false
+		}
+	}
+
+	matched := func(request RequestKeyword) bool {
+		return slices.ContainsFunc(keywords.positive, func(kw Keyword) bool { return kw.Matches(request) })
+	}
+	if match == model.MatchAll {
+		return !slices.ContainsFunc(requested, func(request RequestKeyword) bool { return !matched(request) })
+	}
+	return slices.ContainsFunc(requested, matched)
+}
+
+// requestKeywords normalizes keywords of an ad request
+func requestKeywords(keywords []string) []RequestKeyword {
+	result := make([]RequestKeyword, len(keywords))
+	for i, s := range keywords {
+		result[i] = NewRequestKeyword(s)
+	}
+	return result
+}
+
+// validateKeywords validates line item keywords, a line item must have a positive keyword to have negative ones
+func validateKeywords(keywords []string) error {
+	var positive, negative bool
+	for _, s := range keywords {
+		kw, err := ParseKeyword(s)
+		if err != nil {
+			return err
+		}
+		if kw.Negative {
+			negative = true
+		} else {
+			positive = true
+		}
+	}
+	if negative && !positive {
+		return fmt.Errorf("%w: negative keywords require at least one positive keyword", ErrInvalidLineItem)
+	}
+	return nil
+}
+
+// normalizeWords lowercases a text and splits it into words, punctuation separates words
+func normalizeWords(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '\''
+	})
+}
+
+func stemWords(words []string) []string {
+	stems := make([]string, len(words))
+	for i, word := range words {
+		stems[i] = english.Stem(word, true)
+	}
+	return stems
+}
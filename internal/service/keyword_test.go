@@ -0,0 +1,317 @@
+package service
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	"sweng-task/internal/model"
+
+	"go.uber.org/zap"
+)
+
+func TestParseKeyword(t *testing.T) {
+	tests := []struct {
+		keyword      string
+		wantMatch    KeywordMatchType
+		wantNegative bool
+		wantErr      bool
+	}{
+		{"summer", KeywordMatchBroad, false, false},
+		{"summer sale", KeywordMatchBroad, false, false},
+		{"  Summer   Sale ", KeywordMatchBroad, false, false},
+		{`"summer sale"`, KeywordMatchPhrase, false, false},
+		{"[summer sale]", KeywordMatchExact, false, false},
+		{"-free", KeywordMatchBroad, true, false},
+		{"- free", KeywordMatchBroad, true, false},
+		{`-"free shipping"`, KeywordMatchPhrase, true, false},
+		{"-[free]", KeywordMatchExact, true, false},
+		{"men's shoes", KeywordMatchBroad, false, false},
+		{"4k tv", KeywordMatchBroad, false, false},
+		{"café", KeywordMatchBroad, false, false},
+
+		{"", "", false, true},
+		{"   ", "", false, true},
+		{"-", "", false, true},
+		{"[]", "", false, true},
+		{`""`, "", false, true},
+		{"[ ]", "", false, true},
+		{"!!!", "", false, true},
+		{"[summer", "", false, true},
+		{"summer]", "", false, true},
+		{`"summer`, "", false, true},
+		{`summer"`, "", false, true},
+		{`"summer]`, "", false, true},
+		{"[summer] sale", "", false, true},
+		{`summer "sale"`, "", false, true},
+		{`["summer"]`, "", false, true},
+		{`"[summer]"`, "", false, true},
+		{"--free", "", false, true},
+		{string(make([]byte, maxKeywordLength+1)), "", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.keyword, func(t *testing.T) {
+			kw, err := ParseKeyword(tt.keyword)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidLineItem) {
+					t.Errorf("Wrong error: %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Parse keyword: %v", err)
+			}
+			if kw.Match != tt.wantMatch || kw.Negative != tt.wantNegative {
+				t.Errorf("Wrong keyword: %+v", kw)
+			}
+		})
+	}
+}
+
+func TestKeyword_Matches(t *testing.T) {
+	tests := []struct {
+		keyword string
+		request string
+		want    bool
+	}{
+		// broad: all words in any order, normalized and stemmed
+		{"summer", "summer", true},
+		{"summer", "Summer", true},
+		{"summer", "SUMMER!", true},
+		{"Summer", "summer", true},
+		{"summer", "summer sale", true},
+		{"summer", "big summer sale", true},
+		{"summer", "winter", false},
+		{"summer", "summers", true},
+		{"summer sale", "sale summer", true},
+		{"summer sale", "summer", false},
+		{"summer sale", "summer dresses on sale", true},
+		{"running shoes", "run shoe", true},
+		{"running shoes", "shoes for running", true},
+		{"running shoes", "runner shoes", false},
+		{"shoe", "shoes", true},
+		{"shoes", "shoe", true},
+		{"connection", "connected", true},
+		{"connections", "connect", true},
+		{"happy", "happiness", true},
+		{"shoe", "shoelace", false},
+		{"generously", "generous", true},
+		{"lego", "legos", true},
+		{"summer-sale", "summer sale", true},
+		{"summer sale", "summer-sale", true},
+		{"men's shoes", "men's shoe", true},
+		{"4k tv", "4K TV!", true},
+		{"4k tv", "tv", false},
+		{"café", "Café", true},
+
+		// phrase: words in the same order, normalized but not stemmed
+		{`"summer sale"`, "summer sale", true},
+		{`"summer sale"`, "Summer Sale", true},
+		{`"summer sale"`, "big summer sale today", true},
+		{`"summer sale"`, "summer sale", true},
+		{`"summer sale"`, "sale summer", false},
+		{`"summer sale"`, "summer big sale", false},
+		{`"summer sale"`, "summer", false},
+		{`"summer sale"`, "summer sales", false},
+		{`"summer sale"`, "summer, sale!", true},
+		{`"summer"`, "summer", true},
+		{`"summer"`, "summers", false},
+		{`"summer"`, "early summer", true},
+
+		// exact: the same words only
+		{"[summer sale]", "summer sale", true},
+		{"[summer sale]", "Summer  SALE", true},
+		{"[summer sale]", "summer sale!", true},
+		{"[summer sale]", "big summer sale", false},
+		{"[summer sale]", "summer sale today", false},
+		{"[summer sale]", "sale summer", false},
+		{"[summer sale]", "summer sales", false},
+		{"[summer]", "summer", true},
+		{"[summer]", "summer sale", false},
+
+		// negative keywords match the same way
+		{"-free", "free shipping", true},
+		{"-free", "Free", true},
+		{"-free", "paid shipping", false},
+		{`-"free shipping"`, "get free shipping", true},
+		{`-"free shipping"`, "shipping free", false},
+		{"-[free]", "free", true},
+		{"-[free]", "free shipping", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.keyword+" ~ "+tt.request, func(t *testing.T) {
+			kw, err := ParseKeyword(tt.keyword)
+			if err != nil {
+				t.Fatalf("Parse keyword: %v", err)
+			}
+			if got := kw.Matches(NewRequestKeyword(tt.request)); got != tt.want {
+				t.Errorf("Matches() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatchKeywords(t *testing.T) {
+	tests := []struct {
+		name      string
+		keywords  []string
+		requested []string
+		match     model.MatchMode
+		want      bool
+	}{
+		{"no requested keywords", []string{"summer"}, nil, model.MatchAny, true},
+		{"no requested keywords, negative", []string{"summer", "-free"}, nil, model.MatchAny, true},
+		{"no line item keywords", nil, []string{"summer"}, model.MatchAny, false},
+		{"any matches", []string{"summer"}, []string{"winter", "summer"}, model.MatchAny, true},
+		{"any doesn't match", []string{"summer"}, []string{"winter", "autumn"}, model.MatchAny, false},
+		{"all matches", []string{"summer", `"big sale"`}, []string{"summer", "big sale"}, model.MatchAll, true},
+		{"all doesn't match", []string{"summer"}, []string{"summer", "sale"}, model.MatchAll, false},
+		{"all by one keyword", []string{"sale"}, []string{"summer sale", "winter sale"}, model.MatchAll, true},
+		{"negative excludes", []string{"shoes", "-free"}, []string{"free shoes"}, model.MatchAny, false},
+		{"negative excludes other requested keyword", []string{"shoes", "-free"}, []string{"shoes", "free"}, model.MatchAny, false},
+		{"negative doesn't match", []string{"shoes", "-free"}, []string{"cheap shoes"}, model.MatchAny, true},
+		{"negative phrase excludes", []string{"shoes", `-"free shipping"`}, []string{"shoes free shipping"}, model.MatchAny, false},
+		{"negative phrase doesn't match", []string{"shoes", `-"free shipping"`}, []string{"free shoes shipping"}, model.MatchAny, true},
+		{"negative exact excludes", []string{"shoes", "-[free shoes]"}, []string{"free shoes"}, model.MatchAny, false},
+		{"negative exact doesn't match", []string{"shoes", "-[free shoes]"}, []string{"free shoes today"}, model.MatchAny, true},
+		{"negative stemmed", []string{"shoes", "-running"}, []string{"shoes for runners", "run shoes"}, model.MatchAny, false},
+		{"exact among broad", []string{"[shoes]", "boots"}, []string{"red shoes"}, model.MatchAny, false},
+		{"invalid keyword never matches", []string{"[shoes"}, []string{"shoes"}, model.MatchAny, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := matchKeywords(parseLineItemKeywords(tt.keywords), requestKeywords(tt.requested), tt.match); got != tt.want {
+				t.Errorf("matchKeywords() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKeywordCache(t *testing.T) {
+	cache := newKeywordCache()
+	item := &model.LineItem{ID: "li_1", Keywords: []string{"summer", "-free"}}
+
+	parsed := cache.get(item)
+	if len(parsed.positive) != 1 || len(parsed.negative) != 1 {
+		t.Fatalf("Wrong parsed keywords: %+v", parsed)
+	}
+	if cache.get(item) != parsed {
+		t.Errorf("Keywords must be parsed once")
+	}
+
+	// a new revision of the line item has other keywords
+	updated := *item
+	updated.Keywords = []string{"winter"}
+	if got := cache.get(&updated); len(got.positive) != 1 || !slices.Equal(got.terms, []string{"winter"}) {
+		t.Errorf("Changed keywords must be parsed again: %+v", got)
+	}
+}
+
+func TestLineItemService_KeywordCacheInvalidation(t *testing.T) {
+	s := NewLineItemService(NewMemoryLineItemRepository(), NewMemoryLineItemHistory(), SystemClock{}, zap.NewNop().Sugar())
+	item := newTestLineItem(t, s)
+	cached := func() bool {
+		s.keywords.mu.RLock()
+		defer s.keywords.mu.RUnlock()
+		_, ok := s.keywords.items[item.ID]
+		return ok
+	}
+
+	query := model.TargetingQuery{Placement: "header", Keywords: []string{"summer"}}
+	if matching, _ := s.FindMatchingLineItems(query); len(matching) != 1 || !cached() {
+		t.Fatalf("Keywords of a matched line item must be cached: %d", len(matching))
+	}
+
+	bid := 3.0
+	item, err := s.Update(item.ID, model.LineItemUpdate{Bid: &bid}, item.Version, model.ChangeMeta{})
+	if err != nil {
+		t.Fatalf("Update line item: %v", err)
+	}
+	if cached() {
+		t.Errorf("Keywords of an updated line item must be removed")
+	}
+
+	if matching, _ := s.FindMatchingLineItems(query); len(matching) != 1 || !cached() {
+		t.Fatalf("Keywords of a matched line item must be cached: %d", len(matching))
+	}
+	if _, err := s.Archive(item.ID, item.Version, model.ChangeMeta{}); err != nil {
+		t.Fatalf("Archive line item: %v", err)
+	}
+	if cached() {
+		t.Errorf("Keywords of an archived line item must be removed")
+	}
+}
+
+func TestLineItemService_KeywordValidation(t *testing.T) {
+	s := NewLineItemService(NewMemoryLineItemRepository(), NewMemoryLineItemHistory(), SystemClock{}, zap.NewNop().Sugar())
+
+	tests := []struct {
+		name     string
+		keywords []string
+		wantErr  bool
+	}{
+		{"valid", []string{"summer", `"summer sale"`, "[sale]", "-free"}, false},
+		{"unbalanced bracket", []string{"[summer"}, true},
+		{"empty", []string{""}, true},
+		{"only negative", []string{"-free"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			item, err := s.Create(model.LineItemCreate{Name: "test", Bid: 1, Budget: 100, Placement: "header", Keywords: tt.keywords}, model.ChangeMeta{})
+			if tt.wantErr != errors.Is(err, ErrInvalidLineItem) {
+				t.Fatalf("Wrong create error: %v", err)
+			}
+			if err != nil {
+				return
+			}
+
+			keywords := []string{"[summer"}
+			_, err = s.Update(item.ID, model.LineItemUpdate{Keywords: &keywords}, item.Version, model.ChangeMeta{})
+			if !errors.Is(err, ErrInvalidLineItem) {
+				t.Errorf("Wrong update error: %v", err)
+			}
+		})
+	}
+}
+
+func TestLineItemService_FindMatchingLineItems_Keywords(t *testing.T) {
+	s := NewLineItemService(NewMemoryLineItemRepository(), NewMemoryLineItemHistory(), SystemClock{}, zap.NewNop().Sugar())
+
+	for _, keywords := range [][]string{
+		{"running shoes", "-free"},
+		{`"summer sale"`},
+		{"[lego]"},
+	} {
+		if _, err := s.Create(model.LineItemCreate{Name: keywords[0], Bid: 1, Budget: 100, Placement: "header", Keywords: keywords}, model.ChangeMeta{}); err != nil {
+			t.Fatalf("Create line item: %v", err)
+		}
+	}
+
+	tests := []struct {
+		keyword string
+		want    []string
+	}{
+		{"Shoes for Running", []string{"running shoes"}},
+		{"free running shoes", nil},
+		{"big summer sale", []string{`"summer sale"`}},
+		{"sale summer", nil},
+		{"LEGO", []string{"[lego]"}},
+		{"lego sets", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.keyword, func(t *testing.T) {
+			items, err := s.FindMatchingLineItems(model.TargetingQuery{Placement: "header", Keywords: []string{tt.keyword}})
+			if err != nil {
+				t.Fatalf("Find matching line items: %v", err)
+			}
+
+			var names []string
+			for _, item := range items {
+				names = append(names, item.Name)
+			}
+			if len(names) != len(tt.want) || (len(names) > 0 && names[0] != tt.want[0]) {
+				t.Errorf("Wrong matching line items: %q != %q", names, tt.want)
+			}
+		})
+	}
+}
@@ -1,9 +1,12 @@
 package service
 
 import (
+	"cmp"
 	"errors"
+	"fmt"
+	"net/url"
+	"slices"
 	"sync"
-	"time"
 
 	"sweng-task/internal/model"
 
@@ -13,46 +16,97 @@ import (
 
 // Errors
 var (
-	ErrLineItemNotFound = errors.New("line item not found")
+	ErrLineItemNotFound        = errors.New("line item not found")
+	ErrInvalidLineItem         = errors.New("invalid line item")
+	ErrLineItemNotEditable     = errors.New("line item cannot be edited in its current status")
+	ErrInvalidStatusTransition = errors.New("invalid line item status transition")
+	ErrVersionMismatch         = errors.New("line item version mismatch")
 )
 
-// LineItemService provides operations for line items
+// lineItemTransitions is the state machine of line item statuses.
+// Completed items can only be archived, archived items are final.
+var lineItemTransitions = map[model.LineItemStatus][]model.LineItemStatus{
+	model.LineItemStatusActive:    {model.LineItemStatusPaused, model.LineItemStatusCompleted, model.LineItemStatusArchived},
+	model.LineItemStatusPaused:    {model.LineItemStatusActive, model.LineItemStatusCompleted, model.LineItemStatusArchived},
+	model.LineItemStatusCompleted: {model.LineItemStatusArchived},
+}
+
+// LineItemRepository persists line items.
+// Implementations must be safe for concurrent use.
+type LineItemRepository interface {
+	Create(item *model.LineItem) error
+	// Update replaces a stored line item, returns ErrLineItemNotFound if there is no such item
+	Update(item *model.LineItem) error
+	GetByID(id string) (*model.LineItem, error)
+	GetAll(advertiserID, placement string) ([]*model.LineItem, error)
+	// FindMatchingLineItems finds active line items matching the targeting query.
+	// Implementations may return line items not matching the keywords, the service matches them precisely.
+	FindMatchingLineItems(query model.TargetingQuery) ([]*model.LineItem, error)
+}
+
+// TransactionalLineItemRepository is implemented by repositories sharing a database with the history,
+// a line item and its change are stored in one transaction, so the history has no gaps
+type TransactionalLineItemRepository interface {
+	CreateWithChange(item *model.LineItem, change model.LineItemChange) error
+	UpdateWithChange(item *model.LineItem, change model.LineItemChange) error
+}
+
+// LineItemService provides operations for line items.
+// Every change is recorded into the line item history.
 type LineItemService struct {
-	items map[string]*model.LineItem
-	mu    sync.RWMutex
-	log   *zap.SugaredLogger
+	repo     LineItemRepository
+	history  LineItemHistory
+	clock    Clock
+	keywords *keywordCache
+	mu       sync.Mutex // serializes writes
+	log      *zap.SugaredLogger
 }
 
 // NewLineItemService creates a new LineItemService
-func NewLineItemService(log *zap.SugaredLogger) *LineItemService {
+func NewLineItemService(repo LineItemRepository, history LineItemHistory, clock Clock, log *zap.SugaredLogger) *LineItemService {
 	return &LineItemService{
-		items: make(map[string]*model.LineItem),
-		log:   log,
+		repo:     repo,
+		history:  history,
+		clock:    clock,
+		keywords: newKeywordCache(),
+		log:      log,
 	}
 }
 
 // Create creates a new line item
-func (s *LineItemService) Create(item model.LineItemCreate) (*model.LineItem, error) {
+func (s *LineItemService) Create(item model.LineItemCreate, meta model.ChangeMeta) (*model.LineItem, error) {
+	lineItem := &model.LineItem{
+		Name:          item.Name,
+		AdvertiserID:  item.AdvertiserID,
+		Bid:           item.Bid,
+		Budget:        item.Budget,
+		Placement:     item.Placement,
+		Categories:    item.Categories,
+		Keywords:      item.Keywords,
+		LandingURL:    item.LandingURL,
+		StartAt:       item.StartAt,
+		EndAt:         item.EndAt,
+		Schedule:      item.Schedule,
+		Pacing:        cmp.Or(item.Pacing, model.PacingModeASAP),
+		FrequencyCaps: item.FrequencyCaps,
+		Status:        model.LineItemStatusActive,
+		Version:       1,
+	}
+	// created items are validated like updated ones, so every stored item can be edited
+	if err := validateLineItem(lineItem); err != nil {
+		return nil, err
+	}
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	now := time.Now()
+	lineItem.ID = "li_" + uuid.New().String()
+	lineItem.CreatedAt = s.clock.Now()
+	lineItem.UpdatedAt = lineItem.CreatedAt
 
-	lineItem := &model.LineItem{
-		ID:           "li_" + uuid.New().String(),
-		Name:         item.Name,
-		AdvertiserID: item.AdvertiserID,
-		Bid:          item.Bid,
-		Budget:       item.Budget,
-		Placement:    item.Placement,
-		Categories:   item.Categories,
-		Keywords:     item.Keywords,
-		Status:       model.LineItemStatusActive,
-		CreatedAt:    now,
-		UpdatedAt:    now,
-	}
-
-	s.items[lineItem.ID] = lineItem
+	if err := s.save(nil, lineItem, model.LineItemChangeActionCreate, meta); err != nil {
+		return nil, err
+	}
 	s.log.Infow("Line item created",
 		"id", lineItem.ID,
 		"name", lineItem.Name,
@@ -65,83 +119,329 @@ func (s *LineItemService) Create(item model.LineItemCreate) (*model.LineItem, er
 
 // GetByID retrieves a line item by ID
 func (s *LineItemService) GetByID(id string) (*model.LineItem, error) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+	return s.repo.GetByID(id)
+}
 
-	item, exists := s.items[id]
-	if !exists {
-		return nil, ErrLineItemNotFound
+// Update applies a partial update to a line item.
+// Only active and paused line items can be edited.
+// If 'version' is not zero, the update is applied only if the current version of the item equals it,
+// otherwise ErrVersionMismatch is returned.
+func (s *LineItemService) Update(id string, update model.LineItemUpdate, version int64, meta model.ChangeMeta) (*model.LineItem, error) {
+	if err := validateLineItemUpdate(update); err != nil {
+		return nil, err
 	}
 
-	return item, nil
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.update(id, version, model.LineItemChangeActionUpdate, meta, func(item *model.LineItem) {
+		if update.Name != nil {
+			item.Name = *update.Name
+		}
+		if update.Bid != nil {
+			item.Bid = *update.Bid
+		}
+		if update.Budget != nil {
+			item.Budget = *update.Budget
+		}
+		if update.Placement != nil {
+			item.Placement = *update.Placement
+		}
+		if update.Categories != nil {
+			item.Categories = *update.Categories
+		}
+		if update.Keywords != nil {
+			item.Keywords = *update.Keywords
+		}
+		if update.LandingURL != nil {
+			item.LandingURL = *update.LandingURL
+		}
+		if update.StartAt.Set {
+			item.StartAt = update.StartAt.Value
+		}
+		if update.EndAt.Set {
+			item.EndAt = update.EndAt.Value
+		}
+		if update.Schedule.Set {
+			item.Schedule = update.Schedule.Value
+		}
+		if update.Pacing != nil {
+			item.Pacing = *update.Pacing
+		}
+		if update.FrequencyCaps != nil {
+			item.FrequencyCaps = *update.FrequencyCaps
+		}
+	})
 }
 
-// GetAll retrieves all line items, optionally filtered by advertiser ID and placement
-func (s *LineItemService) GetAll(advertiserID, placement string) ([]*model.LineItem, error) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+// Restore restores editable fields (everything except the status) of a line item
+// to the values they had at 'revision' version. The restore is a new change itself.
+func (s *LineItemService) Restore(id string, revision int64, version int64, meta model.ChangeMeta) (*model.LineItem, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	var result []*model.LineItem
+	if _, err := s.repo.GetByID(id); err != nil {
+		return nil, err
+	}
 
-	for _, item := range s.items {
-		if advertiserID != "" && item.AdvertiserID != advertiserID {
-			continue
+	old, recorded, err := lineItemRevision(s.history, id, revision)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.update(id, version, model.LineItemChangeActionRestore, meta, func(item *model.LineItem) {
+		item.Name = old.Name
+		item.Bid = old.Bid
+		item.Budget = old.Budget
+		item.Placement = old.Placement
+		item.Categories = old.Categories
+		item.Keywords = old.Keywords
+		item.StartAt = old.StartAt
+		item.EndAt = old.EndAt
+		item.Schedule = old.Schedule
+		// revisions recorded before pacing was introduced have no pacing
+		item.Pacing = cmp.Or(old.Pacing, model.PacingModeASAP)
+		// revisions recorded before landing pages and caps were audited don't have them, the current ones are kept
+		if _, ok := recorded["landing_url"]; ok {
+			item.LandingURL = old.LandingURL
+		}
+		if _, ok := recorded["frequency_caps"]; ok {
+			item.FrequencyCaps = old.FrequencyCaps
 		}
+	})
+}
+
+// update applies changes to a copy of an editable line item, must be called under the write lock
+func (s *LineItemService) update(id string, version int64, action model.LineItemChangeAction, meta model.ChangeMeta, apply func(item *model.LineItem)) (*model.LineItem, error) {
+	item, err := s.getForChange(id, version)
+	if err != nil {
+		return nil, err
+	}
+	if item.Status != model.LineItemStatusActive && item.Status != model.LineItemStatusPaused {
+		return nil, ErrLineItemNotEditable
+	}
+
+	// stored items are never modified in place, readers may still hold them
+	updated := *item
+	apply(&updated)
+	if err := validateLineItem(&updated); err != nil {
+		return nil, err
+	}
+	updated.Version++
+	updated.UpdatedAt = s.clock.Now()
 
-		if placement != "" && item.Placement != placement {
-			continue
+	if err := s.save(item, &updated, action, meta); err != nil {
+		return nil, err
+	}
+	s.keywords.invalidate(updated.ID)
+	s.log.Infow("Line item updated",
+		"id", updated.ID,
+		"action", action,
+	)
+
+	return &updated, nil
+}
+
+// SetStatus moves a line item into a new status according to the status state machine.
+// If 'version' is not zero, the change is applied only to this version of the item.
+func (s *LineItemService) SetStatus(id string, status model.LineItemStatus, version int64, meta model.ChangeMeta) (*model.LineItem, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	item, err := s.getForChange(id, version)
+	if err != nil {
+		return nil, err
+	}
+	if !slices.Contains(lineItemTransitions[item.Status], status) {
+		return nil, fmt.Errorf("%w: %s -> %s", ErrInvalidStatusTransition, item.Status, status)
+	}
+
+	updated := *item
+	updated.Status = status
+	updated.Version++
+	updated.UpdatedAt = s.clock.Now()
+
+	if err := s.save(item, &updated, model.LineItemChangeActionStatus, meta); err != nil {
+		return nil, err
+	}
+	s.keywords.invalidate(updated.ID)
+	s.log.Infow("Line item status changed",
+		"id", updated.ID,
+		"from", item.Status,
+		"to", updated.Status,
+	)
+
+	return &updated, nil
+}
+
+// Archive soft-deletes a line item, archived line items are not returned by GetAll
+func (s *LineItemService) Archive(id string, version int64, meta model.ChangeMeta) (*model.LineItem, error) {
+	return s.SetStatus(id, model.LineItemStatusArchived, version, meta)
+}
+
+// History returns up to 'limit' changes of a line item with versions greater than 'afterVersion'
+func (s *LineItemService) History(id string, afterVersion int64, limit int) ([]model.LineItemChange, error) {
+	if _, err := s.repo.GetByID(id); err != nil {
+		return nil, err
+	}
+
+	return s.history.List(id, afterVersion, limit)
+}
+
+// save stores a created (nil 'old') or updated line item and records the change into the history.
+// Transactional repositories store both at once, otherwise the line item is stored first
+// and a failure to record the change is only logged, since the change cannot be rolled back.
+func (s *LineItemService) save(old, updated *model.LineItem, action model.LineItemChangeAction, meta model.ChangeMeta) error {
+	changes, err := diffLineItems(old, updated)
+	if err != nil {
+		return fmt.Errorf("diff line item: %w", err)
+	}
+	change := model.LineItemChange{
+		LineItemID: updated.ID,
+		Version:    updated.Version,
+		Action:     action,
+		Actor:      meta.Actor,
+		RequestID:  meta.RequestID,
+		Timestamp:  updated.UpdatedAt,
+		Changes:    changes,
+	}
+
+	if repo, ok := s.repo.(TransactionalLineItemRepository); ok {
+		if old == nil {
+			return repo.CreateWithChange(updated, change)
 		}
+		return repo.UpdateWithChange(updated, change)
+	}
 
-		result = append(result, item)
+	if old == nil {
+		err = s.repo.Create(updated)
+	} else {
+		err = s.repo.Update(updated)
 	}
+	if err != nil {
+		return err
+	}
+	if err := s.history.Append(change); err != nil {
+		s.log.Errorw("Cannot record line item change",
+			"id", updated.ID,
+			"version", updated.Version,
+			"error", err,
+		)
+	}
+	return nil
+}
 
-	return result, nil
+// getForChange returns the current line item and checks its version,
+// zero version skips the check. Must be called under the write lock.
+func (s *LineItemService) getForChange(id string, version int64) (*model.LineItem, error) {
+	item, err := s.repo.GetByID(id)
+	if err != nil {
+		return nil, err
+	}
+	if version != 0 && item.Version != version {
+		return nil, fmt.Errorf("%w: %d != %d", ErrVersionMismatch, version, item.Version)
+	}
+	return item, nil
 }
 
-// FindMatchingLineItems finds line items matching the given placement and filters
+// GetAll retrieves all not archived line items, optionally filtered by advertiser ID and placement
+func (s *LineItemService) GetAll(advertiserID, placement string) ([]*model.LineItem, error) {
+	items, err := s.repo.GetAll(advertiserID, placement)
+	if err != nil {
+		return nil, err
+	}
+
+	return slices.DeleteFunc(items, func(item *model.LineItem) bool {
+		return item.Status == model.LineItemStatusArchived
+	}), nil
+}
+
+// FindMatchingLineItems finds line items matching the targeting query
+// which are scheduled to be served right now.
+// Keywords are matched according to their match types, see Keyword.
 // This method will be used by the AdService when implementing the ad selection logic
-func (s *LineItemService) FindMatchingLineItems(placement string, category, keyword string) ([]*model.LineItem, error) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-
-	var result []*model.LineItem
-
-	for _, item := range s.items {
-		// Skip items not matching the placement or not active
-		if item.Placement != placement || item.Status != model.LineItemStatusActive {
-			continue
-		}
-
-		// Apply category filter if specified
-		if category != "" {
-			categoryFound := false
-			for _, cat := range item.Categories {
-				if cat == category {
-					categoryFound = true
-					break
-				}
-			}
-			if !categoryFound {
-				continue
-			}
-		}
-
-		// Apply keyword filter if specified
-		if keyword != "" {
-			keywordFound := false
-			for _, kw := range item.Keywords {
-				if kw == keyword {
-					keywordFound = true
-					break
-				}
-			}
-			if !keywordFound {
-				continue
-			}
-		}
-
-		result = append(result, item)
-	}
-
-	return result, nil
+func (s *LineItemService) FindMatchingLineItems(query model.TargetingQuery) ([]*model.LineItem, error) {
+	items, err := s.repo.FindMatchingLineItems(query)
+	if err != nil {
+		return nil, err
+	}
+
+	now := s.clock.Now()
+	keywords := requestKeywords(query.Keywords)
+	return slices.DeleteFunc(items, func(item *model.LineItem) bool {
+		return !isScheduled(item, now) || !matchKeywords(s.keywords.get(item), keywords, query.Match)
+	}), nil
+}
+
+// validateLineItem validates a line item as a whole, e.g. a restored revision
+func validateLineItem(item *model.LineItem) error {
+	switch {
+	case item.Name == "":
+		return fmt.Errorf("%w: name must not be empty", ErrInvalidLineItem)
+	case item.Placement == "":
+		return fmt.Errorf("%w: placement must not be empty", ErrInvalidLineItem)
+	case item.Bid <= 0:
+		return fmt.Errorf("%w: bid must be a positive number", ErrInvalidLineItem)
+	case item.Budget <= 0:
+		return fmt.Errorf("%w: budget must be a positive number", ErrInvalidLineItem)
+	}
+	if err := validateFlight(item.StartAt, item.EndAt, item.Schedule); err != nil {
+		return err
+	}
+	if err := validateKeywords(item.Keywords); err != nil {
+		return err
+	}
+	if err := validateLandingURL(item.LandingURL); err != nil {
+		return err
+	}
+	if err := validatePacing(item.Pacing); err != nil {
+		return err
+	}
+	return validateFrequencyCaps(item.FrequencyCaps)
+}
+
+func validateLineItemUpdate(update model.LineItemUpdate) error {
+	switch {
+	case update == model.LineItemUpdate{}:
+		return fmt.Errorf("%w: nothing to update", ErrInvalidLineItem)
+	case update.Name != nil && *update.Name == "":
+		return fmt.Errorf("%w: name must not be empty", ErrInvalidLineItem)
+	case update.Placement != nil && *update.Placement == "":
+		return fmt.Errorf("%w: placement must not be empty", ErrInvalidLineItem)
+	case update.Bid != nil && *update.Bid <= 0:
+		return fmt.Errorf("%w: bid must be a positive number", ErrInvalidLineItem)
+	case update.Budget != nil && *update.Budget <= 0:
+		return fmt.Errorf("%w: budget must be a positive number", ErrInvalidLineItem)
+	}
+	if update.Keywords != nil {
+		if err := validateKeywords(*update.Keywords); err != nil {
+			return err
+		}
+	}
+	if update.LandingURL != nil {
+		if err := validateLandingURL(*update.LandingURL); err != nil {
+			return err
+		}
+	}
+	if update.Pacing != nil {
+		if err := validatePacing(*update.Pacing); err != nil {
+			return err
+		}
+	}
+	if update.FrequencyCaps != nil {
+		return validateFrequencyCaps(*update.FrequencyCaps)
+	}
+	return nil
+}
+
+// validateLandingURL validates the landing page of a line item, it must be an absolute http(s) URL if set
+func validateLandingURL(landingURL string) error {
+	if landingURL == "" {
+		return nil
+	}
+	u, err := url.Parse(landingURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%w: landing URL %q must be an absolute http or https URL", ErrInvalidLineItem, landingURL)
+	}
+	return nil
 }
@@ -0,0 +1,256 @@
+package service
+
+import (
+	"cmp"
+	"slices"
+	"sync"
+
+	"sweng-task/internal/model"
+)
+
+// MemoryLineItemRepository keeps line items in memory.
+// Stored items must not be modified after they were passed to the repository.
+type MemoryLineItemRepository struct {
+	items map[string]*model.LineItem
+	index lineItemIndex
+	mu    sync.RWMutex
+}
+
+// NewMemoryLineItemRepository creates a new MemoryLineItemRepository
+func NewMemoryLineItemRepository() *MemoryLineItemRepository {
+	return &MemoryLineItemRepository{
+		items: make(map[string]*model.LineItem),
+		index: make(lineItemIndex),
+	}
+}
+
+// Create stores a new line item
+func (r *MemoryLineItemRepository) Create(item *model.LineItem) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.items[item.ID] = item
+	r.index.add(item)
+	return nil
+}
+
+// Update replaces a stored line item and reindexes it
+func (r *MemoryLineItemRepository) Update(item *model.LineItem) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	old, exists := r.items[item.ID]
+	if !exists {
+		return ErrLineItemNotFound
+	}
+
+	r.index.remove(old)
+	r.items[item.ID] = item
+	r.index.add(item)
+	return nil
+}
+
+// GetByID retrieves a line item by ID
+func (r *MemoryLineItemRepository) GetByID(id string) (*model.LineItem, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	item, exists := r.items[id]
+	if !exists {
+		return nil, ErrLineItemNotFound
+	}
+
+	return item, nil
+}
+
+// GetAll retrieves all line items, optionally filtered by advertiser ID and placement
+func (r *MemoryLineItemRepository) GetAll(advertiserID, placement string) ([]*model.LineItem, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var result []*model.LineItem
+
+	for _, item := range r.items {
+		if advertiserID != "" && item.AdvertiserID != advertiserID {
+			continue
+		}
+
+		if placement != "" && item.Placement != placement {
+			continue
+		}
+
+		result = append(result, item)
+	}
+
+	return result, nil
+}
+
+// FindMatchingLineItems finds active line items matching the targeting query
+func (r *MemoryLineItemRepository) FindMatchingLineItems(query model.TargetingQuery) ([]*model.LineItem, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.index.find(query), nil
+}
+
+// postings is a posting list of the index
+type postings map[string]*model.LineItem
+
+// lineItemIndex is an inverted index of active line items by placement
+type lineItemIndex map[string]*placementIndex
+
+// placementIndex keeps posting lists of active line items of a placement by category and by keyword term.
+// Keyword terms only preselect candidates, match types and negative keywords are checked by the service.
+type placementIndex struct {
+	all        postings
+	categories map[string]postings
+	keywords   map[string]postings
+}
+
+// add posts an item if it is active
+func (idx lineItemIndex) add(item *model.LineItem) {
+	if item.Status != model.LineItemStatusActive {
+		return
+	}
+
+	p, ok := idx[item.Placement]
+	if !ok {
+		p = &placementIndex{
+			all:        make(postings),
+			categories: make(map[string]postings),
+			keywords:   make(map[string]postings),
+		}
+		idx[item.Placement] = p
+	}
+
+	p.all[item.ID] = item
+	post(p.categories, item.Categories, item)
+	post(p.keywords, KeywordIndexTerms(item), item)
+}
+
+// remove deletes all postings of an item, empty posting lists are pruned
+func (idx lineItemIndex) remove(item *model.LineItem) {
+	p, ok := idx[item.Placement]
+	if !ok {
+		return
+	}
+
+	delete(p.all, item.ID)
+	unpost(p.categories, item.Categories, item)
+	unpost(p.keywords, KeywordIndexTerms(item), item)
+	if len(p.all) == 0 {
+		delete(idx, item.Placement)
+	}
+}
+
+func post(lists map[string]postings, values []string, item *model.LineItem) {
+	for _, v := range values {
+		list, ok := lists[v]
+		if !ok {
+			list = make(postings)
+			lists[v] = list
+		}
+		list[item.ID] = item
+	}
+}
+
+func unpost(lists map[string]postings, values []string, item *model.LineItem) {
+	for _, v := range values {
+		list, ok := lists[v]
+		if !ok {
+			continue
+		}
+		delete(list, item.ID)
+		if len(list) == 0 {
+			delete(lists, v)
+		}
+	}
+}
+
+// find returns items matching the query.
+// The query is a conjunction of groups, every group is a union of posting lists:
+// an "all" query has a group per value, an "any" query has a group per categories and keywords.
+// A requested keyword is looked up by all its terms.
+// Items of the smallest group are checked against the rest of groups.
+func (idx lineItemIndex) find(query model.TargetingQuery) []*model.LineItem {
+	p, ok := idx[query.Placement]
+	if !ok {
+		return nil
+	}
+
+	categories := make([][]string, len(query.Categories))
+	for i, category := range query.Categories {
+		categories[i] = []string{category}
+	}
+	keywords := make([][]string, len(query.Keywords))
+	for i, keyword := range query.Keywords {
+		keywords[i] = KeywordTerms(keyword)
+	}
+
+	var groups [][]postings
+	for _, dimension := range []struct {
+		lists map[string]postings
+		keys  [][]string // posting list keys by requested value
+	}{
+		{p.categories, categories},
+		{p.keywords, keywords},
+	} {
+		if len(dimension.keys) == 0 {
+			continue
+		}
+
+		var group []postings
+		for _, keys := range dimension.keys {
+			for _, key := range keys {
+				group = append(group, dimension.lists[key])
+			}
+			if query.Match == model.MatchAll {
+				groups = append(groups, group)
+				group = nil
+			}
+		}
+		if query.Match != model.MatchAll {
+			groups = append(groups, group)
+		}
+	}
+	if len(groups) == 0 {
+		groups = [][]postings{{p.all}}
+	}
+
+	slices.SortFunc(groups, func(a, b []postings) int { return cmp.Compare(groupSize(a), groupSize(b)) })
+
+	var result []*model.LineItem
+	seen := make(map[string]struct{})
+	for _, list := range groups[0] {
+		for id, item := range list {
+			if _, ok := seen[id]; ok {
+				continue
+			}
+			seen[id] = struct{}{}
+
+			if !slices.ContainsFunc(groups[1:], func(group []postings) bool { return !inGroup(group, id) }) {
+				result = append(result, item)
+			}
+		}
+	}
+	return result
+}
+
+// groupSize returns the upper bound of the number of items in a group
+func groupSize(group []postings) int {
+	var size int
+	for _, list := range group {
+		size += len(list)
+	}
+	return size
+}
+
+// inGroup reports if an item is posted in any list of a group
+func inGroup(group []postings, id string) bool {
+	for _, list := range group {
+		if _, ok := list[id]; ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,161 @@
+package service
+
+import (
+	"fmt"
+	"math/rand"
+	"slices"
+	"sort"
+	"testing"
+	"time"
+
+	"sweng-task/internal/model"
+)
+
+// scanMatchingLineItems is the full scan implementation the inverted index replaced,
+// it is kept as a reference for tests and benchmarks
+func scanMatchingLineItems(items []*model.LineItem, query model.TargetingQuery) []*model.LineItem {
+	keywords := requestKeywords(query.Keywords)
+	matches := func(targeted, requested []string) bool {
+		if len(requested) == 0 {
+			return true
+		}
+		contains := func(v string) bool { return slices.Contains(targeted, v) }
+		if query.Match == model.MatchAll {
+			return !slices.ContainsFunc(requested, func(v string) bool { return !contains(v) })
+		}
+		return slices.ContainsFunc(requested, contains)
+	}
+
+	var result []*model.LineItem
+	for _, item := range items {
+		if item.Placement != query.Placement || item.Status != model.LineItemStatusActive {
+			continue
+		}
+		if !matches(item.Categories, query.Categories) || !matchKeywords(parseLineItemKeywords(item.Keywords), keywords, query.Match) {
+			continue
+		}
+		result = append(result, item)
+	}
+	return result
+}
+
+// generateLineItems generates random line items over a small vocabulary,
+// so that queries have a reasonable amount of matches
+func generateLineItems(rnd *rand.Rand, n int) []*model.LineItem {
+	statuses := []model.LineItemStatus{model.LineItemStatusActive, model.LineItemStatusActive, model.LineItemStatusActive, model.LineItemStatusPaused}
+
+	items := make([]*model.LineItem, n)
+	for i := range items {
+		item := &model.LineItem{
+			ID:        fmt.Sprintf("li_%d", i),
+			Bid:       rnd.Float64() * 10,
+			Placement: fmt.Sprintf("placement_%d", rnd.Intn(5)),
+			Status:    statuses[rnd.Intn(len(statuses))],
+		}
+		for range rnd.Intn(4) {
+			item.Categories = append(item.Categories, fmt.Sprintf("category_%d", rnd.Intn(20)))
+		}
+		for range rnd.Intn(6) {
+			item.Keywords = append(item.Keywords, fmt.Sprintf("keyword%d", rnd.Intn(100)))
+		}
+		items[i] = item
+	}
+	return items
+}
+
+func lineItemIDs(items []*model.LineItem) []string {
+	ids := make([]string, len(items))
+	for i, item := range items {
+		ids[i] = item.ID
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func TestMemoryLineItemRepository_FindMatchingLineItems(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	items := generateLineItems(rnd, 2000)
+
+	repo := NewMemoryLineItemRepository()
+	for _, item := range items {
+		if err := repo.Create(item); err != nil {
+			t.Fatalf("Create line item: %v", err)
+		}
+	}
+
+	categories := [][]string{nil, {"category_0"}, {"category_19", "unknown"}, {"category_1", "category_2", "category_3"}, {"unknown"}}
+	keywords := [][]string{nil, {"keyword0"}, {"keyword42", "keyword7"}, {"keyword1", "keyword2", "keyword1"}, {"unknown"}}
+	for _, placement := range []string{"placement_0", "placement_4", "unknown"} {
+		for _, match := range []model.MatchMode{model.MatchAny, model.MatchAll} {
+			for _, c := range categories {
+				for _, k := range keywords {
+					query := model.TargetingQuery{Placement: placement, Categories: c, Keywords: k, Match: match}
+					got, err := repo.FindMatchingLineItems(query)
+					if err != nil {
+						t.Fatalf("Find matching line items: %v", err)
+					}
+					// the index preselects candidates by keyword terms
+					keywords := requestKeywords(query.Keywords)
+					got = slices.DeleteFunc(got, func(item *model.LineItem) bool {
+						return !matchKeywords(parseLineItemKeywords(item.Keywords), keywords, query.Match)
+					})
+
+					want := scanMatchingLineItems(items, query)
+					if !slices.Equal(lineItemIDs(got), lineItemIDs(want)) {
+						t.Errorf("Wrong matching line items for %+v: %d != %d", query, len(got), len(want))
+					}
+				}
+			}
+		}
+	}
+}
+
+// BenchmarkFindMatchingLineItems compares the inverted index against the full scan.
+// Besides ns/op it reports p99 latency of a single selection.
+func BenchmarkFindMatchingLineItems(b *testing.B) {
+	for _, n := range []int{1_000, 10_000, 50_000} {
+		rnd := rand.New(rand.NewSource(1))
+		items := generateLineItems(rnd, n)
+
+		repo := NewMemoryLineItemRepository()
+		for _, item := range items {
+			_ = repo.Create(item)
+		}
+
+		// a page has a couple of categories and several keywords
+		queries := make([]model.TargetingQuery, 1024)
+		for i := range queries {
+			queries[i] = model.TargetingQuery{
+				Placement:  fmt.Sprintf("placement_%d", rnd.Intn(5)),
+				Categories: []string{fmt.Sprintf("category_%d", rnd.Intn(20)), fmt.Sprintf("category_%d", rnd.Intn(20))},
+				Keywords:   []string{fmt.Sprintf("keyword%d", rnd.Intn(100)), fmt.Sprintf("keyword%d", rnd.Intn(100)), fmt.Sprintf("keyword%d", rnd.Intn(100))},
+			}
+		}
+
+		b.Run(fmt.Sprintf("index/%d", n), func(b *testing.B) {
+			benchmarkSelection(b, queries, func(q model.TargetingQuery) {
+				_, _ = repo.FindMatchingLineItems(q)
+			})
+		})
+		b.Run(fmt.Sprintf("scan/%d", n), func(b *testing.B) {
+			benchmarkSelection(b, queries, func(q model.TargetingQuery) {
+				_ = scanMatchingLineItems(items, q)
+			})
+		})
+	}
+}
+
+func benchmarkSelection(b *testing.B, queries []model.TargetingQuery, find func(q model.TargetingQuery)) {
+	latencies := make([]time.Duration, 0, b.N)
+
+	b.ResetTimer()
+	for i := range b.N {
+		start := time.Now()
+		find(queries[i%len(queries)])
+		latencies = append(latencies, time.Since(start))
+	}
+	b.StopTimer()
+
+	slices.Sort(latencies)
+	b.ReportMetric(float64(latencies[len(latencies)*99/100].Nanoseconds()), "p99-ns")
+}
@@ -0,0 +1,186 @@
+package service
+
+import (
+	"errors"
+	"testing"
+
+	"sweng-task/internal/model"
+
+	"go.uber.org/zap"
+)
+
+func newTestLineItem(t *testing.T, s *LineItemService) *model.LineItem {
+	t.Helper()
+
+	item, err := s.Create(model.LineItemCreate{
+		Name:         "test_1",
+		AdvertiserID: "ad_1",
+		Bid:          2,
+		Budget:       1000,
+		Placement:    "header",
+		Categories:   []string{"toys"},
+		Keywords:     []string{"summer"},
+	}, model.ChangeMeta{})
+	if err != nil {
+		t.Fatalf("Create line item: %v", err)
+	}
+	return item
+}
+
+func TestLineItemService_CreateValidation(t *testing.T) {
+	s := NewLineItemService(NewMemoryLineItemRepository(), NewMemoryLineItemHistory(), SystemClock{}, zap.NewNop().Sugar())
+
+	tests := []struct {
+		name  string
+		input model.LineItemCreate
+	}{
+		{"no name", model.LineItemCreate{Bid: 1, Budget: 100, Placement: "header"}},
+		{"no placement", model.LineItemCreate{Name: "test", Bid: 1, Budget: 100}},
+		{"no bid", model.LineItemCreate{Name: "test", Budget: 100, Placement: "header"}},
+		{"negative budget", model.LineItemCreate{Name: "test", Bid: 1, Budget: -1, Placement: "header"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := s.Create(tt.input, model.ChangeMeta{})
+			if !errors.Is(err, ErrInvalidLineItem) {
+				t.Errorf("Wrong error: %v", err)
+			}
+		})
+	}
+}
+
+func TestLineItemService_Update(t *testing.T) {
+	s := NewLineItemService(NewMemoryLineItemRepository(), NewMemoryLineItemHistory(), SystemClock{}, zap.NewNop().Sugar())
+	item := newTestLineItem(t, s)
+
+	bid := 3.5
+	keywords := []string{"winter"}
+	updated, err := s.Update(item.ID, model.LineItemUpdate{Bid: &bid, Keywords: &keywords}, item.Version, model.ChangeMeta{})
+	if err != nil {
+		t.Fatalf("Update line item: %v", err)
+	}
+	if updated.Bid != bid || updated.Name != item.Name || updated.Keywords[0] != "winter" {
+		t.Errorf("Wrong updated line item: %+v", updated)
+	}
+	if !updated.UpdatedAt.After(item.UpdatedAt) {
+		t.Errorf("UpdatedAt is not maintained: %v <= %v", updated.UpdatedAt, item.UpdatedAt)
+	}
+	if item.Bid != 2 {
+		t.Errorf("Stored line item is modified in place: %+v", item)
+	}
+
+	// targeting index follows the update
+	matching, _ := s.FindMatchingLineItems(model.TargetingQuery{Placement: "header", Categories: []string{"toys"}, Keywords: []string{"summer"}})
+	if len(matching) != 0 {
+		t.Errorf("Line item matched by an old keyword")
+	}
+	matching, _ = s.FindMatchingLineItems(model.TargetingQuery{Placement: "header", Categories: []string{"toys"}, Keywords: []string{"winter"}})
+	if len(matching) != 1 {
+		t.Errorf("Line item is not matched by a new keyword")
+	}
+
+	negative := -1.0
+	_, err = s.Update(item.ID, model.LineItemUpdate{Budget: &negative}, updated.Version, model.ChangeMeta{})
+	if !errors.Is(err, ErrInvalidLineItem) {
+		t.Errorf("Wrong error for a negative budget: %v", err)
+	}
+
+	_, err = s.Update("li_unknown", model.LineItemUpdate{Bid: &bid}, 1, model.ChangeMeta{})
+	if !errors.Is(err, ErrLineItemNotFound) {
+		t.Errorf("Wrong error for unknown line item: %v", err)
+	}
+}
+
+func TestLineItemService_SetStatus(t *testing.T) {
+	tests := []struct {
+		name    string
+		path    []model.LineItemStatus
+		wantErr error
+	}{
+		{"pause", []model.LineItemStatus{model.LineItemStatusPaused}, nil},
+		{"pause and resume", []model.LineItemStatus{model.LineItemStatusPaused, model.LineItemStatusActive}, nil},
+		{"complete paused", []model.LineItemStatus{model.LineItemStatusPaused, model.LineItemStatusCompleted}, nil},
+		{"archive completed", []model.LineItemStatus{model.LineItemStatusCompleted, model.LineItemStatusArchived}, nil},
+		{"resume active", []model.LineItemStatus{model.LineItemStatusActive}, ErrInvalidStatusTransition},
+		{"resume completed", []model.LineItemStatus{model.LineItemStatusCompleted, model.LineItemStatusActive}, ErrInvalidStatusTransition},
+		{"pause completed", []model.LineItemStatus{model.LineItemStatusCompleted, model.LineItemStatusPaused}, ErrInvalidStatusTransition},
+		{"resume archived", []model.LineItemStatus{model.LineItemStatusArchived, model.LineItemStatusActive}, ErrInvalidStatusTransition},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewLineItemService(NewMemoryLineItemRepository(), NewMemoryLineItemHistory(), SystemClock{}, zap.NewNop().Sugar())
+			item := newTestLineItem(t, s)
+
+			var err error
+			for _, status := range tt.path {
+				item, err = s.SetStatus(item.ID, status, item.Version, model.ChangeMeta{})
+				if err != nil {
+					break
+				}
+				if item.Status != status {
+					t.Fatalf("Wrong status: %s != %s", item.Status, status)
+				}
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Wrong error: %v != %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLineItemService_PausedAndArchivedItems(t *testing.T) {
+	s := NewLineItemService(NewMemoryLineItemRepository(), NewMemoryLineItemHistory(), SystemClock{}, zap.NewNop().Sugar())
+	item := newTestLineItem(t, s)
+
+	if _, err := s.SetStatus(item.ID, model.LineItemStatusPaused, 0, model.ChangeMeta{}); err != nil {
+		t.Fatalf("Pause line item: %v", err)
+	}
+	matching, _ := s.FindMatchingLineItems(model.TargetingQuery{Placement: "header"})
+	if len(matching) != 0 {
+		t.Errorf("Paused line item is matched")
+	}
+
+	if _, err := s.Archive(item.ID, 0, model.ChangeMeta{}); err != nil {
+		t.Fatalf("Archive line item: %v", err)
+	}
+	all, _ := s.GetAll("", "")
+	if len(all) != 0 {
+		t.Errorf("Archived line item is listed")
+	}
+
+	bid := 1.0
+	_, err := s.Update(item.ID, model.LineItemUpdate{Bid: &bid}, 0, model.ChangeMeta{})
+	if !errors.Is(err, ErrLineItemNotEditable) {
+		t.Errorf("Wrong error for editing archived line item: %v", err)
+	}
+}
+
+func TestLineItemService_Update_StaleVersion(t *testing.T) {
+	s := NewLineItemService(NewMemoryLineItemRepository(), NewMemoryLineItemHistory(), SystemClock{}, zap.NewNop().Sugar())
+	item := newTestLineItem(t, s)
+
+	first, second := "first", "second"
+	updated, err := s.Update(item.ID, model.LineItemUpdate{Name: &first}, item.Version, model.ChangeMeta{})
+	if err != nil {
+		t.Fatalf("Update line item: %v", err)
+	}
+	if updated.Version != item.Version+1 {
+		t.Errorf("Version is not incremented: %d != %d", updated.Version, item.Version+1)
+	}
+
+	// the second editor still has the original version
+	_, err = s.Update(item.ID, model.LineItemUpdate{Name: &second}, item.Version, model.ChangeMeta{})
+	if !errors.Is(err, ErrVersionMismatch) {
+		t.Errorf("Wrong error for a stale version: %v", err)
+	}
+	_, err = s.SetStatus(item.ID, model.LineItemStatusPaused, item.Version, model.ChangeMeta{})
+	if !errors.Is(err, ErrVersionMismatch) {
+		t.Errorf("Wrong error for a stale version: %v", err)
+	}
+
+	current, _ := s.GetByID(item.ID)
+	if current.Name != first {
+		t.Errorf("Stale write is applied: %q != %q", current.Name, first)
+	}
+}
@@ -0,0 +1,67 @@
+package service
+
+import (
+	"fmt"
+	"time"
+
+	"sweng-task/internal/model"
+)
+
+// PacingService throttles line items, so they spend their daily budgets according to their pacing mode.
+// Even pacing lets a line item take part in auctions only while its spend
+// doesn't exceed the budget share of the elapsed part of the budget day.
+type PacingService struct {
+	budget *BudgetService
+}
+
+// NewPacingService creates a new PacingService, budget days are the days of the BudgetService
+func NewPacingService(budget *BudgetService) *PacingService {
+	return &PacingService{
+		budget: budget,
+	}
+}
+
+// State returns the current pacing state of a line item
+func (s *PacingService) State(item *model.LineItem) model.PacingState {
+	now := s.budget.clock.Now()
+	spend := s.budget.Spend(item.ID)
+
+	state := model.PacingState{
+		LineItemID:  item.ID,
+		Mode:        item.Pacing,
+		Budget:      item.Budget,
+		Spend:       spend,
+		TargetSpend: item.Budget,
+		DayElapsed:  dayElapsed(now, s.budget.location),
+	}
+	if state.Mode == "" {
+		state.Mode = model.PacingModeASAP
+	}
+	if state.Mode == model.PacingModeEven {
+		state.TargetSpend = item.Budget * state.DayElapsed
+	}
+	state.Throttled = spend >= item.Budget || spend > state.TargetSpend
+	return state
+}
+
+// Allow excludes line items which are ahead of their pacing, implements LineItemFilter
+func (s *PacingService) Allow(item *model.LineItem) bool {
+	return !s.State(item).Throttled
+}
+
+// dayElapsed returns the elapsed fraction of the day of 't' in the given location,
+// days are not always 24 hours long because of daylight saving time
+func dayElapsed(t time.Time, loc *time.Location) float64 {
+	start := startOfDay(t, loc)
+	end := start.AddDate(0, 0, 1)
+	return float64(t.Sub(start)) / float64(end.Sub(start))
+}
+
+func validatePacing(mode model.PacingMode) error {
+	switch mode {
+	case model.PacingModeASAP, model.PacingModeEven:
+		return nil
+	default:
+		return fmt.Errorf("%w: unknown pacing %q, expected one of: asap, even", ErrInvalidLineItem, mode)
+	}
+}
@@ -0,0 +1,96 @@
+package service
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"sweng-task/internal/model"
+
+	"go.uber.org/zap"
+)
+
+func TestPacingService_State(t *testing.T) {
+	clock := &fakeClock{}
+	log := zap.NewNop().Sugar()
+	budget := NewBudgetService(nil, NewMemorySpendStore(), clock, time.UTC, log)
+	pacing := NewPacingService(budget)
+
+	day := time.Date(2025, 6, 2, 0, 0, 0, 0, time.UTC)
+	even := &model.LineItem{ID: "li_even", Budget: 100, Pacing: model.PacingModeEven}
+	asap := &model.LineItem{ID: "li_asap", Budget: 100, Pacing: model.PacingModeASAP}
+
+	tests := []struct {
+		name          string
+		item          *model.LineItem
+		elapsed       time.Duration
+		spend         float64
+		wantTarget    float64
+		wantThrottled bool
+	}{
+		{"even at midnight", even, 0, 0, 0, false},
+		{"even behind", even, 12 * time.Hour, 40, 50, false},
+		{"even on target", even, 12 * time.Hour, 50, 50, false},
+		{"even ahead", even, 6 * time.Hour, 30, 25, true},
+		{"even spent", even, 23 * time.Hour, 100, 100 * 23 / 24.0, true},
+		{"asap ahead", asap, 6 * time.Hour, 90, 100, false},
+		{"asap spent", asap, 6 * time.Hour, 100, 100, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock.now = day.Add(tt.elapsed)
+			budget.Charge(tt.item.ID, tt.spend-budget.Spend(tt.item.ID))
+
+			state := pacing.State(tt.item)
+			if math.Abs(state.TargetSpend-tt.wantTarget) > 1e-9 {
+				t.Errorf("Wrong target spend: %v != %v", state.TargetSpend, tt.wantTarget)
+			}
+			if state.Throttled != tt.wantThrottled {
+				t.Errorf("Wrong throttling: %+v", state)
+			}
+			if pacing.Allow(tt.item) == tt.wantThrottled {
+				t.Errorf("Allow doesn't follow the state: %+v", state)
+			}
+		})
+	}
+}
+
+func TestDayElapsed(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatalf("Load location: %v", err)
+	}
+
+	// 2025-03-30 has 23 hours in Berlin, 12:00 local is 11 hours after midnight
+	got := dayElapsed(time.Date(2025, 3, 30, 12, 0, 0, 0, berlin), berlin)
+	if want := 11 / 23.0; math.Abs(got-want) > 1e-9 {
+		t.Errorf("Wrong elapsed fraction of a short day: %v != %v", got, want)
+	}
+}
+
+func TestLineItemService_Pacing(t *testing.T) {
+	s := NewLineItemService(NewMemoryLineItemRepository(), NewMemoryLineItemHistory(), SystemClock{}, zap.NewNop().Sugar())
+
+	item, err := s.Create(model.LineItemCreate{Name: "test_1", Bid: 2, Budget: 100, Placement: "header"}, model.ChangeMeta{})
+	if err != nil {
+		t.Fatalf("Create line item: %v", err)
+	}
+	if item.Pacing != model.PacingModeASAP {
+		t.Errorf("Wrong default pacing: %q", item.Pacing)
+	}
+
+	_, err = s.Create(model.LineItemCreate{Name: "test_2", Bid: 1, Budget: 100, Placement: "header", Pacing: "fast"}, model.ChangeMeta{})
+	if !errors.Is(err, ErrInvalidLineItem) {
+		t.Errorf("Wrong error for unknown pacing: %v", err)
+	}
+
+	even := model.PacingModeEven
+	item, err = s.Update(item.ID, model.LineItemUpdate{Pacing: &even}, item.Version, model.ChangeMeta{})
+	if err != nil {
+		t.Fatalf("Update pacing: %v", err)
+	}
+	if item.Pacing != model.PacingModeEven {
+		t.Errorf("Pacing is not updated: %q", item.Pacing)
+	}
+}
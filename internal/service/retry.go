@@ -0,0 +1,66 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"time"
+
+	"sweng-task/internal/model"
+)
+
+// RetryPolicy defines how writes of tracking events are retried, backoffs grow exponentially
+type RetryPolicy struct {
+	MaxAttempts    int           // number of attempts including the first one
+	InitialBackoff time.Duration // backoff after the first failed attempt
+	MaxBackoff     time.Duration // limit of the exponential backoff
+	Jitter         float64       // share of a backoff which is randomized, so writers don't retry in lockstep
+}
+
+// noRetries writes events once
+var noRetries = RetryPolicy{MaxAttempts: 1}
+
+// Validate validates a retry policy
+func (p RetryPolicy) Validate() error {
+	switch {
+	case p.MaxAttempts < 1:
+		return fmt.Errorf("max attempts must be at least 1")
+	case p.MaxAttempts > 1 && (p.InitialBackoff <= 0 || p.MaxBackoff < p.InitialBackoff):
+		return fmt.Errorf("backoffs must be positive and the max backoff must not be less than the initial one")
+	case p.Jitter < 0 || p.Jitter > 1:
+		return fmt.Errorf("jitter must be in the range [0-1]")
+	}
+	return nil
+}
+
+// Backoff returns the time to wait after the failed attempt, attempts are counted from 1
+func (p RetryPolicy) Backoff(attempt int) time.Duration {
+	backoff := p.InitialBackoff
+	for i := 1; i < attempt && backoff < p.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	backoff = min(backoff, p.MaxBackoff)
+	return backoff - time.Duration(p.Jitter*rand.Float64()*float64(backoff))
+}
+
+// PartialWriteError is returned by storages which wrote only a part of a batch,
+// only the failed events are retried and dead-lettered
+type PartialWriteError struct {
+	Failed  []model.TrackingEvent
+	Indexes []int // positions of the failed events in the written batch
+	Err     error
+}
+
+func (e *PartialWriteError) Error() string {
+	return fmt.Sprintf("%d events are not written: %v", len(e.Failed), e.Err)
+}
+
+func (e *PartialWriteError) Unwrap() error {
+	return e.Err
+}
+
+// DeadLetterStore keeps batches of tracking events which cannot be written to the storage.
+// The sink is the fan-out sink the events failed in, it is empty if they failed in the storage as a whole.
+type DeadLetterStore interface {
+	Put(ctx context.Context, sink string, events []model.TrackingEvent) error
+}
@@ -0,0 +1,168 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"sweng-task/internal/model"
+
+	"go.uber.org/zap"
+)
+
+func TestRetryPolicy_Backoff(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 10, InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second}
+	want := []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond, 800 * time.Millisecond, time.Second, time.Second}
+	for i, backoff := range want {
+		if got := policy.Backoff(i + 1); got != backoff {
+			t.Errorf("Wrong backoff of attempt %d: %v != %v", i+1, got, backoff)
+		}
+	}
+
+	policy.Jitter = 0.5
+	for range 100 {
+		if got := policy.Backoff(2); got <= 100*time.Millisecond || got > 200*time.Millisecond {
+			t.Fatalf("Jittered backoff is out of range: %v", got)
+		}
+	}
+}
+
+type deadLetterRecorder struct {
+	batches [][]model.TrackingEvent
+	sinks   []string
+	err     error
+}
+
+func (r *deadLetterRecorder) Put(_ context.Context, sink string, events []model.TrackingEvent) error {
+	if r.err != nil {
+		return r.err
+	}
+	r.batches = append(r.batches, events)
+	r.sinks = append(r.sinks, sink)
+	return nil
+}
+
+func TestTrackingService_FlushRetries(t *testing.T) {
+	errUnavailable := errors.New("storage is unavailable")
+	var writes int
+	storage := TrackingEventsStorageFunc(func(context.Context, []model.TrackingEvent) error {
+		writes++
+		if writes%3 != 0 {
+			return errUnavailable
+		}
+		return nil
+	})
+	deadLetters := &deadLetterRecorder{}
+	tracking := NewTrackingService(1, storage, time.Second, zap.NewNop().Sugar(),
+		WithRetryPolicy(RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond}),
+		WithDeadLetterStore(deadLetters))
+
+	// the third attempt succeeds
+	if err := tracking.flushTrackingEventsBuffer(t.Context(), make([]model.TrackingEvent, 2)); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	if writes != 3 || len(deadLetters.batches) != 0 {
+		t.Errorf("Batch must be written after retries: %d writes, %d dead letters", writes, len(deadLetters.batches))
+	}
+
+	// all attempts fail, the batch is dead-lettered
+	writes = 0
+	tracking.retry.MaxAttempts = 2
+	if err := tracking.flushTrackingEventsBuffer(t.Context(), make([]model.TrackingEvent, 2)); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	if writes != 2 || len(deadLetters.batches) != 1 || len(deadLetters.batches[0]) != 2 {
+		t.Errorf("Batch must be dead-lettered: %d writes, %d dead letters", writes, len(deadLetters.batches))
+	}
+
+	// retries on shutdown don't wait for backoffs
+	writes = 0
+	tracking.retry = RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Hour, MaxBackoff: time.Hour}
+	ctx, stop := context.WithCancel(t.Context())
+	stop()
+	if err := tracking.flushTrackingEventsBuffer(ctx, make([]model.TrackingEvent, 2)); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	if writes != 3 || len(deadLetters.batches) != 1 {
+		t.Errorf("Batch must be written after retries: %d writes, %d dead letters", writes, len(deadLetters.batches))
+	}
+
+	// the worker stops only if the batch cannot be dead-lettered either
+	writes = 0
+	tracking.retry = RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond}
+	deadLetters.err = errors.New("disk is full")
+	if err := tracking.flushTrackingEventsBuffer(t.Context(), make([]model.TrackingEvent, 2)); !errors.Is(err, errUnavailable) {
+		t.Errorf("Flush must fail: %v", err)
+	}
+}
+
+func TestTrackingService_FlushPartialWrite(t *testing.T) {
+	var written []model.TrackingEvent
+	storage := TrackingEventsStorageFunc(func(_ context.Context, events []model.TrackingEvent) error {
+		// the storage writes one event per attempt
+		written = append(written, events[0])
+		if len(events) > 1 {
+			return &PartialWriteError{Failed: events[1:], Indexes: []int{1}, Err: errors.New("partition is unavailable")}
+		}
+		return nil
+	})
+	deadLetters := &deadLetterRecorder{}
+	tracking := NewTrackingService(1, storage, time.Second, zap.NewNop().Sugar(),
+		WithRetryPolicy(RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond}),
+		WithDeadLetterStore(deadLetters))
+
+	events := []model.TrackingEvent{{ID: "evt_1"}, {ID: "evt_2"}, {ID: "evt_3"}}
+	if err := tracking.flushTrackingEventsBuffer(t.Context(), events); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	if len(written) != 2 || written[0].ID != "evt_1" || written[1].ID != "evt_2" {
+		t.Errorf("Only failed events must be retried: %+v", written)
+	}
+	if len(deadLetters.batches) != 1 || len(deadLetters.batches[0]) != 1 || deadLetters.batches[0][0].ID != "evt_3" {
+		t.Errorf("Only failed events must be dead-lettered: %+v", deadLetters.batches)
+	}
+}
+
+func TestTrackingService_FlushFanOutFailure(t *testing.T) {
+	durable := &sinkRecorder{}
+	attribution := &sinkRecorder{err: errors.New("attribution is unavailable")}
+	realtime := &sinkRecorder{}
+	fanOut, err := NewFanOutStorage(zap.NewNop().Sugar(),
+		FanOutSink{Name: "durable", Storage: durable, Required: true},
+		FanOutSink{Name: "attribution", Storage: attribution, Required: true, EventTypes: []model.TrackingEventType{model.TrackingEventTypeClick}},
+		FanOutSink{Name: "realtime", Storage: realtime, Required: true},
+	)
+	if err != nil {
+		t.Fatalf("Create fan-out storage: %v", err)
+	}
+	deadLetters := &deadLetterRecorder{}
+	tracking := NewTrackingService(1, fanOut, time.Second, zap.NewNop().Sugar(),
+		WithRetryPolicy(RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond}),
+		WithDeadLetterStore(deadLetters))
+
+	// the events are dead-lettered for the failed sink only, the others have accepted them
+	events := []model.TrackingEvent{
+		{ID: "evt_1", EventType: model.TrackingEventTypeImpression},
+		{ID: "evt_2", EventType: model.TrackingEventTypeClick},
+	}
+	if err := tracking.flushTrackingEventsBuffer(t.Context(), events); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	if len(deadLetters.batches) != 1 || deadLetters.sinks[0] != "attribution" ||
+		len(deadLetters.batches[0]) != 1 || deadLetters.batches[0][0].ID != "evt_2" {
+		t.Errorf("Events must be dead-lettered for the failed sink: %v, %+v", deadLetters.sinks, deadLetters.batches)
+	}
+
+	// events failed in several sinks are dead-lettered for each of them
+	deadLetters.batches, deadLetters.sinks = nil, nil
+	realtime.err = errors.New("aggregator is unavailable")
+	if err := tracking.flushTrackingEventsBuffer(t.Context(), events); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	slices.Sort(deadLetters.sinks)
+	if !slices.Equal(deadLetters.sinks, []string{"attribution", "realtime"}) {
+		t.Errorf("Events must be dead-lettered for every failed sink: %v", deadLetters.sinks)
+	}
+}
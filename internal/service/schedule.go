@@ -0,0 +1,167 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"sweng-task/internal/model"
+)
+
+// Clock provides the current time, it is replaced by a fake one in tests
+type Clock interface {
+	Now() time.Time
+}
+
+// SystemClock is the Clock of the system time
+type SystemClock struct{}
+
+// Now returns the current system time
+func (SystemClock) Now() time.Time {
+	return time.Now()
+}
+
+// sweeperActor is the actor of changes made by the expired line items sweeper
+const sweeperActor = "system:sweeper"
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// locations caches loaded time zones, time.LoadLocation reads the zone database on every call
+var locations sync.Map
+
+func loadLocation(name string) (*time.Location, error) {
+	if loc, ok := locations.Load(name); ok {
+		return loc.(*time.Location), nil
+	}
+
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, err
+	}
+	locations.Store(name, loc)
+	return loc, nil
+}
+
+// validateFlight validates flight dates and the schedule of a line item
+func validateFlight(startAt, endAt *time.Time, schedule *model.Schedule) error {
+	if startAt != nil && endAt != nil && !startAt.Before(*endAt) {
+		return fmt.Errorf("%w: start_at must be before end_at", ErrInvalidLineItem)
+	}
+	if schedule == nil {
+		return nil
+	}
+
+	if _, err := loadLocation(schedule.Timezone); err != nil {
+		return fmt.Errorf("%w: unknown timezone %q", ErrInvalidLineItem, schedule.Timezone)
+	}
+	for _, day := range schedule.Days {
+		if _, ok := weekdays[day]; !ok {
+			return fmt.Errorf("%w: unknown day %q, expected one of: mon, tue, wed, thu, fri, sat, sun", ErrInvalidLineItem, day)
+		}
+	}
+	for _, hour := range schedule.Hours {
+		if hour < 0 || hour > 23 {
+			return fmt.Errorf("%w: hour %d must be in the range [0-23]", ErrInvalidLineItem, hour)
+		}
+	}
+	return nil
+}
+
+// isScheduled reports if a line item may be served at the given time
+// according to its flight dates and schedule
+func isScheduled(item *model.LineItem, now time.Time) bool {
+	if item.StartAt != nil && now.Before(*item.StartAt) {
+		return false
+	}
+	if item.EndAt != nil && !now.Before(*item.EndAt) {
+		return false
+	}
+
+	schedule := item.Schedule
+	if schedule == nil || (len(schedule.Days) == 0 && len(schedule.Hours) == 0) {
+		return true
+	}
+
+	loc, err := loadLocation(schedule.Timezone)
+	if err != nil {
+		// schedules are validated on write, but better not to serve an item than to overspend
+		return false
+	}
+	local := now.In(loc)
+
+	if len(schedule.Days) > 0 && !slices.ContainsFunc(schedule.Days, func(day string) bool { return weekdays[day] == local.Weekday() }) {
+		return false
+	}
+	if len(schedule.Hours) > 0 && !slices.Contains(schedule.Hours, local.Hour()) {
+		return false
+	}
+	return true
+}
+
+// CompleteExpired completes active and paused line items whose flight is over
+func (s *LineItemService) CompleteExpired() (int, error) {
+	items, err := s.repo.GetAll("", "")
+	if err != nil {
+		return 0, fmt.Errorf("get line items: %w", err)
+	}
+
+	now := s.clock.Now()
+	var completed int
+	for _, item := range items {
+		if item.EndAt == nil || now.Before(*item.EndAt) {
+			continue
+		}
+		if item.Status != model.LineItemStatusActive && item.Status != model.LineItemStatusPaused {
+			continue
+		}
+
+		// the version guards against completing an item which was just prolonged
+		_, err := s.SetStatus(item.ID, model.LineItemStatusCompleted, item.Version, model.ChangeMeta{Actor: sweeperActor})
+		if err != nil {
+			s.log.Warnw("Cannot complete expired line item",
+				"id", item.ID,
+				"error", err,
+			)
+			continue
+		}
+		completed++
+	}
+	return completed, nil
+}
+
+// ExpiredLineItemsSweeper periodically completes line items whose flight is over
+func (s *LineItemService) ExpiredLineItemsSweeper(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			completed, err := s.CompleteExpired()
+			if err != nil {
+				s.log.Errorw("Cannot complete expired line items",
+					"error", err,
+				)
+				continue
+			}
+			if completed > 0 {
+				s.log.Infow("Expired line items completed",
+					"count", completed,
+				)
+			}
+
+		case <-ctx.Done():
+			return
+		}
+	}
+}
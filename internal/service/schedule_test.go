@@ -0,0 +1,204 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"sweng-task/internal/model"
+
+	"go.uber.org/zap"
+)
+
+// fakeClock is a manually moved Clock
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func timePtr(t time.Time) *time.Time {
+	return &t
+}
+
+func TestIsScheduled(t *testing.T) {
+	// Monday
+	base := time.Date(2025, 6, 2, 12, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		item model.LineItem
+		now  time.Time
+		want bool
+	}{
+		{"no flight", model.LineItem{}, base, true},
+		{"before start", model.LineItem{StartAt: timePtr(base.Add(time.Hour))}, base, false},
+		{"at start", model.LineItem{StartAt: timePtr(base)}, base, true},
+		{"before end", model.LineItem{EndAt: timePtr(base.Add(time.Second))}, base, true},
+		{"at end", model.LineItem{EndAt: timePtr(base)}, base, false},
+		{"empty schedule", model.LineItem{Schedule: &model.Schedule{Timezone: "Asia/Tokyo"}}, base, true},
+		{"day matches", model.LineItem{Schedule: &model.Schedule{Days: []string{"mon", "tue"}}}, base, true},
+		{"day doesn't match", model.LineItem{Schedule: &model.Schedule{Days: []string{"sat", "sun"}}}, base, false},
+		{"hour matches", model.LineItem{Schedule: &model.Schedule{Hours: []int{12}}}, base, true},
+		{"hour doesn't match", model.LineItem{Schedule: &model.Schedule{Hours: []int{9, 10, 11}}}, base, false},
+		// 12:30 UTC is 21:30 in Tokyo
+		{"hour in timezone", model.LineItem{Schedule: &model.Schedule{Timezone: "Asia/Tokyo", Hours: []int{21}}}, base, true},
+		// 23:30 UTC on Monday is already Tuesday in Tokyo
+		{"day in timezone", model.LineItem{Schedule: &model.Schedule{Timezone: "Asia/Tokyo", Days: []string{"tue"}}}, base.Add(11 * time.Hour), true},
+		{"day and hour", model.LineItem{Schedule: &model.Schedule{Days: []string{"mon"}, Hours: []int{13}}}, base, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isScheduled(&tt.item, tt.now); got != tt.want {
+				t.Errorf("isScheduled() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLineItemService_FlightValidation(t *testing.T) {
+	now := time.Date(2025, 6, 2, 12, 0, 0, 0, time.UTC)
+	s := NewLineItemService(NewMemoryLineItemRepository(), NewMemoryLineItemHistory(), &fakeClock{now: now}, zap.NewNop().Sugar())
+
+	tests := []struct {
+		name  string
+		input model.LineItemCreate
+	}{
+		{"end before start", model.LineItemCreate{StartAt: timePtr(now), EndAt: timePtr(now.Add(-time.Hour))}},
+		{"unknown timezone", model.LineItemCreate{Schedule: &model.Schedule{Timezone: "Mars/Olympus"}}},
+		{"unknown day", model.LineItemCreate{Schedule: &model.Schedule{Days: []string{"monday"}}}},
+		{"wrong hour", model.LineItemCreate{Schedule: &model.Schedule{Hours: []int{24}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input := tt.input
+			input.Name, input.Bid, input.Budget, input.Placement = "test", 1, 100, "header"
+			_, err := s.Create(input, model.ChangeMeta{})
+			if !errors.Is(err, ErrInvalidLineItem) {
+				t.Errorf("Wrong error: %v", err)
+			}
+		})
+	}
+}
+
+func TestLineItemService_ClearFlight(t *testing.T) {
+	now := time.Date(2025, 6, 2, 12, 0, 0, 0, time.UTC)
+	s := NewLineItemService(NewMemoryLineItemRepository(), NewMemoryLineItemHistory(), &fakeClock{now: now}, zap.NewNop().Sugar())
+	item, err := s.Create(model.LineItemCreate{
+		Name:      "test",
+		Bid:       1,
+		Budget:    100,
+		Placement: "header",
+		StartAt:   timePtr(now.Add(time.Hour)),
+		EndAt:     timePtr(now.Add(2 * time.Hour)),
+		Schedule:  &model.Schedule{Days: []string{"mon"}},
+	}, model.ChangeMeta{})
+	if err != nil {
+		t.Fatalf("Create line item: %v", err)
+	}
+
+	// absent fields are kept, explicit nulls clear them
+	var update model.LineItemUpdate
+	if err := json.Unmarshal([]byte(`{"name":"renamed","end_at":null,"schedule":null}`), &update); err != nil {
+		t.Fatalf("Decode update: %v", err)
+	}
+	item, err = s.Update(item.ID, update, item.Version, model.ChangeMeta{})
+	if err != nil {
+		t.Fatalf("Update line item: %v", err)
+	}
+	if item.StartAt == nil || item.EndAt != nil || item.Schedule != nil {
+		t.Errorf("Wrong flight: %v, %v, %+v", item.StartAt, item.EndAt, item.Schedule)
+	}
+
+	item, err = s.Update(item.ID, model.LineItemUpdate{StartAt: model.Nullable[time.Time]{Set: true}}, item.Version, model.ChangeMeta{})
+	if err != nil || item.StartAt != nil {
+		t.Errorf("Start must be cleared: %+v, %v", item, err)
+	}
+
+	// the end is validated against the start
+	_, err = s.Update(item.ID, model.LineItemUpdate{
+		StartAt: model.NullableValue(now),
+		EndAt:   model.NullableValue(now.Add(-time.Hour)),
+	}, item.Version, model.ChangeMeta{})
+	if !errors.Is(err, ErrInvalidLineItem) {
+		t.Errorf("Wrong error for end before start: %v", err)
+	}
+}
+
+func TestLineItemService_FindMatchingLineItems_Flight(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2025, 6, 2, 12, 0, 0, 0, time.UTC)}
+	s := NewLineItemService(NewMemoryLineItemRepository(), NewMemoryLineItemHistory(), clock, zap.NewNop().Sugar())
+
+	_, err := s.Create(model.LineItemCreate{
+		Name:      "test_1",
+		Bid:       1,
+		Budget:    100,
+		Placement: "header",
+		StartAt:   timePtr(clock.now.Add(time.Hour)),
+		EndAt:     timePtr(clock.now.Add(2 * time.Hour)),
+	}, model.ChangeMeta{})
+	if err != nil {
+		t.Fatalf("Create line item: %v", err)
+	}
+
+	for _, step := range []struct {
+		shift time.Duration
+		want  int
+	}{
+		{0, 0},
+		{time.Hour, 1},
+		{time.Hour, 0},
+	} {
+		clock.now = clock.now.Add(step.shift)
+		items, err := s.FindMatchingLineItems(model.TargetingQuery{Placement: "header"})
+		if err != nil {
+			t.Fatalf("Find matching line items: %v", err)
+		}
+		if len(items) != step.want {
+			t.Errorf("Wrong amount of matching line items at %v: %d != %d", clock.now, len(items), step.want)
+		}
+	}
+}
+
+func TestLineItemService_CompleteExpired(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2025, 6, 2, 12, 0, 0, 0, time.UTC)}
+	s := NewLineItemService(NewMemoryLineItemRepository(), NewMemoryLineItemHistory(), clock, zap.NewNop().Sugar())
+
+	expiring, err := s.Create(model.LineItemCreate{Name: "expiring", Bid: 1, Budget: 100, Placement: "header", EndAt: timePtr(clock.now.Add(time.Hour))}, model.ChangeMeta{})
+	if err != nil {
+		t.Fatalf("Create line item: %v", err)
+	}
+	endless, err := s.Create(model.LineItemCreate{Name: "endless", Bid: 1, Budget: 100, Placement: "header"}, model.ChangeMeta{})
+	if err != nil {
+		t.Fatalf("Create line item: %v", err)
+	}
+
+	completed, err := s.CompleteExpired()
+	if err != nil || completed != 0 {
+		t.Fatalf("Nothing must be completed yet: %d, %v", completed, err)
+	}
+
+	clock.now = clock.now.Add(time.Hour)
+	completed, err = s.CompleteExpired()
+	if err != nil || completed != 1 {
+		t.Fatalf("Expired line item must be completed: %d, %v", completed, err)
+	}
+
+	item, _ := s.GetByID(expiring.ID)
+	if item.Status != model.LineItemStatusCompleted {
+		t.Errorf("Wrong status of expired line item: %s", item.Status)
+	}
+	item, _ = s.GetByID(endless.ID)
+	if item.Status != model.LineItemStatusActive {
+		t.Errorf("Wrong status of endless line item: %s", item.Status)
+	}
+
+	changes, _ := s.History(expiring.ID, 1, 10)
+	if len(changes) != 1 || changes[0].Actor != sweeperActor {
+		t.Errorf("Completion is not recorded: %+v", changes)
+	}
+}
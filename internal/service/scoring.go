@@ -0,0 +1,146 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"slices"
+	"sync/atomic"
+	"time"
+
+	"sweng-task/internal/model"
+)
+
+// Scorer computes how relevant a line item is to an ad request.
+// Implementations must be safe for concurrent use.
+type Scorer interface {
+	// Score returns the relevance of a line item in the range (0-1]
+	Score(request model.AdRequest, item *model.LineItem) float64
+}
+
+// TFIDFScorer scores line items by the cosine similarity of TF-IDF vectors of the request
+// and the line item targeting. Placement, categories and stems of keyword words are the terms.
+// Terms which are targeted by many line items weigh less than rare ones,
+// the more requested terms a line item matches, the higher it scores,
+// and line items targeting fewer terms are more specific, so they score higher too.
+//
+// Document frequencies of terms are computed over active line items by Refresh.
+type TFIDFScorer struct {
+	stats atomic.Pointer[corpusStats]
+}
+
+// corpusStats are term statistics of the line items corpus
+type corpusStats struct {
+	documents int
+	frequency map[string]int // number of line items targeting a term
+}
+
+// NewTFIDFScorer creates a new TFIDFScorer without corpus statistics, all terms weigh equally until Refresh
+func NewTFIDFScorer() *TFIDFScorer {
+	s := &TFIDFScorer{}
+	s.stats.Store(&corpusStats{})
+	return s
+}
+
+// Refresh recomputes term statistics over the given line items
+func (s *TFIDFScorer) Refresh(items []*model.LineItem) {
+	stats := &corpusStats{
+		documents: len(items),
+		frequency: make(map[string]int),
+	}
+	for _, item := range items {
+		for term := range lineItemTerms(item) {
+			stats.frequency[term]++
+		}
+	}
+	s.stats.Store(stats)
+}
+
+// Score returns the cosine similarity of the request and the line item
+func (s *TFIDFScorer) Score(request model.AdRequest, item *model.LineItem) float64 {
+	stats := s.stats.Load()
+
+	var dot, requestNorm, itemNorm float64
+	itemTerms := lineItemTerms(item)
+	for term := range itemTerms {
+		w := stats.idf(term)
+		itemNorm += w * w
+	}
+	for term := range requestTerms(request) {
+		w := stats.idf(term)
+		requestNorm += w * w
+		if _, ok := itemTerms[term]; ok {
+			dot += w * w
+		}
+	}
+	if dot == 0 {
+		return 0
+	}
+	return dot / math.Sqrt(requestNorm*itemNorm)
+}
+
+// idf is the smoothed inverse document frequency, it is always positive
+func (s *corpusStats) idf(term string) float64 {
+	return math.Log(float64(s.documents+1)/float64(s.frequency[term]+1)) + 1
+}
+
+// RefreshLoop periodically refreshes term statistics with active line items
+func (s *TFIDFScorer) RefreshLoop(ctx context.Context, lineItems *LineItemService, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.refreshActive(lineItems); err != nil {
+			lineItems.log.Errorw("Cannot refresh relevance scoring statistics",
+				"error", err,
+			)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (s *TFIDFScorer) refreshActive(lineItems *LineItemService) error {
+	items, err := lineItems.GetAll("", "")
+	if err != nil {
+		return fmt.Errorf("get line items: %w", err)
+	}
+
+	s.Refresh(slices.DeleteFunc(items, func(item *model.LineItem) bool {
+		return item.Status != model.LineItemStatusActive
+	}))
+	return nil
+}
+
+// lineItemTerms returns the set of targeting terms of a line item,
+// terms of different kinds are prefixed, so a keyword never equals a category
+func lineItemTerms(item *model.LineItem) map[string]struct{} {
+	terms := make(map[string]struct{}, 1+len(item.Categories)+len(item.Keywords))
+	terms["p:"+item.Placement] = struct{}{}
+	for _, category := range item.Categories {
+		terms["c:"+category] = struct{}{}
+	}
+	for _, term := range KeywordIndexTerms(item) {
+		terms["k:"+term] = struct{}{}
+	}
+	return terms
+}
+
+// requestTerms returns the set of terms of an ad request
+func requestTerms(request model.AdRequest) map[string]struct{} {
+	terms := make(map[string]struct{}, 1+len(request.Categories)+len(request.Keywords))
+	terms["p:"+request.Placement] = struct{}{}
+	for _, category := range request.Categories {
+		terms["c:"+category] = struct{}{}
+	}
+	for _, keyword := range request.Keywords {
+		for _, term := range KeywordTerms(keyword) {
+			terms["k:"+term] = struct{}{}
+		}
+	}
+	return terms
+}
@@ -0,0 +1,94 @@
+package service
+
+import (
+	"math"
+	"testing"
+
+	"sweng-task/internal/model"
+
+	"go.uber.org/zap"
+)
+
+func TestTFIDFScorer_Score(t *testing.T) {
+	specific := &model.LineItem{ID: "li_specific", Placement: "header", Categories: []string{"toys"}, Keywords: []string{"lego"}}
+	broad := &model.LineItem{ID: "li_broad", Placement: "header", Categories: []string{"toys", "games", "books"}, Keywords: []string{"lego", "puzzle", "summer"}}
+	common := &model.LineItem{ID: "li_common", Placement: "header", Categories: []string{"toys"}, Keywords: []string{"summer"}}
+	other := &model.LineItem{ID: "li_other", Placement: "sidebar", Categories: []string{"books"}}
+
+	scorer := NewTFIDFScorer()
+	scorer.Refresh([]*model.LineItem{specific, broad, common, other, {Placement: "header", Keywords: []string{"summer"}}})
+
+	request := model.AdRequest{TargetingQuery: model.TargetingQuery{Placement: "header", Categories: []string{"toys"}, Keywords: []string{"lego"}}}
+	if got := scorer.Score(request, specific); math.Abs(got-1) > 1e-9 {
+		t.Errorf("Exactly matching line item must score 1: %v", got)
+	}
+	if specificScore, broadScore := scorer.Score(request, specific), scorer.Score(request, broad); specificScore <= broadScore {
+		t.Errorf("Specific line item must score higher than broad one: %v <= %v", specificScore, broadScore)
+	}
+
+	// 'lego' is rarer than 'summer', so it weighs more
+	rare := scorer.Score(model.AdRequest{TargetingQuery: model.TargetingQuery{Placement: "header", Keywords: []string{"lego"}}}, specific)
+	frequent := scorer.Score(model.AdRequest{TargetingQuery: model.TargetingQuery{Placement: "header", Keywords: []string{"summer"}}}, common)
+	if rare <= frequent {
+		t.Errorf("Rare term must weigh more than frequent one: %v <= %v", rare, frequent)
+	}
+
+	// more matched terms score higher
+	page := model.AdRequest{TargetingQuery: model.TargetingQuery{Placement: "header", Keywords: []string{"lego", "puzzle", "summer"}}}
+	if broadScore, specificScore := scorer.Score(page, broad), scorer.Score(page, specific); broadScore <= specificScore {
+		t.Errorf("Line item matching more terms must score higher: %v <= %v", broadScore, specificScore)
+	}
+
+	if got := scorer.Score(request, other); got != 0 {
+		t.Errorf("Line item without common terms must score 0: %v", got)
+	}
+}
+
+func TestAdService_GetWinningAds_Relevance(t *testing.T) {
+	lineItemsService := NewLineItemService(NewMemoryLineItemRepository(), NewMemoryLineItemHistory(), SystemClock{}, zap.NewNop().Sugar())
+
+	relevant, err := lineItemsService.Create(model.LineItemCreate{Name: "relevant", Bid: 2, Budget: 100, Placement: "header", Keywords: []string{"lego"}}, model.ChangeMeta{})
+	if err != nil {
+		t.Fatalf("Create line item: %v", err)
+	}
+	_, err = lineItemsService.Create(model.LineItemCreate{Name: "broad", Bid: 2.5, Budget: 100, Placement: "header", Keywords: []string{"lego", "summer", "sale", "kids"}}, model.ChangeMeta{})
+	if err != nil {
+		t.Fatalf("Create line item: %v", err)
+	}
+
+	scorer := NewTFIDFScorer()
+	if err := scorer.refreshActive(lineItemsService); err != nil {
+		t.Fatalf("Refresh scorer: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		weight float64
+		want   string
+	}{
+		{"bid only", 0, "broad"},
+		{"relevance", 1, relevant.Name},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			adService := NewAdService(lineItemsService, zap.NewNop().Sugar(), WithScorer(scorer, tt.weight))
+
+			ads, err := adService.GetWinningAds(model.AdRequest{
+				TargetingQuery: model.TargetingQuery{Placement: "header", Keywords: []string{"lego"}},
+				Limit:          2,
+			})
+			if err != nil {
+				t.Fatalf("Get winning ads: %v", err)
+			}
+			if len(ads) != 2 {
+				t.Fatalf("Wrong amount of winning ads: %d != 2", len(ads))
+			}
+			if ads[0].Name != tt.want {
+				t.Errorf("Wrong first winning ad: %+v", ads[0])
+			}
+			if ads[0].Score < ads[1].Score || ads[0].Relevance <= 0 || ads[0].Relevance > 1 {
+				t.Errorf("Wrong scores: %+v", ads)
+			}
+		})
+	}
+}
@@ -2,22 +2,133 @@ package service
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"hash/fnv"
+	"strings"
+	"sweng-task/internal/metrics"
 	"sweng-task/internal/model"
+	"sync"
 	"time"
 
 	"go.uber.org/zap"
 )
 
+var (
+	// ErrInvalidTrackingEvent is returned for tracking events which cannot be recorded
+	ErrInvalidTrackingEvent = errors.New("invalid tracking event")
+	// ErrTrackingOverloaded is returned for events which cannot be accepted now, they should be sent again later
+	ErrTrackingOverloaded = errors.New("tracking is overloaded")
+
+	// errTrackingStopped is returned for events sent during the shutdown, another instance may accept them
+	errTrackingStopped = fmt.Errorf("%w: tracking is stopped", ErrTrackingOverloaded)
+)
+
+// OverflowPolicy defines what happens to events once the events buffer is full
+type OverflowPolicy string
+
+const (
+	// OverflowWait waits for free space in the buffer up to a timeout, then rejects the event
+	OverflowWait OverflowPolicy = "wait"
+	// OverflowSpill writes events to the spill queue, they are passed to the worker once the buffer has space
+	OverflowSpill OverflowPolicy = "spill"
+	// OverflowReject rejects events immediately
+	OverflowReject OverflowPolicy = "reject"
+)
+
+// SpillQueue keeps events which don't fit into the events buffer
+type SpillQueue interface {
+	// Push appends an event to the queue, the event must be durable once it returns,
+	// it is committed to the event log right after
+	Push(model.TrackingEvent) error
+	// Replay passes queued events to fn in the order they were pushed, an event is removed
+	// from the queue once fn returns no error for it
+	Replay(ctx context.Context, fn func(model.TrackingEvent) error) error
+}
+
 // TrackingService provides operations for tracking
 type TrackingService struct {
-	inputTrackingEvents       chan model.TrackingEvent
+	shards                    []chan queuedEvent // events of a line item go to one shard, every shard has a worker
 	eventsStorage             TrackingEventsStorage
 	eventsStorageWriteTimeout time.Duration
+	retry                     RetryPolicy
+	deadLetters               DeadLetterStore
+	listeners                 []TrackingEventListener
+	overflow                  OverflowPolicy
+	overflowWait              time.Duration
+	spill                     SpillQueue
+	eventLog                  EventLog
+	dedup                     EventDeduplicator
+	dedupMu                   sync.Mutex
+	inFlight                  map[string]struct{} // IDs of events being recorded
+	stopMu                    sync.RWMutex        // held for reading while an event is passed to the worker
+	stopped                   bool
 
 	log *zap.SugaredLogger
 }
 
+// TrackingEventListener is notified about every accepted signed tracking event,
+// it is called synchronously, so it must be fast
+type TrackingEventListener interface {
+	OnTrackingEvent(event model.TrackingEvent)
+}
+
+// TrackingServiceOption configures optional parts of TrackingService
+type TrackingServiceOption func(*TrackingService)
+
+// WithTrackingEventListener adds a listener of accepted signed tracking events
+func WithTrackingEventListener(listener TrackingEventListener) TrackingServiceOption {
+	return func(s *TrackingService) {
+		s.listeners = append(s.listeners, listener)
+	}
+}
+
+// WithEventDeduplicator drops events with IDs of recently accepted events
+func WithEventDeduplicator(dedup EventDeduplicator) TrackingServiceOption {
+	return func(s *TrackingService) {
+		s.dedup = dedup
+	}
+}
+
+// WithOverflowPolicy sets what happens to events once the buffer is full,
+// 'wait' is the timeout of the OverflowWait policy. Events are rejected immediately by default.
+func WithOverflowPolicy(policy OverflowPolicy, wait time.Duration) TrackingServiceOption {
+	return func(s *TrackingService) {
+		s.overflow = policy
+		s.overflowWait = wait
+	}
+}
+
+// WithSpillQueue sets the queue of the OverflowSpill policy
+func WithSpillQueue(spill SpillQueue) TrackingServiceOption {
+	return func(s *TrackingService) {
+		s.spill = spill
+	}
+}
+
+// WithWorkers shards events by line item between n workers, each of them writes its own batches,
+// so events of a line item keep their order. The buffer is split between shards, there is one worker by default.
+func WithWorkers(n int) TrackingServiceOption {
+	return func(s *TrackingService) {
+		s.shards = make([]chan queuedEvent, max(n, 1))
+	}
+}
+
+// WithRetryPolicy retries failed writes of events batches, they are written once by default
+func WithRetryPolicy(policy RetryPolicy) TrackingServiceOption {
+	return func(s *TrackingService) {
+		s.retry = policy
+	}
+}
+
+// WithDeadLetterStore keeps batches which cannot be written after all attempts,
+// without it the worker stops once a batch cannot be written
+func WithDeadLetterStore(store DeadLetterStore) TrackingServiceOption {
+	return func(s *TrackingService) {
+		s.deadLetters = store
+	}
+}
+
 // TrackingEventsStorage persists tracking events
 type TrackingEventsStorage interface {
 	Write(context.Context, []model.TrackingEvent) error
@@ -30,38 +141,294 @@ func (f TrackingEventsStorageFunc) Write(ctx context.Context, events []model.Tra
 }
 
 // NewTrackingService creates a new TrackingService
-func NewTrackingService(eventsBufferSize int, trackingEventsStorage TrackingEventsStorage, trackingEventsWriteTimeout time.Duration, log *zap.SugaredLogger) *TrackingService {
-	return &TrackingService{
-		inputTrackingEvents:       make(chan model.TrackingEvent, eventsBufferSize),
+func NewTrackingService(eventsBufferSize int, trackingEventsStorage TrackingEventsStorage, trackingEventsWriteTimeout time.Duration, log *zap.SugaredLogger, opts ...TrackingServiceOption) *TrackingService {
+	s := &TrackingService{
+		shards:                    make([]chan queuedEvent, 1),
 		eventsStorage:             trackingEventsStorage,
 		eventsStorageWriteTimeout: trackingEventsWriteTimeout,
+		retry:                     noRetries,
+		overflow:                  OverflowReject,
+		inFlight:                  make(map[string]struct{}),
 
 		log: log,
 	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	shardBufferSize := max((eventsBufferSize+len(s.shards)-1)/len(s.shards), 1)
+	for i := range s.shards {
+		s.shards[i] = make(chan queuedEvent, shardBufferSize)
+	}
+	return s
+}
+
+// ValidateOverflowPolicy checks the overflow policy has everything it needs
+func (s *TrackingService) ValidateOverflowPolicy() error {
+	switch s.overflow {
+	case OverflowReject:
+	case OverflowWait:
+		if s.overflowWait <= 0 {
+			return fmt.Errorf("overflow wait timeout must be positive")
+		}
+	case OverflowSpill:
+		if s.spill == nil {
+			return fmt.Errorf("overflow policy %q requires a spill queue", s.overflow)
+		}
+	default:
+		return fmt.Errorf("unknown overflow policy %q, expected one of: wait, spill, reject", s.overflow)
+	}
+	return nil
 }
 
 // RecordAdInteraction records ad interactions.
-// Unblocking operation.
+// It doesn't block longer than the wait timeout of the overflow policy.
+// Events which cannot be accepted now return ErrTrackingOverloaded.
+// Events without an ID get one derived from the auction, so retries of the same event have the same ID.
+// Duplicates of recently accepted events are reported as accepted, but are not recorded again.
+// Events must come from signed URLs, listeners charge budgets and count frequency caps by them.
 func (s *TrackingService) RecordAdInteraction(t model.TrackingEvent) (bool, error) {
-	// simple implementation, there are several ways to improvement
-	// one of which is to add a timeout to wait
-	//
-	// TODO: stop accepting events if graceful shutdown signal is received
-	select {
-	case s.inputTrackingEvents <- t:
+	return s.record(t, true)
+}
+
+// RecordUnsignedAdInteraction validates and records ad interactions reported by clients.
+// Clients may forge line items, users and clearing prices, so listeners are not notified about these events:
+// they are written to the sink only and don't spend budgets or count toward frequency caps.
+func (s *TrackingService) RecordUnsignedAdInteraction(t model.TrackingEvent) (bool, error) {
+	if err := ValidateTrackingEvent(t); err != nil {
+		return false, err
+	}
+	return s.record(t, false)
+}
+
+func (s *TrackingService) record(t model.TrackingEvent, signed bool) (bool, error) {
+	if t.ID == "" && t.AuctionID != "" {
+		t.ID = derivedEventID(t)
+	}
+	if s.dedup == nil || t.ID == "" {
+		if err := s.enqueue(t, signed); err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+
+	key := dedupKey(t, signed)
+	s.dedupMu.Lock()
+	if s.dedup.Seen(key) {
+		s.dedupMu.Unlock()
+		metrics.TrackingEventsDuplicates.WithLabelValues(string(t.EventType)).Inc()
 		return true, nil
+	}
+	if _, ok := s.inFlight[key]; ok {
+		// the same event is being recorded, it is not known yet if it is accepted
+		s.dedupMu.Unlock()
+		return false, fmt.Errorf("%w: event %q is being recorded", ErrTrackingOverloaded, t.ID)
+	}
+	s.inFlight[key] = struct{}{}
+	s.dedupMu.Unlock()
+
+	// the ID is remembered only once the event is accepted, so a retry of a rejected event is not dropped
+	err := s.enqueue(t, signed)
+
+	s.dedupMu.Lock()
+	defer s.dedupMu.Unlock()
+	delete(s.inFlight, key)
+	if err != nil {
+		return false, err
+	}
+	s.dedup.Add(key)
+	return true, nil
+}
+
+// dedupKey separates IDs of signed and unsigned events. Auction IDs of served ads are public,
+// so an unsigned event with a derived ID must not suppress the signed event charging the budget.
+func dedupKey(t model.TrackingEvent, signed bool) string {
+	if signed {
+		return t.ID
+	}
+	return "unsigned:" + t.ID
+}
+
+// ValidateTrackingEvent validates a tracking event, all errors wrap ErrInvalidTrackingEvent
+func ValidateTrackingEvent(t model.TrackingEvent) error {
+	switch {
+	case t.EventType != model.TrackingEventTypeImpression && t.EventType != model.TrackingEventTypeClick && t.EventType != model.TrackingEventTypeConversion:
+		return fmt.Errorf("%w: unknown event type %q, expected one of: impression, click, conversion", ErrInvalidTrackingEvent, t.EventType)
+	case t.LineItemID == "":
+		return fmt.Errorf("%w: line item id must not be empty", ErrInvalidTrackingEvent)
+	case t.ClearingPrice < 0:
+		return fmt.Errorf("%w: clearing price must not be negative", ErrInvalidTrackingEvent)
+	}
+	return nil
+}
+
+// enqueue appends an event to the event log, passes it to the worker and notifies listeners about signed events,
+// the overflow policy decides what happens if the buffer is full.
+// Events which are not passed to the worker are committed to the event log right away:
+// rejected ones are sent again by clients and spilled ones are kept by the spill queue.
+func (s *TrackingService) enqueue(t model.TrackingEvent, signed bool) error {
+	s.stopMu.RLock()
+	defer s.stopMu.RUnlock()
+	if s.stopped {
+		return errTrackingStopped
+	}
+
+	q := queuedEvent{TrackingEvent: t}
+	if s.eventLog != nil {
+		offset, err := s.eventLog.Append(t, signed)
+		if err != nil {
+			return fmt.Errorf("append to event log: %w", err)
+		}
+		q.offset = offset
+	}
+
+	shard := s.shard(t)
+	select {
+	case shard <- q:
+		s.notify(t, signed)
+		return nil
 	default:
-		return false, nil
 	}
+
+	switch s.overflow {
+	case OverflowWait:
+		timer := time.NewTimer(s.overflowWait)
+		defer timer.Stop()
+		select {
+		case shard <- q:
+			s.notify(t, signed)
+			return nil
+		case <-timer.C:
+			s.commitEventLog(q)
+			metrics.TrackingEventsDropped.WithLabelValues("wait_timeout").Inc()
+			return fmt.Errorf("%w: events buffer is full", ErrTrackingOverloaded)
+		}
+	case OverflowSpill:
+		err := s.spill.Push(t)
+		s.commitEventLog(q)
+		if err != nil {
+			s.log.Errorw("Cannot spill tracking event",
+				"error", err,
+			)
+			metrics.TrackingEventsDropped.WithLabelValues("spill_failed").Inc()
+			return fmt.Errorf("%w: events buffer is full", ErrTrackingOverloaded)
+		}
+		metrics.TrackingEventsSpilled.Inc()
+		s.notify(t, signed)
+		return nil
+	default:
+		s.commitEventLog(q)
+		metrics.TrackingEventsDropped.WithLabelValues("buffer_full").Inc()
+		return fmt.Errorf("%w: events buffer is full", ErrTrackingOverloaded)
+	}
+}
+
+func (s *TrackingService) notify(t model.TrackingEvent, signed bool) {
+	if !signed {
+		return
+	}
+	for _, listener := range s.listeners {
+		listener.OnTrackingEvent(t)
+	}
+}
+
+// ReplaySpilled periodically passes spilled events to the worker, it waits for free space in the buffer.
+// Listeners were notified once the events were spilled, so they are not notified again.
+func (s *TrackingService) ReplaySpilled(ctx context.Context, interval time.Duration) {
+	if s.spill == nil {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			err := s.spill.Replay(ctx, func(t model.TrackingEvent) error {
+				s.stopMu.RLock()
+				defer s.stopMu.RUnlock()
+				if s.stopped {
+					return errTrackingStopped
+				}
+				select {
+				case s.shard(t) <- queuedEvent{TrackingEvent: t}:
+					return nil
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			})
+			if err != nil && ctx.Err() == nil {
+				s.log.Errorw("Cannot replay spilled tracking events",
+					"error", err,
+				)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// StopAccepting rejects new events with ErrTrackingOverloaded and waits for the events being passed to the workers.
+// It is called on shutdown before the workers are stopped, so the workers flush every accepted event.
+func (s *TrackingService) StopAccepting() {
+	s.stopMu.Lock()
+	defer s.stopMu.Unlock()
+	s.stopped = true
+}
+
+// shard returns the channel of the worker the event goes to
+func (s *TrackingService) shard(t model.TrackingEvent) chan queuedEvent {
+	if len(s.shards) == 1 {
+		return s.shards[0]
+	}
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(t.LineItemID))
+	return s.shards[h.Sum32()%uint32(len(s.shards))]
+}
+
+// derivedEventID identifies an event by the auction the ad won, an ad is counted once per auction
+func derivedEventID(t model.TrackingEvent) string {
+	return strings.Join([]string{string(t.EventType), t.AuctionID, t.LineItemID}, ":")
 }
 
-// TrackingEventsWorker represents the main loop of the worker.
+// TrackingEventsWorker runs a worker per shard and waits for all of them.
+// Once ctx is done, every worker flushes all events passed to it and stops, call StopAccepting before.
+// Once a worker fails, the rest flush their buffers and stop too, since their events cannot be accepted any more.
+func (s *TrackingService) TrackingEventsWorker(ctx context.Context, maxChunkSize int, flushEvery time.Duration) error {
+	if len(s.shards) == 1 {
+		return s.trackingEventsWorker(ctx, s.shards[0], maxChunkSize, flushEvery)
+	}
+
+	ctx, stop := context.WithCancel(ctx)
+	defer stop()
+
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs []error
+	)
+	for i, shard := range s.shards {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := s.trackingEventsWorker(ctx, shard, maxChunkSize, flushEvery); err != nil {
+				mu.Lock()
+				errs = append(errs, fmt.Errorf("shard %d: %w", i, err))
+				mu.Unlock()
+				stop()
+			}
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// trackingEventsWorker represents the main loop of the worker of a shard.
 // Buffer will be flushed into the storage in two cases:
 // 1. buffer is reached max chunk size 'maxChunkSize'
 // 2. events shouldn't stay in the buffer longer than 'flushEvery' duration
-func (s *TrackingService) TrackingEventsWorker(ctx context.Context, maxChunkSize int, flushEvery time.Duration) error {
+func (s *TrackingService) trackingEventsWorker(ctx context.Context, input <-chan queuedEvent, maxChunkSize int, flushEvery time.Duration) error {
 	var isBufferFlushNeeded bool
-	buffer := make([]model.TrackingEvent, 0, maxChunkSize)
+	buffer := make([]queuedEvent, 0, maxChunkSize)
 	ticker := time.NewTicker(flushEvery)
 
 	for {
@@ -69,18 +436,22 @@ func (s *TrackingService) TrackingEventsWorker(ctx context.Context, maxChunkSize
 		case 0:
 			// no events in the buffer
 			select {
-			case event := <-s.inputTrackingEvents:
+			case event := <-input:
 				buffer = append(buffer, event)
 			default:
 				// check graceful shutdown only if no events in the chan
 
 				select {
-				case event := <-s.inputTrackingEvents:
+				case event := <-input:
 					buffer = append(buffer, event)
 
 				case <-ctx.Done():
-					// graceful shutdown
-					return nil
+					// graceful shutdown, once the events passed to the worker are flushed
+					buffer = drainInput(input, buffer, maxChunkSize)
+					if len(buffer) == 0 {
+						return nil
+					}
+					isBufferFlushNeeded = true
 				}
 			}
 			ticker.Reset(flushEvery)
@@ -88,14 +459,15 @@ func (s *TrackingService) TrackingEventsWorker(ctx context.Context, maxChunkSize
 		default:
 			// some events present in the buffer
 			select {
-			case event := <-s.inputTrackingEvents:
+			case event := <-input:
 				buffer = append(buffer, event)
 
 			case <-ticker.C:
 				isBufferFlushNeeded = true
 
 			case <-ctx.Done():
-				// graceful shutdown
+				// graceful shutdown, waiting events are flushed in full chunks
+				buffer = drainInput(input, buffer, maxChunkSize)
 				isBufferFlushNeeded = true
 			}
 		}
@@ -104,32 +476,103 @@ func (s *TrackingService) TrackingEventsWorker(ctx context.Context, maxChunkSize
 			isBufferFlushNeeded = true
 		}
 		if isBufferFlushNeeded {
-			err := s.flushTrackingEventsBuffer(buffer)
+			events := make([]model.TrackingEvent, len(buffer))
+			for i, event := range buffer {
+				events[i] = event.TrackingEvent
+			}
+			err := s.flushTrackingEventsBuffer(ctx, events)
 			if err != nil {
-				// TODO: repeat N times in case of recovery
-
 				s.log.Errorw("Cannot flush events buffer",
 					"error", err,
 				)
-				// TODO: since we cannot flush any events, we cannot accept any new events
-				// maybe we should just shut down completely in this case
+				// since we cannot flush any events, we cannot accept any new events
 				return fmt.Errorf("flush buffer: %w", err)
 			}
+			// written or dead-lettered events are not replayed after a restart
+			s.commitEventLog(buffer...)
 
 			// TODO: potential optimization by using the sync.Pool
-			buffer = make([]model.TrackingEvent, 0, maxChunkSize)
+			buffer = make([]queuedEvent, 0, maxChunkSize)
 			isBufferFlushNeeded = false
 			ticker.Stop() // TODO: additional checks needed, maybe it is safe do not stop ticker each time
 		}
 	}
 }
 
-// flushTrackingEventsBuffer flushes tracking events to the external storage
-func (s *TrackingService) flushTrackingEventsBuffer(events []model.TrackingEvent) error {
+// drainInput moves events waiting in the input to the buffer until it has 'maxChunkSize' events
+func drainInput(input <-chan queuedEvent, buffer []queuedEvent, maxChunkSize int) []queuedEvent {
+	for len(buffer) < maxChunkSize {
+		select {
+		case event := <-input:
+			buffer = append(buffer, event)
+		default:
+			return buffer
+		}
+	}
+	return buffer
+}
+
+// flushTrackingEventsBuffer flushes tracking events to the external storage.
+// Failed writes are retried with backoffs, once all attempts fail the batch is put to the dead-letter store.
+// If the storage reports a partial write, only the failed events are retried.
+// Backoffs are skipped on shutdown, so the worker doesn't delay it. Events which failed in fan-out sinks
+// are dead-lettered per sink, so a replay doesn't send them to the sinks which accepted them.
+func (s *TrackingService) flushTrackingEventsBuffer(ctx context.Context, events []model.TrackingEvent) error {
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = s.writeTrackingEvents(events)
+		if err == nil {
+			return nil
+		}
+		var partial *PartialWriteError
+		if errors.As(err, &partial) {
+			events = partial.Failed
+		}
+		if attempt >= s.retry.MaxAttempts {
+			break
+		}
+
+		backoff := s.retry.Backoff(attempt)
+		s.log.Warnw("Cannot flush events buffer, retrying",
+			"attempt", attempt,
+			"backoff", backoff,
+			"error", err,
+		)
+		metrics.TrackingFlushRetries.Inc()
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+		}
+	}
+
+	if s.deadLetters == nil {
+		return err
+	}
+	failures := sinkWriteErrors(err)
+	if len(failures) == 0 {
+		failures = []*SinkWriteError{{Failed: events}}
+	}
+	ctx, stop := context.WithTimeout(context.Background(), s.eventsStorageWriteTimeout)
+	defer stop()
+	for _, failure := range failures {
+		if dlErr := s.deadLetters.Put(ctx, failure.Sink, failure.Failed); dlErr != nil {
+			return fmt.Errorf("%w, dead-letter: %w", err, dlErr)
+		}
+		metrics.TrackingDeadLetterEvents.Add(float64(len(failure.Failed)))
+		s.log.Errorw("Events batch is put to the dead-letter store",
+			"sink", failure.Sink,
+			"events", len(failure.Failed),
+			"error", err,
+		)
+	}
+	return nil
+}
+
+func (s *TrackingService) writeTrackingEvents(events []model.TrackingEvent) error {
 	ctx, stop := context.WithTimeout(context.Background(), s.eventsStorageWriteTimeout)
 	defer stop()
 
-	// TODO: push buffer to the external message queue
-	// as example, to Kafka
 	return s.eventsStorage.Write(ctx, events)
 }
@@ -0,0 +1,100 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+
+	"sweng-task/internal/model"
+	"sweng-task/internal/signing"
+
+	"go.uber.org/zap"
+)
+
+// SignedTrackingService records impressions and clicks coming from signed URLs of served ads.
+// Events carry the signed facts only, so clients cannot forge line items or clearing prices.
+type SignedTrackingService struct {
+	tracking  *TrackingService
+	lineItems *LineItemService
+	signer    *signing.Signer
+	clock     Clock
+
+	log *zap.SugaredLogger
+}
+
+// NewSignedTrackingService creates a new SignedTrackingService
+func NewSignedTrackingService(tracking *TrackingService, lineItems *LineItemService, signer *signing.Signer, clock Clock, log *zap.SugaredLogger) *SignedTrackingService {
+	return &SignedTrackingService{
+		tracking:  tracking,
+		lineItems: lineItems,
+		signer:    signer,
+		clock:     clock,
+		log:       log,
+	}
+}
+
+// Serve returns the line item of the ad signed into the token. Serving records no events,
+// the impression is recorded once the pixel of the served ad is requested.
+func (s *SignedTrackingService) Serve(token string) (*model.LineItem, error) {
+	claims, err := s.signer.Verify(token, s.clock.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	item, err := s.lineItems.GetByID(claims.LineItemID)
+	if err != nil {
+		return nil, fmt.Errorf("get line item: %w", err)
+	}
+	return item, nil
+}
+
+// RecordImpression records an impression of the ad signed into the token.
+// Tokens which cannot be verified return errors wrapping the signing errors.
+func (s *SignedTrackingService) RecordImpression(token string) (bool, error) {
+	claims, err := s.signer.Verify(token, s.clock.Now())
+	if err != nil {
+		return false, err
+	}
+	return s.record(model.TrackingEventTypeImpression, claims)
+}
+
+// RecordClick records a click of the ad signed into the token and returns the landing URL of its line item,
+// it is empty if the line item has no landing page
+func (s *SignedTrackingService) RecordClick(token string) (string, bool, error) {
+	claims, err := s.signer.Verify(token, s.clock.Now())
+	if err != nil {
+		return "", false, err
+	}
+
+	item, err := s.lineItems.GetByID(claims.LineItemID)
+	if err != nil {
+		return "", false, fmt.Errorf("get line item: %w", err)
+	}
+
+	ok, err := s.record(model.TrackingEventTypeClick, claims)
+	return item.LandingURL, ok, err
+}
+
+func (s *SignedTrackingService) record(eventType model.TrackingEventType, claims signing.Claims) (bool, error) {
+	ok, err := s.tracking.RecordAdInteraction(model.TrackingEvent{
+		EventType:     eventType,
+		LineItemID:    claims.LineItemID,
+		Timestamp:     s.clock.Now(),
+		Placement:     claims.Placement,
+		UserID:        claims.UserID,
+		AuctionID:     claims.AuctionID,
+		ClearingPrice: claims.ClearingPrice,
+	})
+	if errors.Is(err, ErrTrackingOverloaded) {
+		// pixels and redirects are not retried, the event is lost
+		s.log.Warnw("Tracking event is dropped",
+			"event_type", eventType,
+			"line_item_id", claims.LineItemID,
+			"error", err,
+		)
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("record ad interaction: %w", err)
+	}
+	return ok, nil
+}
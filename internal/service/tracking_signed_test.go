@@ -0,0 +1,136 @@
+package service
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"sweng-task/internal/model"
+	"sweng-task/internal/signing"
+
+	"go.uber.org/zap"
+)
+
+// trackingEventsRecorder records tracking events it is notified about
+type trackingEventsRecorder struct {
+	events []model.TrackingEvent
+}
+
+func (r *trackingEventsRecorder) OnTrackingEvent(event model.TrackingEvent) {
+	r.events = append(r.events, event)
+}
+
+func TestSignedTrackingService(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2025, 6, 2, 10, 0, 0, 0, time.UTC)}
+	log := zap.NewNop().Sugar()
+	signer, err := signing.NewSigner("k1", []signing.Key{{ID: "k1", Secret: []byte("0123456789abcdef")}})
+	if err != nil {
+		t.Fatalf("Create signer: %v", err)
+	}
+
+	lineItems := NewLineItemService(NewMemoryLineItemRepository(), NewMemoryLineItemHistory(), clock, log)
+	recorder := &trackingEventsRecorder{}
+	tracking := NewTrackingService(10, nil, time.Second, log, WithTrackingEventListener(recorder))
+	s := NewSignedTrackingService(tracking, lineItems, signer, clock, log)
+
+	item, err := lineItems.Create(model.LineItemCreate{Name: "test", Bid: 2, Budget: 100, Placement: "header", LandingURL: "https://example.com/sale"}, model.ChangeMeta{})
+	if err != nil {
+		t.Fatalf("Create line item: %v", err)
+	}
+	sign := func(lineItemID string, expiresAt time.Time) string {
+		t.Helper()
+		token, err := signer.Sign(signing.Claims{
+			LineItemID:    lineItemID,
+			Placement:     "header",
+			AuctionID:     "auc_1",
+			ClearingPrice: 1.5,
+			UserID:        "user_1",
+			ExpiresAt:     expiresAt,
+		})
+		if err != nil {
+			t.Fatalf("Sign: %v", err)
+		}
+		return token
+	}
+	token := sign(item.ID, clock.now.Add(time.Hour))
+
+	if ok, err := s.RecordImpression(token); !ok || err != nil {
+		t.Fatalf("Record impression: %v, %v", ok, err)
+	}
+	landingURL, ok, err := s.RecordClick(token)
+	if !ok || err != nil {
+		t.Fatalf("Record click: %v, %v", ok, err)
+	}
+	if landingURL != "https://example.com/sale" {
+		t.Errorf("Wrong landing URL: %q", landingURL)
+	}
+
+	want := model.TrackingEvent{
+		LineItemID:    item.ID,
+		Timestamp:     clock.now,
+		Placement:     "header",
+		UserID:        "user_1",
+		AuctionID:     "auc_1",
+		ClearingPrice: 1.5,
+	}
+	if len(recorder.events) != 2 {
+		t.Fatalf("Wrong amount of events: %d != 2", len(recorder.events))
+	}
+	for i, eventType := range []model.TrackingEventType{model.TrackingEventTypeImpression, model.TrackingEventTypeClick} {
+		want.EventType = eventType
+		got := recorder.events[i]
+		if got.EventType != want.EventType || got.LineItemID != want.LineItemID || !got.Timestamp.Equal(want.Timestamp) ||
+			got.Placement != want.Placement || got.UserID != want.UserID || got.AuctionID != want.AuctionID || got.ClearingPrice != want.ClearingPrice {
+			t.Errorf("Wrong event: %+v != %+v", got, want)
+		}
+	}
+
+	// serving records no events
+	served, err := s.Serve(token)
+	if err != nil || served.ID != item.ID {
+		t.Errorf("Serve: %+v, %v", served, err)
+	}
+	if _, err := s.Serve(token + "x"); !errors.Is(err, signing.ErrInvalidToken) {
+		t.Errorf("Wrong error for a tampered token: %v", err)
+	}
+
+	// rejected tokens are not recorded
+	if _, err := s.RecordImpression(token + "x"); !errors.Is(err, signing.ErrInvalidToken) {
+		t.Errorf("Wrong error for a tampered token: %v", err)
+	}
+	if _, _, err := s.RecordClick(sign(item.ID, clock.now)); !errors.Is(err, signing.ErrExpiredToken) {
+		t.Errorf("Wrong error for an expired token: %v", err)
+	}
+	if _, _, err := s.RecordClick(sign("li_unknown", clock.now.Add(time.Hour))); !errors.Is(err, ErrLineItemNotFound) {
+		t.Errorf("Wrong error for an unknown line item: %v", err)
+	}
+	if len(recorder.events) != 2 {
+		t.Errorf("Rejected events must not be recorded: %d != 2", len(recorder.events))
+	}
+}
+
+func TestLineItemService_LandingURLValidation(t *testing.T) {
+	s := NewLineItemService(NewMemoryLineItemRepository(), NewMemoryLineItemHistory(), SystemClock{}, zap.NewNop().Sugar())
+
+	tests := []struct {
+		landingURL string
+		wantErr    bool
+	}{
+		{"", false},
+		{"https://example.com/sale?utm_source=ads", false},
+		{"http://example.com", false},
+		{"example.com", true},
+		{"/sale", true},
+		{"javascript:alert(1)", true},
+		{"ftp://example.com", true},
+		{"https://", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.landingURL, func(t *testing.T) {
+			_, err := s.Create(model.LineItemCreate{Name: "test", Bid: 1, Budget: 100, Placement: "header", LandingURL: tt.landingURL}, model.ChangeMeta{})
+			if tt.wantErr != errors.Is(err, ErrInvalidLineItem) {
+				t.Errorf("Wrong error: %v", err)
+			}
+		})
+	}
+}
@@ -2,7 +2,12 @@ package service
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"slices"
 	"sweng-task/internal/model"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -58,11 +63,8 @@ func TestTrackingService_TrackingEventsWorker(t *testing.T) {
 
 	// buffer is full
 	ok, err := tService.RecordAdInteraction(model.TrackingEvent{})
-	if err != nil {
-		t.Errorf("Ad interaction doesn't not recorded: %v, %v", ok, err)
-	}
-	if ok {
-		t.Errorf("Buffer must be full")
+	if ok || !errors.Is(err, ErrTrackingOverloaded) {
+		t.Errorf("Buffer must be full: %v, %v", ok, err)
 	}
 
 	close(pause) // unpause storage writer
@@ -73,3 +75,413 @@ func TestTrackingService_TrackingEventsWorker(t *testing.T) {
 		t.Errorf("Wrong number of events: %d (persisted) != %d (sent)", eventsPersisted, bufferSize+chunkSize)
 	}
 }
+
+func TestValidateTrackingEvent(t *testing.T) {
+	tests := []struct {
+		name    string
+		event   model.TrackingEvent
+		wantErr bool
+	}{
+		{"impression", model.TrackingEvent{EventType: model.TrackingEventTypeImpression, LineItemID: "li_1", ClearingPrice: 1.5}, false},
+		{"conversion", model.TrackingEvent{EventType: model.TrackingEventTypeConversion, LineItemID: "li_1"}, false},
+		{"unknown type", model.TrackingEvent{EventType: "view", LineItemID: "li_1"}, true},
+		{"no type", model.TrackingEvent{LineItemID: "li_1"}, true},
+		{"no line item", model.TrackingEvent{EventType: model.TrackingEventTypeClick}, true},
+		{"negative price", model.TrackingEvent{EventType: model.TrackingEventTypeImpression, LineItemID: "li_1", ClearingPrice: -1}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateTrackingEvent(tt.event)
+			if tt.wantErr != errors.Is(err, ErrInvalidTrackingEvent) {
+				t.Errorf("Wrong error: %v", err)
+			}
+		})
+	}
+}
+
+func TestTrackingService_OverflowWait(t *testing.T) {
+	tracking := NewTrackingService(1, nil, time.Second, zap.NewNop().Sugar(), WithOverflowPolicy(OverflowWait, 20*time.Millisecond))
+	if err := tracking.ValidateOverflowPolicy(); err != nil {
+		t.Fatalf("Validate overflow policy: %v", err)
+	}
+
+	if _, err := tracking.RecordAdInteraction(model.TrackingEvent{}); err != nil {
+		t.Fatalf("Record ad interaction: %v", err)
+	}
+
+	// nobody reads the buffer, the event is rejected after the timeout
+	start := time.Now()
+	if _, err := tracking.RecordAdInteraction(model.TrackingEvent{}); !errors.Is(err, ErrTrackingOverloaded) {
+		t.Errorf("Event must be rejected: %v", err)
+	}
+	if waited := time.Since(start); waited < 20*time.Millisecond {
+		t.Errorf("Event is rejected without waiting: %v", waited)
+	}
+
+	// the buffer gets free space while the event waits
+	go func() {
+		time.Sleep(5 * time.Millisecond)
+		<-tracking.shards[0]
+	}()
+	tracking.overflowWait = time.Second
+	if _, err := tracking.RecordAdInteraction(model.TrackingEvent{}); err != nil {
+		t.Errorf("Event must wait for free space: %v", err)
+	}
+}
+
+type memorySpillQueue struct {
+	events []model.TrackingEvent
+	err    error
+}
+
+func (q *memorySpillQueue) Push(event model.TrackingEvent) error {
+	if q.err != nil {
+		return q.err
+	}
+	q.events = append(q.events, event)
+	return nil
+}
+
+func (q *memorySpillQueue) Replay(ctx context.Context, fn func(model.TrackingEvent) error) error {
+	for len(q.events) > 0 {
+		if err := fn(q.events[0]); err != nil {
+			return err
+		}
+		q.events = q.events[1:]
+	}
+	return nil
+}
+
+func TestTrackingService_RecordUnsignedAdInteraction(t *testing.T) {
+	recorder := &trackingEventsRecorder{}
+	tracking := NewTrackingService(2, nil, time.Second, zap.NewNop().Sugar(), WithTrackingEventListener(recorder))
+
+	// unsigned events are recorded, but don't charge budgets or count toward frequency caps
+	event := model.TrackingEvent{ID: "evt_1", EventType: model.TrackingEventTypeImpression, LineItemID: "li_1", UserID: "u_1", ClearingPrice: 1000}
+	if ok, err := tracking.RecordUnsignedAdInteraction(event); !ok || err != nil {
+		t.Fatalf("Record unsigned ad interaction: %v, %v", ok, err)
+	}
+	if len(tracking.shards[0]) != 1 {
+		t.Errorf("Unsigned event must be passed to the worker")
+	}
+	if len(recorder.events) != 0 {
+		t.Errorf("Listeners must not be notified about unsigned events: %+v", recorder.events)
+	}
+
+	// invalid events are not recorded
+	event.ClearingPrice = -1
+	if _, err := tracking.RecordUnsignedAdInteraction(event); !errors.Is(err, ErrInvalidTrackingEvent) {
+		t.Errorf("Wrong error: %v", err)
+	}
+	if len(tracking.shards[0]) != 1 {
+		t.Errorf("Invalid event must not be passed to the worker")
+	}
+
+	// signed events notify listeners
+	event.ID, event.ClearingPrice = "evt_2", 1
+	if _, err := tracking.RecordAdInteraction(event); err != nil {
+		t.Fatalf("Record ad interaction: %v", err)
+	}
+	if len(recorder.events) != 1 {
+		t.Errorf("Listeners must be notified about signed events: %d != 1", len(recorder.events))
+	}
+}
+
+func TestTrackingService_UnsignedDoesNotSuppressSigned(t *testing.T) {
+	dedup, err := NewBloomDeduplicator(&fakeClock{now: time.Date(2025, 6, 2, 10, 0, 0, 0, time.UTC)}, time.Minute, 1000, 0.001)
+	if err != nil {
+		t.Fatalf("Create deduplicator: %v", err)
+	}
+	recorder := &trackingEventsRecorder{}
+	tracking := NewTrackingService(10, nil, time.Second, zap.NewNop().Sugar(), WithEventDeduplicator(dedup), WithTrackingEventListener(recorder))
+
+	// an unsigned event with the public auction ID arrives before the pixel
+	event := model.TrackingEvent{EventType: model.TrackingEventTypeImpression, LineItemID: "li_1", AuctionID: "auc_1"}
+	if ok, err := tracking.RecordUnsignedAdInteraction(event); !ok || err != nil {
+		t.Fatalf("Record unsigned event: %v, %v", ok, err)
+	}
+	if ok, err := tracking.RecordAdInteraction(event); !ok || err != nil {
+		t.Fatalf("Record signed event: %v, %v", ok, err)
+	}
+	if len(recorder.events) != 1 {
+		t.Errorf("Signed event must be charged: %d listener calls", len(recorder.events))
+	}
+
+	// retries are still dropped within their own kind
+	_, _ = tracking.RecordAdInteraction(event)
+	_, _ = tracking.RecordUnsignedAdInteraction(event)
+	if len(recorder.events) != 1 || len(tracking.shards[0]) != 2 {
+		t.Errorf("Retries must be dropped: %d listener calls, %d queued", len(recorder.events), len(tracking.shards[0]))
+	}
+}
+
+func TestTrackingService_OverflowSpill(t *testing.T) {
+	spill := &memorySpillQueue{}
+	recorder := &trackingEventsRecorder{}
+	tracking := NewTrackingService(1, nil, time.Second, zap.NewNop().Sugar(),
+		WithOverflowPolicy(OverflowSpill, 0), WithSpillQueue(spill), WithTrackingEventListener(recorder))
+	if err := tracking.ValidateOverflowPolicy(); err != nil {
+		t.Fatalf("Validate overflow policy: %v", err)
+	}
+
+	for i := range 3 {
+		if _, err := tracking.RecordAdInteraction(model.TrackingEvent{ID: fmt.Sprintf("evt_%d", i)}); err != nil {
+			t.Fatalf("Record ad interaction: %v", err)
+		}
+	}
+	if len(spill.events) != 2 {
+		t.Fatalf("Wrong amount of spilled events: %d != 2", len(spill.events))
+	}
+	if len(recorder.events) != 3 {
+		t.Errorf("Listeners must be notified about spilled events: %d != 3", len(recorder.events))
+	}
+
+	// spilled events are passed to the worker once the buffer has space
+	ctx, stop := context.WithCancel(t.Context())
+	defer stop()
+	go tracking.ReplaySpilled(ctx, time.Millisecond)
+	for i := range 3 {
+		select {
+		case event := <-tracking.shards[0]:
+			if want := fmt.Sprintf("evt_%d", i); event.ID != want {
+				t.Errorf("Wrong event: %q != %q", event.ID, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Spilled event %d is not replayed", i)
+		}
+	}
+	if len(recorder.events) != 3 {
+		t.Errorf("Listeners must not be notified about replayed events: %d != 3", len(recorder.events))
+	}
+	stop()
+
+	// the event is rejected if it cannot be spilled
+	spill.err = errors.New("disk is full")
+	tracking.shards[0] <- queuedEvent{}
+	if _, err := tracking.RecordAdInteraction(model.TrackingEvent{}); !errors.Is(err, ErrTrackingOverloaded) {
+		t.Errorf("Event must be rejected: %v", err)
+	}
+}
+
+func TestTrackingService_ShardedWorkers(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		written = make(map[string][]model.TrackingEvent)
+		batches = make(map[string]map[string]struct{}) // line items of every batch
+	)
+	storage := TrackingEventsStorageFunc(func(_ context.Context, events []model.TrackingEvent) error {
+		mu.Lock()
+		defer mu.Unlock()
+		batch := fmt.Sprintf("batch_%d", len(batches))
+		batches[batch] = make(map[string]struct{})
+		for _, event := range events {
+			written[event.LineItemID] = append(written[event.LineItemID], event)
+			batches[batch][event.LineItemID] = struct{}{}
+		}
+		return nil
+	})
+	tracking := NewTrackingService(100, storage, time.Second, zap.NewNop().Sugar(), WithWorkers(4), WithOverflowPolicy(OverflowWait, time.Second))
+	if len(tracking.shards) != 4 || cap(tracking.shards[0]) != 25 {
+		t.Fatalf("Buffer must be split between shards: %d shards of %d", len(tracking.shards), cap(tracking.shards[0]))
+	}
+
+	ctx, stop := context.WithCancel(t.Context())
+	done := make(chan error)
+	go func() {
+		done <- tracking.TrackingEventsWorker(ctx, 10, time.Second)
+	}()
+	for i := range 1000 {
+		event := model.TrackingEvent{ID: fmt.Sprintf("evt_%d", i), LineItemID: fmt.Sprintf("li_%d", i%16)}
+		if _, err := tracking.RecordAdInteraction(event); err != nil {
+			t.Fatalf("Record event: %v", err)
+		}
+	}
+	// all shards are drained on shutdown
+	stop()
+	if err := <-done; err != nil {
+		t.Fatalf("Tracking events worker stopped with an error: %v", err)
+	}
+
+	var total int
+	for lineItemID, events := range written {
+		total += len(events)
+		for i := 1; i < len(events); i++ {
+			var prev, cur int
+			_, _ = fmt.Sscanf(events[i-1].ID, "evt_%d", &prev)
+			_, _ = fmt.Sscanf(events[i].ID, "evt_%d", &cur)
+			if cur < prev {
+				t.Errorf("Events of line item %q are out of order: %s after %s", lineItemID, events[i].ID, events[i-1].ID)
+			}
+		}
+	}
+	if total != 1000 {
+		t.Errorf("Wrong number of written events: %d != 1000", total)
+	}
+	distinct := make(map[chan queuedEvent]struct{})
+	for i := range 16 {
+		distinct[tracking.shard(model.TrackingEvent{LineItemID: fmt.Sprintf("li_%d", i)})] = struct{}{}
+	}
+	if len(distinct) < 2 {
+		t.Errorf("Line items must be spread between shards")
+	}
+}
+
+func TestTrackingService_StopAccepting(t *testing.T) {
+	var written atomic.Int64
+	storage := TrackingEventsStorageFunc(func(_ context.Context, events []model.TrackingEvent) error {
+		written.Add(int64(len(events)))
+		return nil
+	})
+	tracking := NewTrackingService(40, storage, time.Second, zap.NewNop().Sugar(), WithWorkers(4), WithOverflowPolicy(OverflowWait, time.Second))
+
+	// senders keep recording events during the shutdown
+	var (
+		wg       sync.WaitGroup
+		accepted atomic.Int64
+	)
+	for i := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; ; j++ {
+				_, err := tracking.RecordAdInteraction(model.TrackingEvent{LineItemID: fmt.Sprintf("li_%d_%d", i, j)})
+				if errors.Is(err, errTrackingStopped) {
+					return
+				}
+				if err != nil {
+					t.Errorf("Record event: %v", err)
+					return
+				}
+				accepted.Add(1)
+			}
+		}()
+	}
+
+	ctx, stop := context.WithCancel(t.Context())
+	done := make(chan error)
+	go func() {
+		done <- tracking.TrackingEventsWorker(ctx, 10, time.Second)
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	tracking.StopAccepting()
+	stop()
+	if err := <-done; err != nil {
+		t.Fatalf("Tracking events worker stopped with an error: %v", err)
+	}
+	wg.Wait()
+
+	// every accepted event is written
+	if written.Load() != accepted.Load() {
+		t.Errorf("Accepted events are lost: %d != %d", written.Load(), accepted.Load())
+	}
+	if _, err := tracking.RecordAdInteraction(model.TrackingEvent{}); !errors.Is(err, ErrTrackingOverloaded) {
+		t.Errorf("Events must be rejected after the shutdown: %v", err)
+	}
+}
+
+func TestTrackingService_ShutdownFlushesFullChunks(t *testing.T) {
+	var batches []int
+	storage := TrackingEventsStorageFunc(func(_ context.Context, events []model.TrackingEvent) error {
+		batches = append(batches, len(events))
+		return nil
+	})
+	tracking := NewTrackingService(25, storage, time.Second, zap.NewNop().Sugar())
+	for i := range 25 {
+		if _, err := tracking.RecordAdInteraction(model.TrackingEvent{ID: fmt.Sprintf("evt_%d", i)}); err != nil {
+			t.Fatalf("Record event: %v", err)
+		}
+	}
+
+	// the worker starts after the shutdown, waiting events are still batched
+	ctx, stop := context.WithCancel(t.Context())
+	stop()
+	if err := tracking.TrackingEventsWorker(ctx, 10, time.Hour); err != nil {
+		t.Fatalf("Tracking events worker stopped with an error: %v", err)
+	}
+	if !slices.Equal(batches, []int{10, 10, 5}) {
+		t.Errorf("Wrong batches: %v", batches)
+	}
+}
+
+func TestTrackingService_ShardedWorkers_Failure(t *testing.T) {
+	errUnavailable := errors.New("storage is unavailable")
+	var (
+		mu      sync.Mutex
+		written int
+	)
+	storage := TrackingEventsStorageFunc(func(_ context.Context, events []model.TrackingEvent) error {
+		if events[0].LineItemID == "li_broken" {
+			return errUnavailable
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		written += len(events)
+		return nil
+	})
+	tracking := NewTrackingService(100, storage, time.Second, zap.NewNop().Sugar(), WithWorkers(4))
+
+	// the rest of the workers flush their buffers and stop once a worker fails
+	var accepted int
+	for i := range 20 {
+		if tracking.shard(model.TrackingEvent{LineItemID: fmt.Sprintf("li_%d", i)}) == tracking.shard(model.TrackingEvent{LineItemID: "li_broken"}) {
+			continue
+		}
+		if _, err := tracking.RecordAdInteraction(model.TrackingEvent{LineItemID: fmt.Sprintf("li_%d", i)}); err != nil {
+			t.Fatalf("Record event: %v", err)
+		}
+		accepted++
+	}
+	if _, err := tracking.RecordAdInteraction(model.TrackingEvent{LineItemID: "li_broken"}); err != nil {
+		t.Fatalf("Record event: %v", err)
+	}
+	if err := tracking.TrackingEventsWorker(t.Context(), 1, time.Hour); !errors.Is(err, errUnavailable) {
+		t.Fatalf("Worker must fail: %v", err)
+	}
+	if written != accepted {
+		t.Errorf("Events of other shards must be flushed: %d != %d", written, accepted)
+	}
+}
+
+// BenchmarkTrackingService_Workers shows how throughput scales with workers once the storage is the bottleneck,
+// every write takes a millisecond. Besides ns/op it reports events/s.
+func BenchmarkTrackingService_Workers(b *testing.B) {
+	for _, workers := range []int{1, 2, 4, 8, 16} {
+		b.Run(fmt.Sprintf("workers/%d", workers), func(b *testing.B) {
+			var written atomic.Int64
+			storage := TrackingEventsStorageFunc(func(_ context.Context, events []model.TrackingEvent) error {
+				time.Sleep(time.Millisecond)
+				written.Add(int64(len(events)))
+				return nil
+			})
+			tracking := NewTrackingService(1000, storage, time.Second, zap.NewNop().Sugar(), WithWorkers(workers), WithOverflowPolicy(OverflowWait, time.Minute))
+			events := make([]model.TrackingEvent, 1024)
+			for i := range events {
+				events[i] = model.TrackingEvent{EventType: model.TrackingEventTypeImpression, LineItemID: fmt.Sprintf("li_%d", i)}
+			}
+
+			ctx, stop := context.WithCancel(b.Context())
+			done := make(chan error)
+			go func() {
+				done <- tracking.TrackingEventsWorker(ctx, 100, time.Millisecond)
+			}()
+
+			b.ResetTimer()
+			for i := range b.N {
+				if _, err := tracking.RecordAdInteraction(events[i%len(events)]); err != nil {
+					b.Fatalf("Record event: %v", err)
+				}
+			}
+			stop()
+			if err := <-done; err != nil {
+				b.Fatalf("Tracking events worker stopped with an error: %v", err)
+			}
+			b.StopTimer()
+
+			if written.Load() != int64(b.N) {
+				b.Fatalf("Wrong number of written events: %d != %d", written.Load(), b.N)
+			}
+			b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "events/s")
+		})
+	}
+}
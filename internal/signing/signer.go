@@ -0,0 +1,134 @@
+// Package signing signs facts about served ads into tokens embedded in tracking URLs,
+// so tracking requests coming back from clients can be trusted.
+package signing
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// minSecretLength is the minimal length of a key secret in bytes
+const minSecretLength = 16
+
+var (
+	// ErrInvalidToken is returned for malformed tokens and tokens with a wrong signature
+	ErrInvalidToken = errors.New("invalid token")
+	// ErrUnknownKey is returned for tokens signed by a key which is not configured (anymore)
+	ErrUnknownKey = errors.New("unknown signing key")
+	// ErrExpiredToken is returned for tokens used after their expiry
+	ErrExpiredToken = errors.New("token expired")
+)
+
+// Claims are the facts about a served ad signed into a token
+type Claims struct {
+	LineItemID    string    `json:"li"`
+	Placement     string    `json:"pl"`
+	AuctionID     string    `json:"au"`
+	ClearingPrice float64   `json:"cp"`
+	UserID        string    `json:"u,omitempty"`
+	ExpiresAt     time.Time `json:"-"`
+}
+
+// claims is the wire format of Claims, the expiry is kept in Unix seconds
+type claims struct {
+	Claims
+	Exp int64 `json:"exp"`
+}
+
+// Key is a secret used to sign and verify tokens, the ID is embedded in tokens,
+// so tokens signed by a previous key are verified after the signing key is rotated
+type Key struct {
+	ID     string
+	Secret []byte
+}
+
+// Signer signs tokens with the active key and verifies them with any of the configured keys.
+// A token is "<key id>.<base64url claims>.<base64url HMAC-SHA256>".
+type Signer struct {
+	active Key
+	keys   map[string][]byte
+}
+
+// NewSigner creates a new Signer, tokens are signed by the key 'activeKeyID'
+func NewSigner(activeKeyID string, keys []Key) (*Signer, error) {
+	s := &Signer{
+		keys: make(map[string][]byte, len(keys)),
+	}
+	for _, key := range keys {
+		switch {
+		case key.ID == "" || strings.Contains(key.ID, "."):
+			return nil, fmt.Errorf("key id %q must be non-empty and must not contain dots", key.ID)
+		case len(key.Secret) < minSecretLength:
+			return nil, fmt.Errorf("secret of key %q must be at least %d bytes long", key.ID, minSecretLength)
+		}
+		if _, ok := s.keys[key.ID]; ok {
+			return nil, fmt.Errorf("duplicate key %q", key.ID)
+		}
+		s.keys[key.ID] = key.Secret
+	}
+
+	secret, ok := s.keys[activeKeyID]
+	if !ok {
+		return nil, fmt.Errorf("active key %q is not configured", activeKeyID)
+	}
+	s.active = Key{ID: activeKeyID, Secret: secret}
+	return s, nil
+}
+
+// Sign returns a token carrying the claims
+func (s *Signer) Sign(c Claims) (string, error) {
+	payload, err := json.Marshal(claims{Claims: c, Exp: c.ExpiresAt.Unix()})
+	if err != nil {
+		return "", fmt.Errorf("marshal claims: %w", err)
+	}
+
+	signed := s.active.ID + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sign(s.active.Secret, signed)), nil
+}
+
+// Verify checks the signature and the expiry of a token and returns its claims
+func (s *Signer) Verify(token string, now time.Time) (Claims, error) {
+	keyID, rest, ok := strings.Cut(token, ".")
+	if !ok {
+		return Claims{}, ErrInvalidToken
+	}
+	encodedPayload, encodedSignature, ok := strings.Cut(rest, ".")
+	if !ok {
+		return Claims{}, ErrInvalidToken
+	}
+
+	secret, ok := s.keys[keyID]
+	if !ok {
+		return Claims{}, fmt.Errorf("%w: %q", ErrUnknownKey, keyID)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(encodedSignature)
+	if err != nil || !hmac.Equal(signature, sign(secret, keyID+"."+encodedPayload)) {
+		return Claims{}, ErrInvalidToken
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encodedPayload)
+	if err != nil {
+		return Claims{}, ErrInvalidToken
+	}
+	var c claims
+	if err := json.Unmarshal(payload, &c); err != nil {
+		return Claims{}, ErrInvalidToken
+	}
+	c.ExpiresAt = time.Unix(c.Exp, 0)
+	if !now.Before(c.ExpiresAt) {
+		return Claims{}, ErrExpiredToken
+	}
+	return c.Claims, nil
+}
+
+func sign(secret []byte, data string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
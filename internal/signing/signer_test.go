@@ -0,0 +1,122 @@
+package signing
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSigner_SignVerify(t *testing.T) {
+	now := time.Date(2025, 6, 2, 10, 0, 0, 0, time.UTC)
+	claims := Claims{
+		LineItemID:    "li_1",
+		Placement:     "header",
+		AuctionID:     "auc_1",
+		ClearingPrice: 1.25,
+		UserID:        "user_1",
+		ExpiresAt:     now.Add(time.Hour),
+	}
+
+	k1 := Key{ID: "k1", Secret: []byte("0123456789abcdef")}
+	k2 := Key{ID: "k2", Secret: []byte("fedcba9876543210")}
+	old, err := NewSigner("k1", []Key{k1})
+	if err != nil {
+		t.Fatalf("Create signer: %v", err)
+	}
+	rotated, err := NewSigner("k2", []Key{k2, k1})
+	if err != nil {
+		t.Fatalf("Create signer: %v", err)
+	}
+	retired, err := NewSigner("k2", []Key{k2})
+	if err != nil {
+		t.Fatalf("Create signer: %v", err)
+	}
+
+	token, err := old.Sign(claims)
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	got, err := old.Verify(token, now)
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if !got.ExpiresAt.Equal(claims.ExpiresAt) {
+		t.Errorf("Wrong expiry: %v != %v", got.ExpiresAt, claims.ExpiresAt)
+	}
+	got.ExpiresAt = claims.ExpiresAt
+	if got != claims {
+		t.Errorf("Wrong claims: %+v != %+v", got, claims)
+	}
+
+	// tokens of the previous key are verified after the rotation, until the key is removed
+	if _, err := rotated.Verify(token, now); err != nil {
+		t.Errorf("Token of a previous key must be verified: %v", err)
+	}
+	if _, err := retired.Verify(token, now); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("Wrong error for a removed key: %v", err)
+	}
+	newToken, err := rotated.Sign(claims)
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	if !strings.HasPrefix(newToken, "k2.") {
+		t.Errorf("Token must be signed by the active key: %s", newToken)
+	}
+	if _, err := old.Verify(newToken, now); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("Wrong error for an unknown key: %v", err)
+	}
+
+	if _, err := old.Verify(token, claims.ExpiresAt); !errors.Is(err, ErrExpiredToken) {
+		t.Errorf("Wrong error for an expired token: %v", err)
+	}
+
+	parts := strings.Split(token, ".")
+	forged, err := NewSigner("k1", []Key{{ID: "k1", Secret: []byte("not the real secret")}})
+	if err != nil {
+		t.Fatalf("Create signer: %v", err)
+	}
+	forgedToken, err := forged.Sign(Claims{LineItemID: "li_1", ClearingPrice: 0.01, ExpiresAt: claims.ExpiresAt})
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	for _, invalid := range []string{
+		"",
+		"k1",
+		"k1.payload",
+		parts[0] + "." + parts[1] + ".",
+		parts[0] + "." + parts[1] + "x." + parts[2],
+		parts[0] + "." + strings.Split(forgedToken, ".")[1] + "." + parts[2],
+		forgedToken,
+	} {
+		if _, err := old.Verify(invalid, now); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("Wrong error for invalid token %q: %v", invalid, err)
+		}
+	}
+}
+
+func TestNewSigner(t *testing.T) {
+	secret := []byte("0123456789abcdef")
+	tests := []struct {
+		name    string
+		active  string
+		keys    []Key
+		wantErr bool
+	}{
+		{"valid", "k1", []Key{{"k1", secret}, {"k2", secret}}, false},
+		{"unknown active key", "k3", []Key{{"k1", secret}}, true},
+		{"no keys", "k1", nil, true},
+		{"short secret", "k1", []Key{{"k1", secret[:8]}}, true},
+		{"empty id", "", []Key{{"", secret}}, true},
+		{"id with dot", "k.1", []Key{{"k.1", secret}}, true},
+		{"duplicate id", "k1", []Key{{"k1", secret}, {"k1", secret}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewSigner(tt.active, tt.keys)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Wrong error: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,95 @@
+package storage
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+
+	"sweng-task/internal/model"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var lineItemHistoryBucket = []byte("line_item_history")
+
+// BoltLineItemHistory stores line item changes in a bbolt database.
+// Every line item has a nested bucket with changes keyed by big-endian versions,
+// so the natural key order is the version order.
+type BoltLineItemHistory struct {
+	db *bolt.DB
+}
+
+// NewBoltLineItemHistory creates a new BoltLineItemHistory
+func NewBoltLineItemHistory(db *bolt.DB) (*BoltLineItemHistory, error) {
+	err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(lineItemHistoryBucket)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("create bucket: %w", err)
+	}
+
+	return &BoltLineItemHistory{
+		db: db,
+	}, nil
+}
+
+// Append appends a change, an already recorded version is never overwritten
+func (h *BoltLineItemHistory) Append(change model.LineItemChange) error {
+	return h.db.Update(func(tx *bolt.Tx) error {
+		return appendLineItemChange(tx, change)
+	})
+}
+
+// List returns up to 'limit' changes of a line item with versions greater than 'afterVersion'
+func (h *BoltLineItemHistory) List(lineItemID string, afterVersion int64, limit int) ([]model.LineItemChange, error) {
+	var changes []model.LineItemChange
+
+	err := h.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(lineItemHistoryBucket).Bucket([]byte(lineItemID))
+		if b == nil {
+			return nil
+		}
+
+		c := b.Cursor()
+		for k, v := c.Seek(versionKey(afterVersion + 1)); k != nil && len(changes) < limit; k, v = c.Next() {
+			var change model.LineItemChange
+			if err := json.Unmarshal(v, &change); err != nil {
+				return fmt.Errorf("decode change: %w", err)
+			}
+			changes = append(changes, change)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
+
+// appendLineItemChange appends a change within a transaction, so it can be stored together with the line item
+func appendLineItemChange(tx *bolt.Tx, change model.LineItemChange) error {
+	data, err := json.Marshal(change)
+	if err != nil {
+		return fmt.Errorf("encode change: %w", err)
+	}
+
+	history, err := tx.CreateBucketIfNotExists(lineItemHistoryBucket)
+	if err != nil {
+		return fmt.Errorf("create bucket: %w", err)
+	}
+	b, err := history.CreateBucketIfNotExists([]byte(change.LineItemID))
+	if err != nil {
+		return fmt.Errorf("create line item bucket: %w", err)
+	}
+
+	key := versionKey(change.Version)
+	if b.Get(key) != nil {
+		return fmt.Errorf("change of version %d is already recorded", change.Version)
+	}
+	return b.Put(key, data)
+}
+
+func versionKey(version int64) []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(version))
+}
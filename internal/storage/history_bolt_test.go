@@ -0,0 +1,54 @@
+package storage
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"sweng-task/internal/model"
+)
+
+func TestBoltLineItemHistory(t *testing.T) {
+	db, err := OpenBolt(filepath.Join(t.TempDir(), "lineitems.db"))
+	if err != nil {
+		t.Fatalf("Open db: %v", err)
+	}
+	defer db.Close()
+
+	history, err := NewBoltLineItemHistory(db)
+	if err != nil {
+		t.Fatalf("Open history: %v", err)
+	}
+
+	for version := int64(1); version <= 300; version++ {
+		err := history.Append(model.LineItemChange{
+			LineItemID: "li_1",
+			Version:    version,
+			Action:     model.LineItemChangeActionUpdate,
+			Timestamp:  time.Now(),
+			Changes:    []model.FieldChange{{Field: "bid", New: json.RawMessage("1")}},
+		})
+		if err != nil {
+			t.Fatalf("Append change: %v", err)
+		}
+	}
+
+	// versions must be ordered numerically, not lexically
+	changes, err := history.List("li_1", 255, 2)
+	if err != nil {
+		t.Fatalf("List changes: %v", err)
+	}
+	if len(changes) != 2 || changes[0].Version != 256 || changes[1].Version != 257 {
+		t.Errorf("Wrong changes page: %+v", changes)
+	}
+
+	if err := history.Append(model.LineItemChange{LineItemID: "li_1", Version: 1}); err == nil {
+		t.Errorf("Recorded change is overwritten")
+	}
+
+	changes, _ = history.List("li_unknown", 0, 10)
+	if len(changes) != 0 {
+		t.Errorf("Changes of unknown line item: %+v", changes)
+	}
+}
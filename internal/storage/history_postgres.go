@@ -0,0 +1,83 @@
+package storage
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+
+	"sweng-task/internal/model"
+)
+
+// PostgresLineItemHistory stores line item changes in PostgreSQL
+type PostgresLineItemHistory struct {
+	db *sql.DB
+}
+
+// NewPostgresLineItemHistory creates a new PostgresLineItemHistory.
+// The schema is expected to be migrated with MigratePostgres.
+func NewPostgresLineItemHistory(db *sql.DB) *PostgresLineItemHistory {
+	return &PostgresLineItemHistory{
+		db: db,
+	}
+}
+
+// Append appends a change, the primary key prevents overwriting of recorded versions
+func (h *PostgresLineItemHistory) Append(change model.LineItemChange) error {
+	return insertLineItemChange(h.db, change)
+}
+
+func insertLineItemChange(db execer, change model.LineItemChange) error {
+	changes, err := json.Marshal(change.Changes)
+	if err != nil {
+		return fmt.Errorf("encode changes: %w", err)
+	}
+
+	_, err = db.Exec("INSERT INTO line_item_history (line_item_id, version, action, actor, request_id, created_at, changes) VALUES ($1, $2, $3, $4, $5, $6, $7)",
+		change.LineItemID,
+		change.Version,
+		string(change.Action),
+		change.Actor,
+		change.RequestID,
+		change.Timestamp,
+		string(changes),
+	)
+	if err != nil {
+		return fmt.Errorf("insert line item change: %w", err)
+	}
+	return nil
+}
+
+// List returns up to 'limit' changes of a line item with versions greater than 'afterVersion'
+func (h *PostgresLineItemHistory) List(lineItemID string, afterVersion int64, limit int) ([]model.LineItemChange, error) {
+	rows, err := h.db.Query("SELECT line_item_id, version, action, actor, request_id, created_at, changes FROM line_item_history WHERE line_item_id = $1 AND version > $2 ORDER BY version LIMIT $3",
+		lineItemID,
+		afterVersion,
+		limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("select line item changes: %w", err)
+	}
+	defer rows.Close()
+
+	var result []model.LineItemChange
+	for rows.Next() {
+		var (
+			change  model.LineItemChange
+			action  string
+			changes []byte
+		)
+		err := rows.Scan(&change.LineItemID, &change.Version, &action, &change.Actor, &change.RequestID, &change.Timestamp, &changes)
+		if err != nil {
+			return nil, fmt.Errorf("scan line item change: %w", err)
+		}
+		change.Action = model.LineItemChangeAction(action)
+		if err := json.Unmarshal(changes, &change.Changes); err != nil {
+			return nil, fmt.Errorf("decode changes: %w", err)
+		}
+		result = append(result, change)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate line item changes: %w", err)
+	}
+	return result, nil
+}
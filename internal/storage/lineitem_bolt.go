@@ -0,0 +1,147 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"sweng-task/internal/model"
+	"sweng-task/internal/service"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var lineItemsBucket = []byte("line_items")
+
+// BoltLineItemRepository persists line items into an embedded bbolt database file.
+// All line items are additionally kept in memory, so reads never touch the disk.
+type BoltLineItemRepository struct {
+	db    *bolt.DB
+	cache *service.MemoryLineItemRepository
+}
+
+// OpenBolt opens (or creates) a bbolt database file
+func OpenBolt(path string) (*bolt.DB, error) {
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("open bolt db %q: %w", path, err)
+	}
+	return db, nil
+}
+
+// NewBoltLineItemRepository loads all stored line items from the database
+func NewBoltLineItemRepository(db *bolt.DB) (*BoltLineItemRepository, error) {
+	r := &BoltLineItemRepository{
+		db:    db,
+		cache: service.NewMemoryLineItemRepository(),
+	}
+
+	err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(lineItemsBucket)
+		if err != nil {
+			return fmt.Errorf("create bucket: %w", err)
+		}
+
+		var unversioned []*model.LineItem
+		err = b.ForEach(func(k, v []byte) error {
+			var item model.LineItem
+			if err := json.Unmarshal(v, &item); err != nil {
+				return fmt.Errorf("decode line item %q: %w", k, err)
+			}
+			// line items stored before versioning get the first version, the same as in the SQL migration,
+			// so their ETags are accepted in 'If-Match'
+			if item.Version == 0 {
+				item.Version = 1
+				unversioned = append(unversioned, &item)
+			}
+			return r.cache.Create(&item)
+		})
+		if err != nil {
+			return err
+		}
+
+		// the bucket cannot be modified while it is iterated
+		for _, item := range unversioned {
+			data, err := json.Marshal(item)
+			if err != nil {
+				return fmt.Errorf("encode line item: %w", err)
+			}
+			if err := b.Put([]byte(item.ID), data); err != nil {
+				return fmt.Errorf("migrate line item %q: %w", item.ID, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("load line items: %w", err)
+	}
+
+	return r, nil
+}
+
+// Create stores a new line item
+func (r *BoltLineItemRepository) Create(item *model.LineItem) error {
+	return r.CreateWithChange(item, model.LineItemChange{})
+}
+
+// CreateWithChange stores a new line item and its change in one transaction,
+// implements service.TransactionalLineItemRepository. An empty change is not recorded.
+func (r *BoltLineItemRepository) CreateWithChange(item *model.LineItem, change model.LineItemChange) error {
+	if err := r.put(item, change); err != nil {
+		return err
+	}
+	return r.cache.Create(item)
+}
+
+// Update replaces a stored line item
+func (r *BoltLineItemRepository) Update(item *model.LineItem) error {
+	return r.UpdateWithChange(item, model.LineItemChange{})
+}
+
+// UpdateWithChange replaces a stored line item and records its change in one transaction,
+// implements service.TransactionalLineItemRepository. An empty change is not recorded.
+func (r *BoltLineItemRepository) UpdateWithChange(item *model.LineItem, change model.LineItemChange) error {
+	if _, err := r.cache.GetByID(item.ID); err != nil {
+		return err
+	}
+	if err := r.put(item, change); err != nil {
+		return err
+	}
+	return r.cache.Update(item)
+}
+
+// GetByID retrieves a line item by ID
+func (r *BoltLineItemRepository) GetByID(id string) (*model.LineItem, error) {
+	return r.cache.GetByID(id)
+}
+
+// GetAll retrieves all line items, optionally filtered by advertiser ID and placement
+func (r *BoltLineItemRepository) GetAll(advertiserID, placement string) ([]*model.LineItem, error) {
+	return r.cache.GetAll(advertiserID, placement)
+}
+
+// FindMatchingLineItems finds active line items matching the targeting query
+func (r *BoltLineItemRepository) FindMatchingLineItems(query model.TargetingQuery) ([]*model.LineItem, error) {
+	return r.cache.FindMatchingLineItems(query)
+}
+
+func (r *BoltLineItemRepository) put(item *model.LineItem, change model.LineItemChange) error {
+	data, err := json.Marshal(item)
+	if err != nil {
+		return fmt.Errorf("encode line item: %w", err)
+	}
+
+	err = r.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(lineItemsBucket).Put([]byte(item.ID), data); err != nil {
+			return err
+		}
+		if change.LineItemID == "" {
+			return nil
+		}
+		return appendLineItemChange(tx, change)
+	})
+	if err != nil {
+		return fmt.Errorf("put line item: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package storage
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"sweng-task/internal/model"
+	"sweng-task/internal/service"
+)
+
+func TestBoltLineItemRepository_SurvivesReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "lineitems.db")
+
+	db, err := OpenBolt(path)
+	if err != nil {
+		t.Fatalf("Open db: %v", err)
+	}
+	repo, err := NewBoltLineItemRepository(db)
+	if err != nil {
+		t.Fatalf("Open repository: %v", err)
+	}
+
+	item := &model.LineItem{
+		ID:           "li_1",
+		Name:         "test_1",
+		AdvertiserID: "ad_1",
+		Bid:          2,
+		Budget:       1000,
+		Placement:    "header",
+		Categories:   []string{"toys"},
+		Keywords:     []string{"summer"},
+		Status:       model.LineItemStatusActive,
+		CreatedAt:    time.Now().UTC(),
+		UpdatedAt:    time.Now().UTC(),
+	}
+	if err := repo.Create(item); err != nil {
+		t.Fatalf("Create line item: %v", err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("Close db: %v", err)
+	}
+
+	db, err = OpenBolt(path)
+	if err != nil {
+		t.Fatalf("Reopen db: %v", err)
+	}
+	defer db.Close()
+	repo, err = NewBoltLineItemRepository(db)
+	if err != nil {
+		t.Fatalf("Reopen repository: %v", err)
+	}
+
+	got, err := repo.GetByID(item.ID)
+	if err != nil {
+		t.Fatalf("Get line item: %v", err)
+	}
+	if got.Name != item.Name || got.Bid != item.Bid || !got.CreatedAt.Equal(item.CreatedAt) {
+		t.Errorf("Wrong line item after reopen: %+v != %+v", got, item)
+	}
+
+	matching, err := repo.FindMatchingLineItems(model.TargetingQuery{Placement: "header", Categories: []string{"toys"}, Keywords: []string{"summer"}})
+	if err != nil {
+		t.Fatalf("Find matching line items: %v", err)
+	}
+	if len(matching) != 1 {
+		t.Errorf("Wrong amount of matching line items: %d != 1", len(matching))
+	}
+
+	_, err = repo.GetByID("li_unknown")
+	if !errors.Is(err, service.ErrLineItemNotFound) {
+		t.Errorf("Wrong error for unknown line item: %v", err)
+	}
+}
+
+func TestBoltLineItemRepository_MigratesUnversioned(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "lineitems.db")
+
+	db, err := OpenBolt(path)
+	if err != nil {
+		t.Fatalf("Open db: %v", err)
+	}
+	defer db.Close()
+	repo, err := NewBoltLineItemRepository(db)
+	if err != nil {
+		t.Fatalf("Open repository: %v", err)
+	}
+	// line items stored before versioning have no version
+	if err := repo.put(&model.LineItem{ID: "li_1", Name: "test_1", Status: model.LineItemStatusActive}, model.LineItemChange{}); err != nil {
+		t.Fatalf("Put line item: %v", err)
+	}
+
+	for range 2 {
+		repo, err = NewBoltLineItemRepository(db)
+		if err != nil {
+			t.Fatalf("Reopen repository: %v", err)
+		}
+		got, err := repo.GetByID("li_1")
+		if err != nil {
+			t.Fatalf("Get line item: %v", err)
+		}
+		if got.Version != 1 {
+			t.Errorf("Unversioned line item must get the first version: %d", got.Version)
+		}
+	}
+}
+
+func TestBoltLineItemRepository_WithChange(t *testing.T) {
+	db, err := OpenBolt(filepath.Join(t.TempDir(), "lineitems.db"))
+	if err != nil {
+		t.Fatalf("Open db: %v", err)
+	}
+	defer db.Close()
+	repo, err := NewBoltLineItemRepository(db)
+	if err != nil {
+		t.Fatalf("Open repository: %v", err)
+	}
+	history, err := NewBoltLineItemHistory(db)
+	if err != nil {
+		t.Fatalf("Open history: %v", err)
+	}
+
+	item := &model.LineItem{ID: "li_1", Name: "test_1", Status: model.LineItemStatusActive, Version: 1}
+	if err := repo.CreateWithChange(item, model.LineItemChange{LineItemID: "li_1", Version: 1, Action: model.LineItemChangeActionCreate}); err != nil {
+		t.Fatalf("Create line item: %v", err)
+	}
+	updated := *item
+	updated.Name = "test_2"
+	updated.Version = 2
+	if err := repo.UpdateWithChange(&updated, model.LineItemChange{LineItemID: "li_1", Version: 2, Action: model.LineItemChangeActionUpdate}); err != nil {
+		t.Fatalf("Update line item: %v", err)
+	}
+	changes, err := history.List("li_1", 0, 10)
+	if err != nil || len(changes) != 2 {
+		t.Fatalf("Changes must be recorded with the line item: %+v, %v", changes, err)
+	}
+
+	// the line item is not stored if its change cannot be recorded
+	conflicting := updated
+	conflicting.Name = "test_3"
+	if err := repo.UpdateWithChange(&conflicting, model.LineItemChange{LineItemID: "li_1", Version: 2}); err == nil {
+		t.Fatalf("Update must fail")
+	}
+	repo, err = NewBoltLineItemRepository(db)
+	if err != nil {
+		t.Fatalf("Reopen repository: %v", err)
+	}
+	if got, _ := repo.GetByID("li_1"); got.Name != "test_2" {
+		t.Errorf("Line item must not be updated without its change: %q", got.Name)
+	}
+}
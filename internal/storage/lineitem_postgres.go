@@ -0,0 +1,455 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"sweng-task/internal/model"
+	"sweng-task/internal/service"
+)
+
+const lineItemColumns = "id, name, advertiser_id, bid, budget, placement, categories, keywords, landing_url, start_at, end_at, schedule, pacing, frequency_caps, status, version, created_at, updated_at"
+
+// PostgresLineItemRepository stores line items in PostgreSQL.
+// Category and keyword filters are pushed down into the query and served by GIN indexes
+// over the 'categories' and 'keyword_terms' array columns (see migrations).
+// Keyword terms only preselect candidates, match types and negative keywords are checked by the service.
+type PostgresLineItemRepository struct {
+	db *sql.DB
+}
+
+// NewPostgresLineItemRepository creates a new PostgresLineItemRepository.
+// The schema is expected to be migrated with MigratePostgres.
+func NewPostgresLineItemRepository(db *sql.DB) *PostgresLineItemRepository {
+	return &PostgresLineItemRepository{
+		db: db,
+	}
+}
+
+// Create stores a new line item
+func (r *PostgresLineItemRepository) Create(item *model.LineItem) error {
+	return insertLineItem(r.db, item)
+}
+
+// CreateWithChange stores a new line item and its change in one transaction,
+// implements service.TransactionalLineItemRepository
+func (r *PostgresLineItemRepository) CreateWithChange(item *model.LineItem, change model.LineItemChange) error {
+	return r.inTx(func(tx *sql.Tx) error {
+		if err := insertLineItem(tx, item); err != nil {
+			return err
+		}
+		return insertLineItemChange(tx, change)
+	})
+}
+
+// Update replaces a stored line item.
+// The stored version must precede the version of the item, so concurrent writers
+// of other service instances cannot overwrite each other.
+func (r *PostgresLineItemRepository) Update(item *model.LineItem) error {
+	return r.update(r.db, item)
+}
+
+// UpdateWithChange replaces a stored line item and records its change in one transaction,
+// implements service.TransactionalLineItemRepository
+func (r *PostgresLineItemRepository) UpdateWithChange(item *model.LineItem, change model.LineItemChange) error {
+	return r.inTx(func(tx *sql.Tx) error {
+		if err := r.update(tx, item); err != nil {
+			return err
+		}
+		return insertLineItemChange(tx, change)
+	})
+}
+
+func (r *PostgresLineItemRepository) inTx(fn func(tx *sql.Tx) error) error {
+	return inTx(r.db, fn)
+}
+
+// inTx runs fn in a transaction, which is committed if fn succeeds
+func inTx(db *sql.DB, fn func(tx *sql.Tx) error) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	if err := fn(tx); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit transaction: %w", err)
+	}
+	return nil
+}
+
+// execer is either the database or a transaction
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+func insertLineItem(db execer, item *model.LineItem) error {
+	_, err := db.Exec("INSERT INTO line_items ("+lineItemColumns+", keyword_terms) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19)",
+		item.ID,
+		item.Name,
+		item.AdvertiserID,
+		item.Bid,
+		item.Budget,
+		item.Placement,
+		textArray(item.Categories),
+		textArray(item.Keywords),
+		item.LandingURL,
+		item.StartAt,
+		item.EndAt,
+		jsonValue{item.Schedule},
+		string(item.Pacing),
+		jsonValue{item.FrequencyCaps},
+		string(item.Status),
+		item.Version,
+		item.CreatedAt,
+		item.UpdatedAt,
+		textArray(service.KeywordIndexTerms(item)),
+	)
+	if err != nil {
+		return fmt.Errorf("insert line item: %w", err)
+	}
+	return nil
+}
+
+func (r *PostgresLineItemRepository) update(db execer, item *model.LineItem) error {
+	res, err := db.Exec("UPDATE line_items SET name = $2, advertiser_id = $3, bid = $4, budget = $5, placement = $6, categories = $7, keywords = $8, landing_url = $9, start_at = $10, end_at = $11, schedule = $12, pacing = $13, frequency_caps = $14, status = $15, version = $16, created_at = $17, updated_at = $18, keyword_terms = $19 WHERE id = $1 AND version = $16 - 1",
+		item.ID,
+		item.Name,
+		item.AdvertiserID,
+		item.Bid,
+		item.Budget,
+		item.Placement,
+		textArray(item.Categories),
+		textArray(item.Keywords),
+		item.LandingURL,
+		item.StartAt,
+		item.EndAt,
+		jsonValue{item.Schedule},
+		string(item.Pacing),
+		jsonValue{item.FrequencyCaps},
+		string(item.Status),
+		item.Version,
+		item.CreatedAt,
+		item.UpdatedAt,
+		textArray(service.KeywordIndexTerms(item)),
+	)
+	if err != nil {
+		return fmt.Errorf("update line item: %w", err)
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("update line item: %w", err)
+	}
+	if affected == 0 {
+		if _, err := r.GetByID(item.ID); err != nil {
+			return err
+		}
+		return service.ErrVersionMismatch
+	}
+	return nil
+}
+
+// GetByID retrieves a line item by ID
+func (r *PostgresLineItemRepository) GetByID(id string) (*model.LineItem, error) {
+	row := r.db.QueryRow("SELECT "+lineItemColumns+" FROM line_items WHERE id = $1", id)
+
+	item, err := scanLineItem(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, service.ErrLineItemNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("select line item: %w", err)
+	}
+	return item, nil
+}
+
+// GetAll retrieves all line items, optionally filtered by advertiser ID and placement
+func (r *PostgresLineItemRepository) GetAll(advertiserID, placement string) ([]*model.LineItem, error) {
+	var q query
+	if advertiserID != "" {
+		q.where("advertiser_id = ?", advertiserID)
+	}
+	if placement != "" {
+		q.where("placement = ?", placement)
+	}
+
+	return r.selectLineItems(q)
+}
+
+// FindMatchingLineItems finds active line items matching the targeting query,
+// a requested keyword is looked up by all its terms
+func (r *PostgresLineItemRepository) FindMatchingLineItems(targeting model.TargetingQuery) ([]*model.LineItem, error) {
+	// '@>' (contains) and '&&' (overlaps) are array operators supported by GIN indexes
+	operator := "&&"
+	if targeting.Match == model.MatchAll {
+		operator = "@>"
+	}
+
+	var q query
+	q.where("status = ?", string(model.LineItemStatusActive))
+	q.where("placement = ?", targeting.Placement)
+	if len(targeting.Categories) > 0 {
+		q.where("categories "+operator+" ?", textArray(targeting.Categories))
+	}
+	if len(targeting.Keywords) > 0 {
+		// a line item has a term of every requested keyword (all) or of any of them
+		var terms []string
+		for _, keyword := range targeting.Keywords {
+			if targeting.Match == model.MatchAll {
+				q.where("keyword_terms && ?", textArray(service.KeywordTerms(keyword)))
+				continue
+			}
+			terms = append(terms, service.KeywordTerms(keyword)...)
+		}
+		if targeting.Match != model.MatchAll {
+			q.where("keyword_terms && ?", textArray(terms))
+		}
+	}
+
+	return r.selectLineItems(q)
+}
+
+// BackfillKeywordTerms stores keyword terms of line items written before the terms were introduced,
+// until then keyword queries don't find them
+func (r *PostgresLineItemRepository) BackfillKeywordTerms(ctx context.Context) (int, error) {
+	rows, err := r.db.QueryContext(ctx, "SELECT id, keywords FROM line_items WHERE keyword_terms IS NULL")
+	if err != nil {
+		return 0, fmt.Errorf("select line items: %w", err)
+	}
+	var items []*model.LineItem
+	for rows.Next() {
+		var (
+			item     model.LineItem
+			keywords textArray
+		)
+		if err := rows.Scan(&item.ID, &keywords); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("scan line item: %w", err)
+		}
+		item.Keywords = keywords
+		items = append(items, &item)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("iterate line items: %w", err)
+	}
+
+	for _, item := range items {
+		_, err := r.db.ExecContext(ctx, "UPDATE line_items SET keyword_terms = $2 WHERE id = $1 AND keyword_terms IS NULL",
+			item.ID,
+			textArray(service.KeywordIndexTerms(item)),
+		)
+		if err != nil {
+			return 0, fmt.Errorf("update line item %q: %w", item.ID, err)
+		}
+	}
+	return len(items), nil
+}
+
+func (r *PostgresLineItemRepository) selectLineItems(q query) ([]*model.LineItem, error) {
+	rows, err := r.db.Query("SELECT "+lineItemColumns+" FROM line_items"+q.sql(), q.args...)
+	if err != nil {
+		return nil, fmt.Errorf("select line items: %w", err)
+	}
+	defer rows.Close()
+
+	var result []*model.LineItem
+	for rows.Next() {
+		item, err := scanLineItem(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan line item: %w", err)
+		}
+		result = append(result, item)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate line items: %w", err)
+	}
+	return result, nil
+}
+
+func scanLineItem(row interface{ Scan(...any) error }) (*model.LineItem, error) {
+	var (
+		item                 model.LineItem
+		categories, keywords textArray
+		startAt, endAt       sql.NullTime
+		pacing, status       string
+	)
+	err := row.Scan(
+		&item.ID,
+		&item.Name,
+		&item.AdvertiserID,
+		&item.Bid,
+		&item.Budget,
+		&item.Placement,
+		&categories,
+		&keywords,
+		&item.LandingURL,
+		&startAt,
+		&endAt,
+		jsonValue{&item.Schedule},
+		&pacing,
+		jsonValue{&item.FrequencyCaps},
+		&status,
+		&item.Version,
+		&item.CreatedAt,
+		&item.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	item.Categories = categories
+	item.Keywords = keywords
+	if startAt.Valid {
+		item.StartAt = &startAt.Time
+	}
+	if endAt.Valid {
+		item.EndAt = &endAt.Time
+	}
+	item.Pacing = model.PacingMode(pacing)
+	item.Status = model.LineItemStatus(status)
+	return &item, nil
+}
+
+// query builds a WHERE clause with numbered placeholders
+type query struct {
+	conditions []string
+	args       []any
+}
+
+// where adds a condition, '?' is replaced with the next placeholder
+func (q *query) where(condition string, arg any) {
+	q.args = append(q.args, arg)
+	q.conditions = append(q.conditions, strings.Replace(condition, "?", "$"+strconv.Itoa(len(q.args)), 1))
+}
+
+func (q *query) sql() string {
+	if len(q.conditions) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(q.conditions, " AND ")
+}
+
+// jsonValue maps a value to a nullable 'jsonb' column, nil values are stored as NULL
+type jsonValue struct {
+	v any
+}
+
+// Value implements driver.Valuer
+func (j jsonValue) Value() (driver.Value, error) {
+	if j.v == nil || reflect.ValueOf(j.v).IsNil() {
+		return nil, nil
+	}
+
+	data, err := json.Marshal(j.v)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+// Scan implements sql.Scanner, 'v' must be a pointer
+func (j jsonValue) Scan(src any) error {
+	switch src := src.(type) {
+	case nil:
+		return nil
+	case string:
+		return json.Unmarshal([]byte(src), j.v)
+	case []byte:
+		return json.Unmarshal(src, j.v)
+	default:
+		return fmt.Errorf("cannot scan %T into json", src)
+	}
+}
+
+// textArray maps []string to the PostgreSQL 'text[]' type using the array text representation,
+// so it doesn't depend on the array support of a particular driver
+type textArray []string
+
+// Value implements driver.Valuer
+func (a textArray) Value() (driver.Value, error) {
+	var sb strings.Builder
+	sb.WriteByte('{')
+	for i, s := range a {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteByte('"')
+		for _, r := range s {
+			if r == '"' || r == '\\' {
+				sb.WriteByte('\\')
+			}
+			sb.WriteRune(r)
+		}
+		sb.WriteByte('"')
+	}
+	sb.WriteByte('}')
+	return sb.String(), nil
+}
+
+// Scan implements sql.Scanner
+func (a *textArray) Scan(src any) error {
+	var s string
+	switch src := src.(type) {
+	case nil:
+		*a = nil
+		return nil
+	case string:
+		s = src
+	case []byte:
+		s = string(src)
+	default:
+		return fmt.Errorf("cannot scan %T into text array", src)
+	}
+
+	if len(s) < 2 || s[0] != '{' || s[len(s)-1] != '}' {
+		return fmt.Errorf("invalid text array: %q", s)
+	}
+	s = s[1 : len(s)-1]
+
+	result := textArray{}
+	for len(s) > 0 {
+		var elem strings.Builder
+		if s[0] == '"' {
+			i := 1
+			for ; i < len(s) && s[i] != '"'; i++ {
+				if s[i] == '\\' && i+1 < len(s) {
+					i++
+				}
+				elem.WriteByte(s[i])
+			}
+			if i >= len(s) {
+				return fmt.Errorf("unterminated quoted element in text array")
+			}
+			s = s[i+1:]
+		} else {
+			end := strings.IndexByte(s, ',')
+			if end < 0 {
+				end = len(s)
+			}
+			elem.WriteString(s[:end])
+			s = s[end:]
+		}
+		result = append(result, elem.String())
+
+		if len(s) > 0 {
+			if s[0] != ',' {
+				return fmt.Errorf("invalid text array delimiter: %q", s[0])
+			}
+			s = s[1:]
+		}
+	}
+
+	*a = result
+	return nil
+}
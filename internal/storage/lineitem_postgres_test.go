@@ -0,0 +1,252 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"sweng-task/internal/model"
+	"sweng-task/internal/service"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+var lineItemRowColumns = []string{"id", "name", "advertiser_id", "bid", "budget", "placement", "categories", "keywords", "landing_url", "start_at", "end_at", "schedule", "pacing", "frequency_caps", "status", "version", "created_at", "updated_at"}
+
+func TestPostgresLineItemRepository_FindMatchingLineItems(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Create sql mock: %v", err)
+	}
+	defer db.Close()
+
+	repo := NewPostgresLineItemRepository(db)
+	now := time.Now()
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT "+lineItemColumns+" FROM line_items WHERE status = $1 AND placement = $2 AND categories && $3 AND keyword_terms && $4")).
+		WithArgs("active", "header", `{"toys"}`, `{"summer","sale","run"}`).
+		WillReturnRows(sqlmock.NewRows(lineItemRowColumns).
+			AddRow("li_1", "test_1", "ad_1", 2.0, 1000.0, "header", `{toys,"kids, teens"}`, `{summer}`, "https://example.com/toys", nil, now, `{"timezone":"Europe/Berlin","hours":[9,10]}`, "even", `[{"impressions":3,"window_seconds":86400}]`, "active", int64(1), now, now))
+
+	items, err := repo.FindMatchingLineItems(model.TargetingQuery{Placement: "header", Categories: []string{"toys"}, Keywords: []string{"summer sale", "running"}})
+	if err != nil {
+		t.Fatalf("Find matching line items: %v", err)
+	}
+	if len(items) != 1 {
+		t.Fatalf("Wrong amount of line items: %d != 1", len(items))
+	}
+	if !slices.Equal(items[0].Categories, []string{"toys", "kids, teens"}) {
+		t.Errorf("Wrong categories: %q", items[0].Categories)
+	}
+	if items[0].LandingURL != "https://example.com/toys" {
+		t.Errorf("Wrong landing URL: %q", items[0].LandingURL)
+	}
+	if items[0].StartAt != nil || items[0].EndAt == nil || !items[0].EndAt.Equal(now) {
+		t.Errorf("Wrong flight dates: %v - %v", items[0].StartAt, items[0].EndAt)
+	}
+	if items[0].Schedule == nil || items[0].Schedule.Timezone != "Europe/Berlin" || !slices.Equal(items[0].Schedule.Hours, []int{9, 10}) {
+		t.Errorf("Wrong schedule: %+v", items[0].Schedule)
+	}
+	if items[0].Pacing != model.PacingModeEven {
+		t.Errorf("Wrong pacing: %q", items[0].Pacing)
+	}
+	if !slices.Equal(items[0].FrequencyCaps, []model.FrequencyCap{{Impressions: 3, WindowSeconds: 86400}}) {
+		t.Errorf("Wrong frequency caps: %+v", items[0].FrequencyCaps)
+	}
+	if items[0].Status != model.LineItemStatusActive {
+		t.Errorf("Wrong status: %q", items[0].Status)
+	}
+
+	// all-of semantics - containment of categories, a term of every keyword
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT "+lineItemColumns+" FROM line_items WHERE status = $1 AND placement = $2 AND categories @> $3 AND keyword_terms && $4 AND keyword_terms && $5")).
+		WithArgs("active", "header", `{"toys","games"}`, `{"summer"}`, `{"sale"}`).
+		WillReturnRows(sqlmock.NewRows(lineItemRowColumns))
+
+	_, err = repo.FindMatchingLineItems(model.TargetingQuery{Placement: "header", Categories: []string{"toys", "games"}, Keywords: []string{"summer", "sale"}, Match: model.MatchAll})
+	if err != nil {
+		t.Fatalf("Find matching line items: %v", err)
+	}
+
+	// no optional filters - no array conditions
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT "+lineItemColumns+" FROM line_items WHERE status = $1 AND placement = $2")).
+		WithArgs("active", "header").
+		WillReturnRows(sqlmock.NewRows(lineItemRowColumns))
+
+	items, err = repo.FindMatchingLineItems(model.TargetingQuery{Placement: "header"})
+	if err != nil {
+		t.Fatalf("Find matching line items: %v", err)
+	}
+	if len(items) != 0 {
+		t.Errorf("Wrong amount of line items: %d != 0", len(items))
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPostgresLineItemRepository_CreateAndGet(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Create sql mock: %v", err)
+	}
+	defer db.Close()
+
+	repo := NewPostgresLineItemRepository(db)
+	now := time.Now()
+	item := &model.LineItem{
+		ID:           "li_1",
+		Name:         "test_1",
+		AdvertiserID: "ad_1",
+		Bid:          2,
+		Budget:       1000,
+		Placement:    "header",
+		Categories:   []string{`say "hi"`},
+		Pacing:       model.PacingModeASAP,
+		Status:       model.LineItemStatusActive,
+		Version:      1,
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	}
+
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO line_items")).
+		WithArgs("li_1", "test_1", "ad_1", 2.0, 1000.0, "header", `{"say \"hi\""}`, `{}`, "", nil, nil, nil, "asap", nil, "active", int64(1), now, now, `{}`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	if err := repo.Create(item); err != nil {
+		t.Fatalf("Create line item: %v", err)
+	}
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT " + lineItemColumns + " FROM line_items WHERE id = $1")).
+		WithArgs("li_unknown").
+		WillReturnRows(sqlmock.NewRows(lineItemRowColumns))
+
+	_, err = repo.GetByID("li_unknown")
+	if !errors.Is(err, service.ErrLineItemNotFound) {
+		t.Errorf("Wrong error for unknown line item: %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMigratePostgres(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Create sql mock: %v", err)
+	}
+	defer db.Close()
+
+	names, err := fs.Glob(migrationsFS, "migrations/*.sql")
+	if err != nil || len(names) == 0 {
+		t.Fatalf("List migrations: %v", err)
+	}
+
+	expectMigrationsStart := func(applied ...string) {
+		rows := sqlmock.NewRows([]string{"version"})
+		for _, version := range applied {
+			rows.AddRow(version)
+		}
+
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta("SELECT pg_advisory_xact_lock($1)")).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec(regexp.QuoteMeta("CREATE TABLE IF NOT EXISTS schema_migrations")).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT version FROM schema_migrations")).WillReturnRows(rows)
+	}
+
+	// first run applies all migrations in order
+	expectMigrationsStart()
+	var versions []string
+	for _, name := range names {
+		version := strings.TrimSuffix(path.Base(name), ".sql")
+		versions = append(versions, version)
+
+		query, _ := migrationsFS.ReadFile(name)
+		mock.ExpectExec(regexp.QuoteMeta(string(query))).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO schema_migrations (version) VALUES ($1)")).
+			WithArgs(version).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+	mock.ExpectCommit()
+
+	if err := MigratePostgres(context.Background(), db); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+
+	// second run doesn't apply anything
+	expectMigrationsStart(versions...)
+	mock.ExpectCommit()
+
+	if err := MigratePostgres(context.Background(), db); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPostgresLineItemRepository_UpdateWithChange(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Create sql mock: %v", err)
+	}
+	defer db.Close()
+
+	repo := NewPostgresLineItemRepository(db)
+	now := time.Now()
+	item := &model.LineItem{ID: "li_1", Name: "test_1", Status: model.LineItemStatusActive, Version: 2, CreatedAt: now, UpdatedAt: now}
+	change := model.LineItemChange{LineItemID: "li_1", Version: 2, Action: model.LineItemChangeActionUpdate, Timestamp: now}
+
+	// the line item and its change are committed together
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE line_items")).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO line_item_history")).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	if err := repo.UpdateWithChange(item, change); err != nil {
+		t.Fatalf("Update line item: %v", err)
+	}
+
+	// the update is rolled back if the change cannot be recorded
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE line_items")).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO line_item_history")).WillReturnError(errors.New("duplicate key"))
+	mock.ExpectRollback()
+	if err := repo.UpdateWithChange(item, change); err == nil {
+		t.Errorf("Update must fail")
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPostgresLineItemRepository_BackfillKeywordTerms(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Create sql mock: %v", err)
+	}
+	defer db.Close()
+
+	repo := NewPostgresLineItemRepository(db)
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT id, keywords FROM line_items WHERE keyword_terms IS NULL")).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "keywords"}).AddRow("li_1", `{"running shoes","-free"}`))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE line_items SET keyword_terms = $2 WHERE id = $1 AND keyword_terms IS NULL")).
+		WithArgs("li_1", `{"run","shoe"}`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	n, err := repo.BackfillKeywordTerms(t.Context())
+	if err != nil || n != 1 {
+		t.Errorf("Backfill: %d, %v", n, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,8 @@
+//go:build !unix
+
+package storage
+
+// lockDir doesn't lock directories on this platform, only one process must write to a directory
+func lockDir(string) (func() error, error) {
+	return func() error { return nil }, nil
+}
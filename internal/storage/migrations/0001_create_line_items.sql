@@ -0,0 +1,21 @@
+CREATE TABLE line_items (
+    id            text PRIMARY KEY,
+    name          text NOT NULL,
+    advertiser_id text NOT NULL,
+    bid           double precision NOT NULL,
+    budget        double precision NOT NULL,
+    placement     text NOT NULL,
+    categories    text[] NOT NULL DEFAULT '{}',
+    keywords      text[] NOT NULL DEFAULT '{}',
+    status        text NOT NULL,
+    created_at    timestamptz NOT NULL,
+    updated_at    timestamptz NOT NULL
+);
+
+CREATE INDEX line_items_advertiser_id_idx ON line_items (advertiser_id);
+
+-- ad selection only looks at active line items of a single placement,
+-- the planner combines these indexes with a BitmapAnd
+CREATE INDEX line_items_active_placement_idx ON line_items (placement) WHERE status = 'active';
+CREATE INDEX line_items_active_categories_idx ON line_items USING GIN (categories) WHERE status = 'active';
+CREATE INDEX line_items_active_keywords_idx ON line_items USING GIN (keywords) WHERE status = 'active';
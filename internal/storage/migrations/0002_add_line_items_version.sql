@@ -0,0 +1 @@
+ALTER TABLE line_items ADD COLUMN version bigint NOT NULL DEFAULT 1;
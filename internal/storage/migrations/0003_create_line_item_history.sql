@@ -0,0 +1,10 @@
+CREATE TABLE line_item_history (
+    line_item_id text NOT NULL,
+    version      bigint NOT NULL,
+    action       text NOT NULL,
+    actor        text NOT NULL DEFAULT '',
+    request_id   text NOT NULL DEFAULT '',
+    created_at   timestamptz NOT NULL,
+    changes      jsonb NOT NULL,
+    PRIMARY KEY (line_item_id, version)
+);
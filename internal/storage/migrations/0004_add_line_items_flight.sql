@@ -0,0 +1,4 @@
+ALTER TABLE line_items
+    ADD COLUMN start_at timestamptz,
+    ADD COLUMN end_at   timestamptz,
+    ADD COLUMN schedule jsonb;
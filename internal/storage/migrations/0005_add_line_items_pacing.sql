@@ -0,0 +1,2 @@
+ALTER TABLE line_items
+    ADD COLUMN pacing text NOT NULL DEFAULT 'asap';
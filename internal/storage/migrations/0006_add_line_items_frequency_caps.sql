@@ -0,0 +1,2 @@
+ALTER TABLE line_items
+    ADD COLUMN frequency_caps jsonb;
@@ -0,0 +1,2 @@
+ALTER TABLE line_items
+    ADD COLUMN landing_url text NOT NULL DEFAULT '';
@@ -0,0 +1,7 @@
+-- keywords are matched by stems of their words, so the index over raw keywords is never used.
+-- Stems of positive keywords are stored separately, rows written before are backfilled by the service.
+ALTER TABLE line_items
+    ADD COLUMN keyword_terms text[];
+
+DROP INDEX line_items_active_keywords_idx;
+CREATE INDEX line_items_active_keyword_terms_idx ON line_items USING GIN (keyword_terms) WHERE status = 'active';
@@ -0,0 +1,7 @@
+-- the daily spend ledger of line items, so budgets are not reset by restarts
+CREATE TABLE line_item_spend (
+    day          date NOT NULL,
+    line_item_id text NOT NULL,
+    spend        double precision NOT NULL,
+    PRIMARY KEY (day, line_item_id)
+);
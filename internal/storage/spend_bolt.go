@@ -0,0 +1,96 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var lineItemSpendBucket = []byte("line_item_spend")
+
+// BoltSpendStore stores the daily spend of line items in a bbolt database.
+// Every day has a nested bucket with spends keyed by line item IDs.
+type BoltSpendStore struct {
+	db *bolt.DB
+}
+
+// NewBoltSpendStore creates a new BoltSpendStore
+func NewBoltSpendStore(db *bolt.DB) (*BoltSpendStore, error) {
+	err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(lineItemSpendBucket)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("create bucket: %w", err)
+	}
+
+	return &BoltSpendStore{
+		db: db,
+	}, nil
+}
+
+// LoadSpend returns the spend of line items on a day, implements service.SpendStore
+func (s *BoltSpendStore) LoadSpend(day string) (map[string]float64, error) {
+	spend := make(map[string]float64)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(lineItemSpendBucket).Bucket([]byte(day))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			spend[string(k)] = decodeSpend(v)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return spend, nil
+}
+
+// AddSpend adds amounts to the spend of line items on a day and removes spends of earlier days,
+// implements service.SpendStore
+func (s *BoltSpendStore) AddSpend(day string, amounts map[string]float64) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		spend := tx.Bucket(lineItemSpendBucket)
+
+		// days are dates, so the key order is the order of days
+		var earlier [][]byte
+		c := spend.Cursor()
+		for k, _ := c.First(); k != nil && bytes.Compare(k, []byte(day)) < 0; k, _ = c.Next() {
+			earlier = append(earlier, k)
+		}
+		for _, k := range earlier {
+			if err := spend.DeleteBucket(k); err != nil {
+				return fmt.Errorf("delete spend of %s: %w", k, err)
+			}
+		}
+
+		b, err := spend.CreateBucketIfNotExists([]byte(day))
+		if err != nil {
+			return fmt.Errorf("create day bucket: %w", err)
+		}
+		for id, amount := range amounts {
+			key := []byte(id)
+			if err := b.Put(key, encodeSpend(decodeSpend(b.Get(key))+amount)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func encodeSpend(spend float64) []byte {
+	return binary.BigEndian.AppendUint64(nil, math.Float64bits(spend))
+}
+
+// decodeSpend decodes a stored spend, a missing one is 0
+func decodeSpend(data []byte) float64 {
+	if len(data) != 8 {
+		return 0
+	}
+	return math.Float64frombits(binary.BigEndian.Uint64(data))
+}
@@ -0,0 +1,49 @@
+package storage
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestBoltSpendStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "lineitems.db")
+	db, err := OpenBolt(path)
+	if err != nil {
+		t.Fatalf("Open db: %v", err)
+	}
+	store, err := NewBoltSpendStore(db)
+	if err != nil {
+		t.Fatalf("Open spend store: %v", err)
+	}
+
+	if err := store.AddSpend("2025-06-01", map[string]float64{"li_1": 10}); err != nil {
+		t.Fatalf("Add spend: %v", err)
+	}
+	for range 2 {
+		if err := store.AddSpend("2025-06-02", map[string]float64{"li_1": 1.5, "li_2": 2}); err != nil {
+			t.Fatalf("Add spend: %v", err)
+		}
+	}
+
+	// the spend survives reopening, spends of earlier days are removed
+	db.Close()
+	db, err = OpenBolt(path)
+	if err != nil {
+		t.Fatalf("Open db: %v", err)
+	}
+	defer db.Close()
+	if store, err = NewBoltSpendStore(db); err != nil {
+		t.Fatalf("Open spend store: %v", err)
+	}
+
+	spend, err := store.LoadSpend("2025-06-02")
+	if err != nil {
+		t.Fatalf("Load spend: %v", err)
+	}
+	if len(spend) != 2 || spend["li_1"] != 3 || spend["li_2"] != 4 {
+		t.Errorf("Wrong spend: %v", spend)
+	}
+	if spend, err := store.LoadSpend("2025-06-01"); err != nil || len(spend) != 0 {
+		t.Errorf("Spend of an earlier day must be removed: %v, %v", spend, err)
+	}
+}
@@ -0,0 +1,66 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// PostgresSpendStore stores the daily spend of line items in PostgreSQL,
+// instances sharing the database share the spend
+type PostgresSpendStore struct {
+	db *sql.DB
+}
+
+// NewPostgresSpendStore creates a new PostgresSpendStore.
+// The schema is expected to be migrated with MigratePostgres.
+func NewPostgresSpendStore(db *sql.DB) *PostgresSpendStore {
+	return &PostgresSpendStore{
+		db: db,
+	}
+}
+
+// LoadSpend returns the spend of line items on a day, implements service.SpendStore
+func (s *PostgresSpendStore) LoadSpend(day string) (map[string]float64, error) {
+	rows, err := s.db.Query("SELECT line_item_id, spend FROM line_item_spend WHERE day = $1", day)
+	if err != nil {
+		return nil, fmt.Errorf("select spend: %w", err)
+	}
+	defer rows.Close()
+
+	spend := make(map[string]float64)
+	for rows.Next() {
+		var (
+			id     string
+			amount float64
+		)
+		if err := rows.Scan(&id, &amount); err != nil {
+			return nil, fmt.Errorf("scan spend: %w", err)
+		}
+		spend[id] = amount
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate spend: %w", err)
+	}
+	return spend, nil
+}
+
+// AddSpend adds amounts to the spend of line items on a day and removes spends of earlier days,
+// implements service.SpendStore
+func (s *PostgresSpendStore) AddSpend(day string, amounts map[string]float64) error {
+	return inTx(s.db, func(tx *sql.Tx) error {
+		if _, err := tx.Exec("DELETE FROM line_item_spend WHERE day < $1", day); err != nil {
+			return fmt.Errorf("delete spend of earlier days: %w", err)
+		}
+		for id, amount := range amounts {
+			_, err := tx.Exec("INSERT INTO line_item_spend (day, line_item_id, spend) VALUES ($1, $2, $3) ON CONFLICT (day, line_item_id) DO UPDATE SET spend = line_item_spend.spend + EXCLUDED.spend",
+				day,
+				id,
+				amount,
+			)
+			if err != nil {
+				return fmt.Errorf("add spend: %w", err)
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,158 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"sweng-task/internal/config"
+	"sweng-task/internal/model"
+	"sweng-task/internal/service"
+
+	"go.uber.org/zap"
+)
+
+// OpenTrackingEventsStorage opens the configured tracking events storage, the returned function closes it.
+// If several sinks are configured, events are fanned out to all of them.
+func OpenTrackingEventsStorage(ctx context.Context, cfg *config.Config, log *zap.SugaredLogger) (service.TrackingEventsStorage, func() error, error) {
+	if len(cfg.Tracking.Sinks) == 0 {
+		return openTrackingSink(ctx, cfg, config.TrackingSink{Type: cfg.Tracking.Sink}, log)
+	}
+
+	if err := validateFileSinkDirs(cfg.Tracking.Sinks); err != nil {
+		return nil, nil, err
+	}
+
+	var (
+		sinks  []service.FanOutSink
+		closes []func() error
+	)
+	closeAll := func() error {
+		var errs []error
+		for _, closeSink := range closes {
+			errs = append(errs, closeSink())
+		}
+		return errors.Join(errs...)
+	}
+	for _, sinkCfg := range cfg.Tracking.Sinks {
+		sink, closeSink, err := openTrackingSink(ctx, cfg, sinkCfg, log)
+		if err != nil {
+			closeAll()
+			return nil, nil, fmt.Errorf("sink %q: %w", sinkCfg.Name, err)
+		}
+		closes = append(closes, closeSink)
+
+		fanOutSink := service.FanOutSink{
+			Name:     sinkCfg.Name,
+			Storage:  sink,
+			Required: sinkCfg.Required,
+			Timeout:  sinkCfg.Timeout,
+		}
+		for _, eventType := range sinkCfg.EventTypes {
+			fanOutSink.EventTypes = append(fanOutSink.EventTypes, model.TrackingEventType(eventType))
+		}
+		sinks = append(sinks, fanOutSink)
+	}
+
+	fanOut, err := service.NewFanOutStorage(log, sinks...)
+	if err != nil {
+		closeAll()
+		return nil, nil, err
+	}
+	return fanOut, closeAll, nil
+}
+
+// validateFileSinkDirs rejects "file" sinks sharing a directory, the directory of the first one would lock them out.
+// If there are several of them, each must have its own directory configured.
+func validateFileSinkDirs(sinks config.TrackingSinks) error {
+	var fileSinks []config.TrackingSink
+	for _, sinkCfg := range sinks {
+		if sinkCfg.Type == "file" {
+			fileSinks = append(fileSinks, sinkCfg)
+		}
+	}
+	if len(fileSinks) < 2 {
+		return nil
+	}
+
+	dirs := make(map[string]string, len(fileSinks))
+	for _, sinkCfg := range fileSinks {
+		if sinkCfg.Dir == "" {
+			return fmt.Errorf("sink %q: dir must be set if there are several file sinks", sinkCfg.Name)
+		}
+		dir := filepath.Clean(sinkCfg.Dir)
+		if other, ok := dirs[dir]; ok {
+			return fmt.Errorf("sink %q: dir %q is used by sink %q", sinkCfg.Name, sinkCfg.Dir, other)
+		}
+		dirs[dir] = sinkCfg.Name
+	}
+	return nil
+}
+
+func openTrackingSink(ctx context.Context, cfg *config.Config, sinkCfg config.TrackingSink, log *zap.SugaredLogger) (service.TrackingEventsStorage, func() error, error) {
+	switch sinkCfg.Type {
+	case "discard":
+		discard := service.TrackingEventsStorageFunc(func(_ context.Context, _ []model.TrackingEvent) error { return nil })
+		return discard, func() error { return nil }, nil
+	case "file":
+		dir := sinkCfg.Dir
+		if dir == "" {
+			dir = TrackingEventsDir(cfg)
+		}
+		sink, err := NewFileTrackingEventsStorage(FileTrackingEventsConfig{
+			Dir:             dir,
+			Compression:     Compression(cfg.Tracking.FileCompression),
+			MaxSegmentBytes: cfg.Tracking.FileMaxSegmentBytes,
+			MaxSegmentAge:   cfg.Tracking.FileMaxSegmentAge,
+		}, service.SystemClock{}, log)
+		if err != nil {
+			return nil, nil, err
+		}
+		go sink.RotateLoop(ctx, min(cfg.Tracking.FileMaxSegmentAge, time.Minute))
+		return sink, sink.Close, nil
+	case "kafka":
+		topic := sinkCfg.Topic
+		if topic == "" {
+			topic = cfg.Tracking.KafkaTopic
+		}
+		sink, err := NewKafkaTrackingEventsStorage(KafkaTrackingEventsConfig{
+			Brokers:     cfg.Tracking.KafkaBrokers,
+			Topic:       topic,
+			ClientID:    cfg.Tracking.KafkaClientID,
+			Acks:        cfg.Tracking.KafkaAcks,
+			Compression: cfg.Tracking.KafkaCompression,
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		return sink, sink.Close, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown tracking sink %q, expected one of: file, kafka, discard", sinkCfg.Type)
+	}
+}
+
+// TrackingEventsDir returns the directory of the "file" sink
+func TrackingEventsDir(cfg *config.Config) string {
+	if cfg.Tracking.FileDir != "" {
+		return cfg.Tracking.FileDir
+	}
+	return filepath.Join(cfg.Storage.DataDir, "events")
+}
+
+// DeadLetterDir returns the directory of dead-lettered tracking events batches
+func DeadLetterDir(cfg *config.Config) string {
+	if cfg.Tracking.DeadLetterDir != "" {
+		return cfg.Tracking.DeadLetterDir
+	}
+	return filepath.Join(cfg.Storage.DataDir, "deadletter")
+}
+
+// EventLogDir returns the directory of the write-ahead log of tracking events
+func EventLogDir(cfg *config.Config) string {
+	if cfg.Tracking.WALDir != "" {
+		return cfg.Tracking.WALDir
+	}
+	return filepath.Join(cfg.Storage.DataDir, "wal")
+}
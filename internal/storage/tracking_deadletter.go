@@ -0,0 +1,146 @@
+package storage
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"sweng-task/internal/model"
+	"sweng-task/internal/service"
+)
+
+const (
+	deadLetterPrefix     = "batch-"
+	deadLetterSuffix     = ".ndjson"
+	deadLetterSinkPrefix = "sink-"
+)
+
+// FileDeadLetterStore keeps batches of tracking events which cannot be written to the sink,
+// every batch is an NDJSON file of a directory. Batches of a fan-out sink are kept in its subdirectory.
+type FileDeadLetterStore struct {
+	dir   string
+	clock service.Clock
+
+	mu  sync.Mutex
+	seq int
+}
+
+// NewFileDeadLetterStore opens a dead-letter directory
+func NewFileDeadLetterStore(dir string, clock service.Clock) (*FileDeadLetterStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create directory: %w", err)
+	}
+	return &FileDeadLetterStore{dir: dir, clock: clock}, nil
+}
+
+// Put writes a batch to a new file, implements service.DeadLetterStore
+func (s *FileDeadLetterStore) Put(_ context.Context, sink string, events []model.TrackingEvent) error {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	for _, event := range events {
+		if err := encoder.Encode(event); err != nil {
+			return fmt.Errorf("encode event: %w", err)
+		}
+	}
+
+	dir := s.dir
+	if sink != "" {
+		dir = filepath.Join(s.dir, deadLetterSinkPrefix+url.PathEscape(sink))
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return fmt.Errorf("create directory: %w", err)
+		}
+	}
+
+	s.mu.Lock()
+	name := fmt.Sprintf("%s%s-%06d%s", deadLetterPrefix, s.clock.Now().UTC().Format("20060102T150405.000000000Z"), s.seq, deadLetterSuffix)
+	s.seq++
+	s.mu.Unlock()
+
+	if err := writeFileAtomic(filepath.Join(dir, name), buf.Bytes()); err != nil {
+		return fmt.Errorf("write dead-letter batch: %w", err)
+	}
+	return nil
+}
+
+// Batches returns names of dead-lettered batches relative to the directory in the order they were written
+func (s *FileDeadLetterStore) Batches() ([]string, error) {
+	names, err := batchNames(s.dir, "")
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, fmt.Errorf("read directory: %w", err)
+	}
+	for _, entry := range entries {
+		if entry.IsDir() && strings.HasPrefix(entry.Name(), deadLetterSinkPrefix) {
+			sinkNames, err := batchNames(s.dir, entry.Name())
+			if err != nil {
+				return nil, err
+			}
+			names = append(names, sinkNames...)
+		}
+	}
+	slices.SortFunc(names, func(a, b string) int {
+		return cmp.Or(strings.Compare(filepath.Base(a), filepath.Base(b)), strings.Compare(a, b))
+	})
+	return names, nil
+}
+
+// batchNames returns names of batches of a subdirectory relative to the directory
+func batchNames(dir, subdir string) ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(dir, subdir))
+	if err != nil {
+		return nil, fmt.Errorf("read directory: %w", err)
+	}
+
+	var names []string
+	for _, entry := range entries {
+		if name := entry.Name(); strings.HasPrefix(name, deadLetterPrefix) && strings.HasSuffix(name, deadLetterSuffix) {
+			names = append(names, filepath.Join(subdir, name))
+		}
+	}
+	return names, nil
+}
+
+// Replay passes dead-lettered batches with the sinks they failed in to fn in the order they were written,
+// a batch is removed once fn returns no error for it. The replay stops at the first failed batch.
+func (s *FileDeadLetterStore) Replay(ctx context.Context, fn func(ctx context.Context, sink string, events []model.TrackingEvent) error) (int, error) {
+	names, err := s.Batches()
+	if err != nil {
+		return 0, err
+	}
+
+	for i, name := range names {
+		if err := ctx.Err(); err != nil {
+			return i, err
+		}
+
+		var sink string
+		if subdir := filepath.Dir(name); subdir != "." {
+			if sink, err = url.PathUnescape(strings.TrimPrefix(subdir, deadLetterSinkPrefix)); err != nil {
+				return i, fmt.Errorf("sink of batch %q: %w", name, err)
+			}
+		}
+		path := filepath.Join(s.dir, name)
+		events, err := ReadSegment(path)
+		if err != nil {
+			return i, fmt.Errorf("read batch %q: %w", name, err)
+		}
+		if err := fn(ctx, sink, events); err != nil {
+			return i, fmt.Errorf("replay batch %q: %w", name, err)
+		}
+		if err := os.Remove(path); err != nil {
+			return i, fmt.Errorf("remove batch %q: %w", name, err)
+		}
+	}
+	return len(names), nil
+}
@@ -0,0 +1,91 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"sweng-task/internal/model"
+)
+
+func TestFileDeadLetterStore(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2025, 6, 2, 10, 0, 0, 0, time.UTC)}
+	store, err := NewFileDeadLetterStore(t.TempDir(), clock)
+	if err != nil {
+		t.Fatalf("Create dead-letter store: %v", err)
+	}
+
+	for i := range 3 {
+		clock.now = clock.now.Add(time.Second)
+		if err := store.Put(t.Context(), "", trackingEvents(i*10, 2)); err != nil {
+			t.Fatalf("Put batch: %v", err)
+		}
+	}
+
+	// the replay stops at the failed batch
+	var replayed [][]model.TrackingEvent
+	errUnavailable := errors.New("storage is unavailable")
+	n, err := store.Replay(t.Context(), func(_ context.Context, _ string, events []model.TrackingEvent) error {
+		if len(replayed) == 1 {
+			return errUnavailable
+		}
+		replayed = append(replayed, events)
+		return nil
+	})
+	if n != 1 || !errors.Is(err, errUnavailable) {
+		t.Fatalf("Replay must stop at the failed batch: %d, %v", n, err)
+	}
+
+	n, err = store.Replay(t.Context(), func(_ context.Context, _ string, events []model.TrackingEvent) error {
+		replayed = append(replayed, events)
+		return nil
+	})
+	if n != 2 || err != nil {
+		t.Fatalf("Replay: %d, %v", n, err)
+	}
+
+	if len(replayed) != 3 {
+		t.Fatalf("Wrong amount of replayed batches: %d != 3", len(replayed))
+	}
+	for i, events := range replayed {
+		if len(events) != 2 || events[0].ID != trackingEvents(i*10, 1)[0].ID {
+			t.Errorf("Wrong batch %d: %+v", i, events)
+		}
+	}
+	if names, _ := store.Batches(); len(names) != 0 {
+		t.Errorf("Replayed batches must be removed: %v", names)
+	}
+}
+
+func TestFileDeadLetterStore_Sinks(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2025, 6, 2, 10, 0, 0, 0, time.UTC)}
+	store, err := NewFileDeadLetterStore(t.TempDir(), clock)
+	if err != nil {
+		t.Fatalf("Create dead-letter store: %v", err)
+	}
+
+	for i, sink := range []string{"attribution", "", "kafka/eu"} {
+		clock.now = clock.now.Add(time.Second)
+		if err := store.Put(t.Context(), sink, trackingEvents(i*10, 1)); err != nil {
+			t.Fatalf("Put batch: %v", err)
+		}
+	}
+
+	// batches are replayed to the sinks they failed in, in the order they were written
+	var sinks []string
+	n, err := store.Replay(t.Context(), func(_ context.Context, sink string, events []model.TrackingEvent) error {
+		if events[0].ID != trackingEvents(len(sinks)*10, 1)[0].ID {
+			t.Errorf("Wrong batch %d: %+v", len(sinks), events)
+		}
+		sinks = append(sinks, sink)
+		return nil
+	})
+	if n != 3 || err != nil {
+		t.Fatalf("Replay: %d, %v", n, err)
+	}
+	if !slices.Equal(sinks, []string{"attribution", "", "kafka/eu"}) {
+		t.Errorf("Wrong sinks: %q", sinks)
+	}
+}
@@ -0,0 +1,571 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"sweng-task/internal/model"
+	"sweng-task/internal/service"
+
+	"github.com/klauspost/compress/zstd"
+	"go.uber.org/zap"
+)
+
+// Compression of tracking events segment files
+type Compression string
+
+const (
+	CompressionNone Compression = "none"
+	CompressionGzip Compression = "gzip"
+	CompressionZstd Compression = "zstd"
+)
+
+const (
+	// ManifestFile lists complete segments of a tracking events directory
+	ManifestFile = "manifest.json"
+	// openSegmentSuffix marks the segment which is being written
+	openSegmentSuffix = ".open"
+	// segmentPrefix starts names of segment files
+	segmentPrefix = "events-"
+)
+
+// FileTrackingEventsConfig configures FileTrackingEventsStorage
+type FileTrackingEventsConfig struct {
+	Dir             string
+	Compression     Compression
+	MaxSegmentBytes int64         // a segment is completed once it reaches the size
+	MaxSegmentAge   time.Duration // a segment is completed once it is older, see RotateLoop
+}
+
+// Manifest lists complete segments in the order they were written
+type Manifest struct {
+	Segments []SegmentInfo `json:"segments"`
+}
+
+// SegmentInfo describes a complete segment, its file is never modified again
+type SegmentInfo struct {
+	Name        string      `json:"name"`
+	Events      int         `json:"events"`
+	Bytes       int64       `json:"bytes"`
+	Compression Compression `json:"compression"`
+	CreatedAt   time.Time   `json:"created_at"`
+	CompletedAt time.Time   `json:"completed_at"`
+}
+
+// FileTrackingEventsStorage appends tracking events as NDJSON to segment files of a directory,
+// the directory is locked, so only one process writes to it.
+// Every batch is synced to the disk before Write returns. Compressed batches are written
+// as separate gzip members or zstd frames, so a segment is readable up to the last synced batch.
+// The segment being written has the ".open" suffix, complete segments are renamed
+// and listed in the manifest, so downstream jobs read only complete segments.
+type FileTrackingEventsStorage struct {
+	cfg   FileTrackingEventsConfig
+	clock service.Clock
+
+	mu       sync.Mutex
+	current  *openSegment
+	manifest Manifest
+	seq      int // sequence number of the next segment
+	encoder  *zstd.Encoder
+	unlock   func() error
+
+	log *zap.SugaredLogger
+}
+
+type openSegment struct {
+	info SegmentInfo
+	file segmentFile
+}
+
+// segmentFile is the file of the open segment, *os.File in production
+type segmentFile interface {
+	io.WriteSeeker
+	Truncate(size int64) error
+	Sync() error
+	Close() error
+}
+
+// NewFileTrackingEventsStorage opens a tracking events directory.
+// Segments left open by a crash are completed: their events are recovered up to the last complete one.
+func NewFileTrackingEventsStorage(cfg FileTrackingEventsConfig, clock service.Clock, log *zap.SugaredLogger) (*FileTrackingEventsStorage, error) {
+	switch cfg.Compression {
+	case CompressionNone, CompressionGzip, CompressionZstd:
+	default:
+		return nil, fmt.Errorf("unknown compression %q, expected one of: none, gzip, zstd", cfg.Compression)
+	}
+	if cfg.MaxSegmentBytes <= 0 || cfg.MaxSegmentAge <= 0 {
+		return nil, fmt.Errorf("max segment size and age must be positive")
+	}
+	if err := os.MkdirAll(cfg.Dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create directory: %w", err)
+	}
+	unlock, err := lockDir(cfg.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &FileTrackingEventsStorage{
+		cfg:    cfg,
+		clock:  clock,
+		unlock: unlock,
+		log:    log,
+	}
+	if cfg.Compression == CompressionZstd {
+		encoder, err := zstd.NewWriter(nil)
+		if err != nil {
+			unlock()
+			return nil, fmt.Errorf("create zstd encoder: %w", err)
+		}
+		s.encoder = encoder
+	}
+
+	if err := s.recover(); err != nil {
+		unlock()
+		return nil, fmt.Errorf("recover segments: %w", err)
+	}
+	return s, nil
+}
+
+// Write appends events to the current segment and syncs it, implements service.TrackingEventsStorage
+func (s *FileTrackingEventsStorage) Write(_ context.Context, events []model.TrackingEvent) error {
+	data, err := s.encode(events, s.cfg.Compression)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.current != nil && s.clock.Now().Sub(s.current.info.CreatedAt) >= s.cfg.MaxSegmentAge {
+		if err := s.complete(); err != nil {
+			return err
+		}
+	}
+	if s.current == nil {
+		if err := s.open(); err != nil {
+			return err
+		}
+	}
+
+	segment := s.current
+	if _, err := segment.file.Write(data); err != nil {
+		s.rollback(segment)
+		return fmt.Errorf("write segment: %w", err)
+	}
+	if err := segment.file.Sync(); err != nil {
+		s.rollback(segment)
+		return fmt.Errorf("sync segment: %w", err)
+	}
+	segment.info.Events += len(events)
+	segment.info.Bytes += int64(len(data))
+
+	if segment.info.Bytes >= s.cfg.MaxSegmentBytes {
+		// the batch is durable already, so it must not be written again because of a failed rotation
+		if err := s.complete(); err != nil {
+			s.log.Errorw("Cannot complete segment", "segment", segment.info.Name, "error", err)
+		}
+	}
+	return nil
+}
+
+// rollback drops the partially written batch, so the segment stays readable and the batch can be written again.
+// The file offset is moved back too, otherwise the next batch leaves a hole of zeros in the segment.
+func (s *FileTrackingEventsStorage) rollback(segment *openSegment) {
+	if err := segment.file.Truncate(segment.info.Bytes); err != nil {
+		s.log.Errorw("Cannot truncate segment", "segment", segment.info.Name, "error", err)
+		return
+	}
+	if _, err := segment.file.Seek(segment.info.Bytes, io.SeekStart); err != nil {
+		s.log.Errorw("Cannot seek segment", "segment", segment.info.Name, "error", err)
+	}
+}
+
+// RotateLoop periodically completes the current segment once it reaches the max age,
+// so segments are completed even if no events arrive
+func (s *FileTrackingEventsStorage) RotateLoop(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.rotateExpired(); err != nil {
+				s.log.Errorw("Cannot rotate tracking events segment",
+					"error", err,
+				)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Close completes the current segment and releases the directory
+func (s *FileTrackingEventsStorage) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	defer s.unlock()
+	if s.encoder != nil {
+		defer s.encoder.Close()
+	}
+	if s.current == nil {
+		return nil
+	}
+	return s.complete()
+}
+
+// Manifest returns the list of complete segments
+func (s *FileTrackingEventsStorage) Manifest() Manifest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return Manifest{Segments: slices.Clone(s.manifest.Segments)}
+}
+
+func (s *FileTrackingEventsStorage) rotateExpired() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.current == nil || s.clock.Now().Sub(s.current.info.CreatedAt) < s.cfg.MaxSegmentAge {
+		return nil
+	}
+	return s.complete()
+}
+
+// encode encodes events as NDJSON compressed into a separate gzip member or zstd frame
+func (s *FileTrackingEventsStorage) encode(events []model.TrackingEvent, compression Compression) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	for _, event := range events {
+		if err := encoder.Encode(event); err != nil {
+			return nil, fmt.Errorf("encode event: %w", err)
+		}
+	}
+
+	switch compression {
+	case CompressionGzip:
+		var compressed bytes.Buffer
+		w := gzip.NewWriter(&compressed)
+		if _, err := w.Write(buf.Bytes()); err != nil {
+			return nil, fmt.Errorf("compress events: %w", err)
+		}
+		if err := w.Close(); err != nil {
+			return nil, fmt.Errorf("compress events: %w", err)
+		}
+		return compressed.Bytes(), nil
+	case CompressionZstd:
+		return s.encoder.EncodeAll(buf.Bytes(), nil), nil
+	default:
+		return buf.Bytes(), nil
+	}
+}
+
+// open creates a new segment, must be called under the lock
+func (s *FileTrackingEventsStorage) open() error {
+	now := s.clock.Now().UTC()
+	name := fmt.Sprintf("%s%s-%06d.ndjson%s", segmentPrefix, now.Format("20060102T150405.000000000Z"), s.seq, compressionExt(s.cfg.Compression))
+
+	file, err := os.OpenFile(filepath.Join(s.cfg.Dir, name+openSegmentSuffix), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("create segment: %w", err)
+	}
+	if err := syncDir(s.cfg.Dir); err != nil {
+		file.Close()
+		return err
+	}
+
+	s.seq++
+	s.current = &openSegment{
+		info: SegmentInfo{
+			Name:        name,
+			Compression: s.cfg.Compression,
+			CreatedAt:   now,
+		},
+		file: file,
+	}
+	return nil
+}
+
+// complete closes the current segment and adds it to the manifest, must be called under the lock.
+// Once the segment is closed, the next write starts a new one even if completing fails,
+// a segment which is left open or out of the manifest is recovered at the next start.
+func (s *FileTrackingEventsStorage) complete() error {
+	segment := s.current
+	if err := segment.file.Sync(); err != nil {
+		return fmt.Errorf("sync segment: %w", err)
+	}
+	s.current = nil
+	if err := segment.file.Close(); err != nil {
+		return fmt.Errorf("close segment: %w", err)
+	}
+
+	path := filepath.Join(s.cfg.Dir, segment.info.Name)
+	if segment.info.Events == 0 {
+		return os.Remove(path + openSegmentSuffix)
+	}
+	if err := os.Rename(path+openSegmentSuffix, path); err != nil {
+		return fmt.Errorf("rename segment: %w", err)
+	}
+
+	segment.info.CompletedAt = s.clock.Now().UTC()
+	return s.appendManifest(segment.info)
+}
+
+// appendManifest adds a complete segment to the manifest, the manifest is replaced atomically
+func (s *FileTrackingEventsStorage) appendManifest(info SegmentInfo) error {
+	manifest := Manifest{Segments: append(slices.Clone(s.manifest.Segments), info)}
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode manifest: %w", err)
+	}
+	if err := writeFileAtomic(filepath.Join(s.cfg.Dir, ManifestFile), data); err != nil {
+		return fmt.Errorf("write manifest: %w", err)
+	}
+	s.manifest = manifest
+	return nil
+}
+
+// recover loads the manifest and completes segments a crash left behind
+func (s *FileTrackingEventsStorage) recover() error {
+	data, err := os.ReadFile(filepath.Join(s.cfg.Dir, ManifestFile))
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		return fmt.Errorf("read manifest: %w", err)
+	default:
+		if err := json.Unmarshal(data, &s.manifest); err != nil {
+			return fmt.Errorf("decode manifest: %w", err)
+		}
+	}
+
+	entries, err := os.ReadDir(s.cfg.Dir)
+	if err != nil {
+		return fmt.Errorf("read directory: %w", err)
+	}
+	s.seq = len(entries)
+
+	// entries are sorted by names, so segments are recovered in the order they were written
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasPrefix(name, segmentPrefix) {
+			continue
+		}
+		if strings.HasSuffix(name, ".tmp") {
+			// a segment rewrite interrupted by a crash, the original segment is still there
+			if err := os.Remove(filepath.Join(s.cfg.Dir, name)); err != nil {
+				return fmt.Errorf("remove temporary file: %w", err)
+			}
+			continue
+		}
+		final := strings.TrimSuffix(name, openSegmentSuffix)
+		if slices.ContainsFunc(s.manifest.Segments, func(info SegmentInfo) bool { return info.Name == final }) {
+			continue
+		}
+
+		info, err := s.recoverSegment(name)
+		if err != nil {
+			return fmt.Errorf("recover segment %q: %w", name, err)
+		}
+		if info.Events == 0 {
+			if err := os.Remove(filepath.Join(s.cfg.Dir, name)); err != nil {
+				return fmt.Errorf("remove empty segment: %w", err)
+			}
+			continue
+		}
+		if name != final {
+			if err := os.Rename(filepath.Join(s.cfg.Dir, name), filepath.Join(s.cfg.Dir, final)); err != nil {
+				return fmt.Errorf("rename segment: %w", err)
+			}
+		}
+		if err := s.appendManifest(info); err != nil {
+			return err
+		}
+		s.log.Warnw("Recovered tracking events segment",
+			"segment", final,
+			"events", info.Events,
+		)
+	}
+	return syncDir(s.cfg.Dir)
+}
+
+// recoverSegment reads complete events of a segment, a torn tail is cut off by rewriting the segment
+func (s *FileTrackingEventsStorage) recoverSegment(name string) (SegmentInfo, error) {
+	path := filepath.Join(s.cfg.Dir, name)
+	final := strings.TrimSuffix(name, openSegmentSuffix)
+	compression := segmentCompression(final)
+
+	events, complete, err := readSegment(path, compression)
+	if err != nil {
+		return SegmentInfo{}, err
+	}
+
+	stat, err := os.Stat(path)
+	if err != nil {
+		return SegmentInfo{}, err
+	}
+	info := SegmentInfo{
+		Name:        final,
+		Events:      len(events),
+		Bytes:       stat.Size(),
+		Compression: compression,
+		CreatedAt:   stat.ModTime().UTC(),
+		CompletedAt: s.clock.Now().UTC(),
+	}
+	if complete || len(events) == 0 {
+		return info, nil
+	}
+
+	// rewrite the events which were read completely, the compression may differ from the configured one
+	if compression == CompressionZstd && s.encoder == nil {
+		encoder, err := zstd.NewWriter(nil)
+		if err != nil {
+			return SegmentInfo{}, fmt.Errorf("create zstd encoder: %w", err)
+		}
+		defer encoder.Close()
+		s.encoder = encoder
+		defer func() { s.encoder = nil }()
+	}
+	data, err := s.encode(events, compression)
+	if err != nil {
+		return SegmentInfo{}, err
+	}
+	if err := writeFileAtomic(path, data); err != nil {
+		return SegmentInfo{}, fmt.Errorf("rewrite segment: %w", err)
+	}
+	info.Bytes = int64(len(data))
+	return info, nil
+}
+
+// ReadSegment reads events of a segment file, the compression is detected by the file extension
+func ReadSegment(path string) ([]model.TrackingEvent, error) {
+	events, complete, err := readSegment(path, segmentCompression(strings.TrimSuffix(path, openSegmentSuffix)))
+	if err != nil {
+		return nil, err
+	}
+	if !complete {
+		return events, fmt.Errorf("segment %q is truncated", path)
+	}
+	return events, nil
+}
+
+// readSegment reads events of a segment up to the first incomplete one and reports if all of them were read
+func readSegment(path string, compression Compression) ([]model.TrackingEvent, bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, false, err
+	}
+	defer file.Close()
+
+	var r io.Reader = file
+	switch compression {
+	case CompressionGzip:
+		gz, err := gzip.NewReader(file)
+		if errors.Is(err, io.EOF) {
+			return nil, true, nil
+		}
+		if err != nil {
+			return nil, false, nil
+		}
+		r = gz
+	case CompressionZstd:
+		zr, err := zstd.NewReader(file)
+		if err != nil {
+			return nil, false, err
+		}
+		defer zr.Close()
+		r = zr
+	}
+
+	var events []model.TrackingEvent
+	reader := bufio.NewReader(r)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 && line[len(line)-1] == '\n' {
+			var event model.TrackingEvent
+			if err := json.Unmarshal(line, &event); err != nil {
+				return events, false, nil
+			}
+			events = append(events, event)
+		}
+		switch {
+		case errors.Is(err, io.EOF):
+			// a line without the trailing newline is torn
+			return events, len(line) == 0, nil
+		case err != nil:
+			return events, false, nil
+		}
+	}
+}
+
+func compressionExt(compression Compression) string {
+	switch compression {
+	case CompressionGzip:
+		return ".gz"
+	case CompressionZstd:
+		return ".zst"
+	default:
+		return ""
+	}
+}
+
+func segmentCompression(name string) Compression {
+	switch {
+	case strings.HasSuffix(name, ".gz"):
+		return CompressionGzip
+	case strings.HasSuffix(name, ".zst"):
+		return CompressionZstd
+	default:
+		return CompressionNone
+	}
+}
+
+// writeFileAtomic replaces a file with the data, so readers never see a partially written file
+func writeFileAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+// syncDir makes renames and new files of a directory durable
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("open directory: %w", err)
+	}
+	defer d.Close()
+
+	if err := d.Sync(); err != nil {
+		return fmt.Errorf("sync directory: %w", err)
+	}
+	return nil
+}
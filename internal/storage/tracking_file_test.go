@@ -0,0 +1,321 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"sweng-task/internal/model"
+
+	"go.uber.org/zap"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+func trackingEvents(from, n int) []model.TrackingEvent {
+	events := make([]model.TrackingEvent, n)
+	for i := range events {
+		events[i] = model.TrackingEvent{
+			ID:         fmt.Sprintf("evt_%d", from+i),
+			EventType:  model.TrackingEventTypeImpression,
+			LineItemID: "li_1",
+		}
+	}
+	return events
+}
+
+func readSegments(t *testing.T, dir string, manifest Manifest) []model.TrackingEvent {
+	t.Helper()
+	var events []model.TrackingEvent
+	for _, segment := range manifest.Segments {
+		segmentEvents, err := ReadSegment(filepath.Join(dir, segment.Name))
+		if err != nil {
+			t.Fatalf("Read segment: %v", err)
+		}
+		if len(segmentEvents) != segment.Events {
+			t.Errorf("Wrong amount of events of segment %q: %d != %d", segment.Name, len(segmentEvents), segment.Events)
+		}
+		events = append(events, segmentEvents...)
+	}
+	return events
+}
+
+func TestFileTrackingEventsStorage(t *testing.T) {
+	for _, compression := range []Compression{CompressionNone, CompressionGzip, CompressionZstd} {
+		t.Run(string(compression), func(t *testing.T) {
+			dir := t.TempDir()
+			clock := &fakeClock{now: time.Date(2025, 6, 2, 10, 0, 0, 0, time.UTC)}
+			cfg := FileTrackingEventsConfig{
+				Dir:             dir,
+				Compression:     compression,
+				MaxSegmentBytes: 1 << 20,
+				MaxSegmentAge:   time.Minute,
+			}
+			sink, err := NewFileTrackingEventsStorage(cfg, clock, zap.NewNop().Sugar())
+			if err != nil {
+				t.Fatalf("Create storage: %v", err)
+			}
+
+			for i := range 3 {
+				if err := sink.Write(t.Context(), trackingEvents(i*10, 10)); err != nil {
+					t.Fatalf("Write events: %v", err)
+				}
+			}
+			if len(sink.Manifest().Segments) != 0 {
+				t.Errorf("Open segment must not be listed")
+			}
+
+			// the segment is completed once it is too old
+			clock.now = clock.now.Add(time.Minute)
+			if err := sink.rotateExpired(); err != nil {
+				t.Fatalf("Rotate: %v", err)
+			}
+			if err := sink.Write(t.Context(), trackingEvents(30, 5)); err != nil {
+				t.Fatalf("Write events: %v", err)
+			}
+			if err := sink.Close(); err != nil {
+				t.Fatalf("Close storage: %v", err)
+			}
+
+			manifest := sink.Manifest()
+			if len(manifest.Segments) != 2 {
+				t.Fatalf("Wrong amount of segments: %d != 2", len(manifest.Segments))
+			}
+			events := readSegments(t, dir, manifest)
+			if len(events) != 35 {
+				t.Fatalf("Wrong amount of events: %d != 35", len(events))
+			}
+			for i, event := range events {
+				if event.ID != fmt.Sprintf("evt_%d", i) {
+					t.Errorf("Wrong event order: %q at %d", event.ID, i)
+				}
+			}
+
+			// the manifest is persisted
+			reopened, err := NewFileTrackingEventsStorage(cfg, clock, zap.NewNop().Sugar())
+			if err != nil {
+				t.Fatalf("Reopen storage: %v", err)
+			}
+			if len(reopened.Manifest().Segments) != 2 {
+				t.Errorf("Manifest is not persisted: %+v", reopened.Manifest())
+			}
+		})
+	}
+}
+
+func TestFileTrackingEventsStorage_RotateBySize(t *testing.T) {
+	dir := t.TempDir()
+	clock := &fakeClock{now: time.Date(2025, 6, 2, 10, 0, 0, 0, time.UTC)}
+	sink, err := NewFileTrackingEventsStorage(FileTrackingEventsConfig{
+		Dir:             dir,
+		Compression:     CompressionNone,
+		MaxSegmentBytes: 1,
+		MaxSegmentAge:   time.Hour,
+	}, clock, zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatalf("Create storage: %v", err)
+	}
+
+	for i := range 3 {
+		if err := sink.Write(t.Context(), trackingEvents(i, 1)); err != nil {
+			t.Fatalf("Write events: %v", err)
+		}
+	}
+	if got := len(sink.Manifest().Segments); got != 3 {
+		t.Errorf("Every batch must complete a segment: %d != 3", got)
+	}
+}
+
+// failingSegmentFile writes a part of the data and fails, like a full disk
+type failingSegmentFile struct {
+	segmentFile
+	fail bool
+}
+
+func (f *failingSegmentFile) Write(p []byte) (int, error) {
+	if !f.fail {
+		return f.segmentFile.Write(p)
+	}
+	f.fail = false
+	n, _ := f.segmentFile.Write(p[:len(p)/2])
+	return n, errors.New("no space left on device")
+}
+
+func TestFileTrackingEventsStorage_WriteFailure(t *testing.T) {
+	for _, compression := range []Compression{CompressionNone, CompressionGzip} {
+		t.Run(string(compression), func(t *testing.T) {
+			dir := t.TempDir()
+			sink, err := NewFileTrackingEventsStorage(FileTrackingEventsConfig{
+				Dir:             dir,
+				Compression:     compression,
+				MaxSegmentBytes: 1 << 20,
+				MaxSegmentAge:   time.Hour,
+			}, &fakeClock{now: time.Date(2025, 6, 2, 10, 0, 0, 0, time.UTC)}, zap.NewNop().Sugar())
+			if err != nil {
+				t.Fatalf("Create storage: %v", err)
+			}
+
+			if err := sink.Write(t.Context(), trackingEvents(0, 5)); err != nil {
+				t.Fatalf("Write events: %v", err)
+			}
+			file := &failingSegmentFile{segmentFile: sink.current.file, fail: true}
+			sink.current.file = file
+			if err := sink.Write(t.Context(), trackingEvents(5, 5)); err == nil {
+				t.Fatalf("Write must fail")
+			}
+
+			// the failed batch is written again right after the previous one
+			if err := sink.Write(t.Context(), trackingEvents(5, 5)); err != nil {
+				t.Fatalf("Write events: %v", err)
+			}
+			if err := sink.Close(); err != nil {
+				t.Fatalf("Close storage: %v", err)
+			}
+
+			manifest := sink.Manifest()
+			info, err := os.Stat(filepath.Join(dir, manifest.Segments[0].Name))
+			if err != nil {
+				t.Fatalf("Stat segment: %v", err)
+			}
+			if info.Size() != manifest.Segments[0].Bytes {
+				t.Errorf("Segment has a hole: %d != %d bytes", info.Size(), manifest.Segments[0].Bytes)
+			}
+			events := readSegments(t, dir, manifest)
+			if len(events) != 10 {
+				t.Fatalf("Wrong amount of events: %d != 10", len(events))
+			}
+			for i, event := range events {
+				if event.ID != fmt.Sprintf("evt_%d", i) {
+					t.Errorf("Wrong event order: %q at %d", event.ID, i)
+				}
+			}
+		})
+	}
+}
+
+// failingCloseFile fails to close, the file is closed anyway like *os.File does
+type failingCloseFile struct {
+	segmentFile
+}
+
+func (f failingCloseFile) Close() error {
+	_ = f.segmentFile.Close()
+	return errors.New("input/output error")
+}
+
+func TestFileTrackingEventsStorage_RotateFailure(t *testing.T) {
+	dir := t.TempDir()
+	cfg := FileTrackingEventsConfig{
+		Dir:             dir,
+		Compression:     CompressionNone,
+		MaxSegmentBytes: 1,
+		MaxSegmentAge:   time.Hour,
+	}
+	clock := &fakeClock{now: time.Date(2025, 6, 2, 10, 0, 0, 0, time.UTC)}
+	sink, err := NewFileTrackingEventsStorage(cfg, clock, zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatalf("Create storage: %v", err)
+	}
+
+	// the batch is synced before the rotation fails, so it is reported as written
+	if err := sink.Write(t.Context(), trackingEvents(0, 5)); err != nil {
+		t.Fatalf("Write events: %v", err)
+	}
+	// every batch completes a segment, the next one fails to close
+	if err := sink.open(); err != nil {
+		t.Fatalf("Open segment: %v", err)
+	}
+	sink.current.file = failingCloseFile{segmentFile: sink.current.file}
+	if err := sink.Write(t.Context(), trackingEvents(5, 5)); err != nil {
+		t.Fatalf("Durable batch must not fail: %v", err)
+	}
+
+	// later writes start a new segment
+	if err := sink.Write(t.Context(), trackingEvents(10, 5)); err != nil {
+		t.Fatalf("Write events: %v", err)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatalf("Close storage: %v", err)
+	}
+
+	// the segment which was not completed is recovered at the next start
+	reopened, err := NewFileTrackingEventsStorage(cfg, clock, zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatalf("Reopen storage: %v", err)
+	}
+	defer reopened.Close()
+	events := readSegments(t, dir, reopened.Manifest())
+	if len(events) != 15 {
+		t.Errorf("Wrong amount of events: %d != 15", len(events))
+	}
+}
+
+func TestFileTrackingEventsStorage_Recover(t *testing.T) {
+	for _, compression := range []Compression{CompressionNone, CompressionGzip, CompressionZstd} {
+		t.Run(string(compression), func(t *testing.T) {
+			dir := t.TempDir()
+			clock := &fakeClock{now: time.Date(2025, 6, 2, 10, 0, 0, 0, time.UTC)}
+			cfg := FileTrackingEventsConfig{
+				Dir:             dir,
+				Compression:     compression,
+				MaxSegmentBytes: 1 << 20,
+				MaxSegmentAge:   time.Minute,
+			}
+			sink, err := NewFileTrackingEventsStorage(cfg, clock, zap.NewNop().Sugar())
+			if err != nil {
+				t.Fatalf("Create storage: %v", err)
+			}
+			for i := range 2 {
+				if err := sink.Write(t.Context(), trackingEvents(i*10, 10)); err != nil {
+					t.Fatalf("Write events: %v", err)
+				}
+			}
+
+			// crash in the middle of a batch: the open segment has a torn tail
+			segment := sink.current
+			if _, err := segment.file.Write([]byte("{\"event_id\":\"torn")); err != nil {
+				t.Fatalf("Write torn batch: %v", err)
+			}
+			segment.file.Close()
+			sink.unlock()
+
+			recovered, err := NewFileTrackingEventsStorage(cfg, clock, zap.NewNop().Sugar())
+			if err != nil {
+				t.Fatalf("Recover storage: %v", err)
+			}
+			manifest := recovered.Manifest()
+			if len(manifest.Segments) != 1 {
+				t.Fatalf("Open segment must be completed: %+v", manifest)
+			}
+			if strings.HasSuffix(manifest.Segments[0].Name, openSegmentSuffix) {
+				t.Errorf("Completed segment must not be open: %q", manifest.Segments[0].Name)
+			}
+			if _, err := os.Stat(filepath.Join(dir, segment.info.Name+openSegmentSuffix)); !os.IsNotExist(err) {
+				t.Errorf("Open segment must be renamed: %v", err)
+			}
+			if events := readSegments(t, dir, manifest); len(events) != 20 {
+				t.Errorf("Wrong amount of recovered events: %d != 20", len(events))
+			}
+
+			// the recovered storage keeps writing new segments
+			if err := recovered.Write(context.Background(), trackingEvents(20, 1)); err != nil {
+				t.Fatalf("Write events: %v", err)
+			}
+			if err := recovered.Close(); err != nil {
+				t.Fatalf("Close storage: %v", err)
+			}
+			if got := len(recovered.Manifest().Segments); got != 2 {
+				t.Errorf("Wrong amount of segments: %d != 2", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,147 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"sweng-task/internal/model"
+	"sweng-task/internal/service"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// KafkaTrackingEventsConfig configures KafkaTrackingEventsStorage
+type KafkaTrackingEventsConfig struct {
+	Brokers     []string
+	Topic       string
+	ClientID    string
+	Acks        string // "all", "leader" or "none"
+	Compression string // "none", "gzip", "snappy", "lz4" or "zstd"
+	// MaxMessageBytes limits the size of a produced batch of records, 0 keeps the client default
+	MaxMessageBytes int32
+}
+
+// KafkaTrackingEventsStorage produces tracking events as JSON records to a Kafka topic.
+// Records are keyed by the line item ID, so events of a line item keep their order in one partition.
+// Write returns once delivery of every record is reported, failed records are returned
+// as service.PartialWriteError, so only they are retried.
+type KafkaTrackingEventsStorage struct {
+	client *kgo.Client
+	topic  string
+}
+
+// NewKafkaTrackingEventsStorage creates a producer of tracking events, brokers are connected lazily
+func NewKafkaTrackingEventsStorage(cfg KafkaTrackingEventsConfig) (*KafkaTrackingEventsStorage, error) {
+	if len(cfg.Brokers) == 0 || cfg.Topic == "" {
+		return nil, fmt.Errorf("brokers and topic must not be empty")
+	}
+
+	opts := []kgo.Opt{
+		kgo.SeedBrokers(cfg.Brokers...),
+		kgo.DefaultProduceTopic(cfg.Topic),
+		kgo.RecordPartitioner(kgo.StickyKeyPartitioner(nil)), // murmur2 of the key, the same as the Java client
+	}
+	if cfg.ClientID != "" {
+		opts = append(opts, kgo.ClientID(cfg.ClientID))
+	}
+	switch cfg.Acks {
+	case "all":
+		opts = append(opts, kgo.RequiredAcks(kgo.AllISRAcks()))
+	case "leader":
+		// idempotent writes require acks of all in-sync replicas
+		opts = append(opts, kgo.RequiredAcks(kgo.LeaderAck()), kgo.DisableIdempotentWrite())
+	case "none":
+		opts = append(opts, kgo.RequiredAcks(kgo.NoAck()), kgo.DisableIdempotentWrite())
+	default:
+		return nil, fmt.Errorf("unknown acks %q, expected one of: all, leader, none", cfg.Acks)
+	}
+	switch cfg.Compression {
+	case "none":
+		opts = append(opts, kgo.ProducerBatchCompression(kgo.NoCompression()))
+	case "gzip":
+		opts = append(opts, kgo.ProducerBatchCompression(kgo.GzipCompression()))
+	case "snappy":
+		opts = append(opts, kgo.ProducerBatchCompression(kgo.SnappyCompression()))
+	case "lz4":
+		opts = append(opts, kgo.ProducerBatchCompression(kgo.Lz4Compression()))
+	case "zstd":
+		opts = append(opts, kgo.ProducerBatchCompression(kgo.ZstdCompression()))
+	default:
+		return nil, fmt.Errorf("unknown compression %q, expected one of: none, gzip, snappy, lz4, zstd", cfg.Compression)
+	}
+	if cfg.MaxMessageBytes > 0 {
+		opts = append(opts, kgo.ProducerBatchMaxBytes(cfg.MaxMessageBytes))
+	}
+
+	client, err := kgo.NewClient(opts...)
+	if err != nil {
+		return nil, fmt.Errorf("create kafka client: %w", err)
+	}
+	return &KafkaTrackingEventsStorage{
+		client: client,
+		topic:  cfg.Topic,
+	}, nil
+}
+
+// Write produces events and waits for their delivery reports, implements service.TrackingEventsStorage
+func (s *KafkaTrackingEventsStorage) Write(ctx context.Context, events []model.TrackingEvent) error {
+	records := make([]*kgo.Record, len(events))
+	for i, event := range events {
+		value, err := json.Marshal(event)
+		if err != nil {
+			return fmt.Errorf("encode event: %w", err)
+		}
+		// the client sets the current time to records without a timestamp
+		records[i] = &kgo.Record{
+			Key:       []byte(event.LineItemID),
+			Value:     value,
+			Timestamp: event.Timestamp,
+		}
+	}
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		failed   = make([]bool, len(events))
+		firstErr error
+	)
+	wg.Add(len(records))
+	for i, record := range records {
+		s.client.Produce(ctx, record, func(_ *kgo.Record, err error) {
+			defer wg.Done()
+			if err == nil {
+				return
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			failed[i] = true
+			if firstErr == nil {
+				firstErr = err
+			}
+		})
+	}
+	wg.Wait()
+
+	if firstErr == nil {
+		return nil
+	}
+	partial := &service.PartialWriteError{Err: fmt.Errorf("produce to %q: %w", s.topic, firstErr)}
+	for i, event := range events {
+		if failed[i] {
+			partial.Failed = append(partial.Failed, event)
+			partial.Indexes = append(partial.Indexes, i)
+		}
+	}
+	if len(partial.Failed) == len(events) {
+		return partial.Err
+	}
+	return partial
+}
+
+// Close closes connections to the brokers, Write doesn't leave buffered records
+func (s *KafkaTrackingEventsStorage) Close() error {
+	s.client.Close()
+	return nil
+}
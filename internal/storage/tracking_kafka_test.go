@@ -0,0 +1,160 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"sweng-task/internal/model"
+	"sweng-task/internal/service"
+
+	"github.com/twmb/franz-go/pkg/kerr"
+	"github.com/twmb/franz-go/pkg/kfake"
+	"github.com/twmb/franz-go/pkg/kgo"
+	"github.com/twmb/franz-go/pkg/kmsg"
+)
+
+func newKafkaCluster(t *testing.T, topic string) *kfake.Cluster {
+	t.Helper()
+	cluster, err := kfake.NewCluster(kfake.NumBrokers(1), kfake.SeedTopics(3, topic))
+	if err != nil {
+		t.Fatalf("Create kafka cluster: %v", err)
+	}
+	t.Cleanup(cluster.Close)
+	return cluster
+}
+
+func consumeTrackingEvents(t *testing.T, brokers []string, topic string, n int) map[int32][]model.TrackingEvent {
+	t.Helper()
+	consumer, err := kgo.NewClient(kgo.SeedBrokers(brokers...), kgo.ConsumeTopics(topic), kgo.ConsumeResetOffset(kgo.NewOffset().AtStart()))
+	if err != nil {
+		t.Fatalf("Create consumer: %v", err)
+	}
+	defer consumer.Close()
+
+	ctx, stop := context.WithTimeout(t.Context(), 5*time.Second)
+	defer stop()
+
+	partitions := make(map[int32][]model.TrackingEvent)
+	for consumed := 0; consumed < n; {
+		fetches := consumer.PollFetches(ctx)
+		if err := ctx.Err(); err != nil {
+			t.Fatalf("Consumed %d of %d events: %v", consumed, n, err)
+		}
+		fetches.EachRecord(func(record *kgo.Record) {
+			var event model.TrackingEvent
+			if err := json.Unmarshal(record.Value, &event); err != nil {
+				t.Errorf("Decode event: %v", err)
+			}
+			if string(record.Key) != event.LineItemID {
+				t.Errorf("Record must be keyed by the line item: %q != %q", record.Key, event.LineItemID)
+			}
+			partitions[record.Partition] = append(partitions[record.Partition], event)
+			consumed++
+		})
+	}
+	return partitions
+}
+
+func TestKafkaTrackingEventsStorage(t *testing.T) {
+	cluster := newKafkaCluster(t, "tracking-events")
+	sink, err := NewKafkaTrackingEventsStorage(KafkaTrackingEventsConfig{
+		Brokers:     cluster.ListenAddrs(),
+		Topic:       "tracking-events",
+		Acks:        "all",
+		Compression: "zstd",
+	})
+	if err != nil {
+		t.Fatalf("Create storage: %v", err)
+	}
+	defer sink.Close()
+
+	var events []model.TrackingEvent
+	for i, lineItemID := range []string{"li_1", "li_2", "li_3", "li_4", "li_1", "li_2", "li_3", "li_4", "li_1"} {
+		events = append(events, model.TrackingEvent{
+			ID:         trackingEvents(i, 1)[0].ID,
+			EventType:  model.TrackingEventTypeImpression,
+			LineItemID: lineItemID,
+			Timestamp:  time.Date(2025, 6, 2, 10, 0, i, 0, time.UTC),
+		})
+	}
+	if err := sink.Write(t.Context(), events); err != nil {
+		t.Fatalf("Write events: %v", err)
+	}
+
+	// events of a line item are in one partition in the order they were written
+	lineItemPartitions := make(map[string]int32)
+	var consumed []model.TrackingEvent
+	for partition, partitionEvents := range consumeTrackingEvents(t, cluster.ListenAddrs(), "tracking-events", len(events)) {
+		for _, event := range partitionEvents {
+			if p, ok := lineItemPartitions[event.LineItemID]; ok && p != partition {
+				t.Errorf("Events of line item %q are in partitions %d and %d", event.LineItemID, p, partition)
+			}
+			lineItemPartitions[event.LineItemID] = partition
+		}
+		for i := 1; i < len(partitionEvents); i++ {
+			if partitionEvents[i].Timestamp.Before(partitionEvents[i-1].Timestamp) {
+				t.Errorf("Events of partition %d are out of order", partition)
+			}
+		}
+		consumed = append(consumed, partitionEvents...)
+	}
+	if len(consumed) != len(events) {
+		t.Errorf("Wrong amount of consumed events: %d != %d", len(consumed), len(events))
+	}
+}
+
+func TestKafkaTrackingEventsStorage_DeliveryErrors(t *testing.T) {
+	cluster := newKafkaCluster(t, "tracking-events")
+	sink, err := NewKafkaTrackingEventsStorage(KafkaTrackingEventsConfig{
+		Brokers:         cluster.ListenAddrs(),
+		Topic:           "tracking-events",
+		Acks:            "leader",
+		Compression:     "none",
+		MaxMessageBytes: 1024,
+	})
+	if err != nil {
+		t.Fatalf("Create storage: %v", err)
+	}
+	defer sink.Close()
+
+	// a record which is too large fails, the rest of the batch is delivered
+	events := trackingEvents(0, 3)
+	events[1].UserID = strings.Repeat("u", 2048)
+	err = sink.Write(t.Context(), events)
+	var partial *service.PartialWriteError
+	if !errors.As(err, &partial) {
+		t.Fatalf("Write must fail partially: %v", err)
+	}
+	if len(partial.Failed) != 1 || partial.Failed[0].ID != events[1].ID {
+		t.Errorf("Wrong failed events: %+v", partial.Failed)
+	}
+	if !errors.Is(err, kerr.MessageTooLarge) {
+		t.Errorf("Delivery error must be reported: %v", err)
+	}
+
+	// the broker rejects the whole batch
+	cluster.ControlKey(int16(kmsg.Produce), func(req kmsg.Request) (kmsg.Response, error, bool) {
+		produce := req.(*kmsg.ProduceRequest)
+		resp := produce.ResponseKind().(*kmsg.ProduceResponse)
+		for _, topic := range produce.Topics {
+			respTopic := kmsg.NewProduceResponseTopic()
+			respTopic.Topic = topic.Topic
+			for _, partition := range topic.Partitions {
+				respPartition := kmsg.NewProduceResponseTopicPartition()
+				respPartition.Partition = partition.Partition
+				respPartition.ErrorCode = kerr.InvalidRecord.Code
+				respTopic.Partitions = append(respTopic.Partitions, respPartition)
+			}
+			resp.Topics = append(resp.Topics, respTopic)
+		}
+		return resp, nil, true
+	})
+	err = sink.Write(t.Context(), trackingEvents(3, 2))
+	if err == nil || errors.As(err, &partial) {
+		t.Errorf("Write must fail completely: %v", err)
+	}
+}
@@ -0,0 +1,235 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"sweng-task/internal/model"
+)
+
+const (
+	spillPrefix = "spill-"
+	spillSuffix = ".ndjson"
+)
+
+// FileSpillQueue keeps tracking events which don't fit into the events buffer as NDJSON files of a directory.
+// Pushed events are appended to the current file, Replay starts a new one and replays the previous files,
+// a file is removed once all its events are replayed. Events survive restarts of the service,
+// an event whose replay was interrupted is replayed again.
+// Every push is synced before it returns, concurrent pushes share a sync.
+type FileSpillQueue struct {
+	dir string
+
+	mu      sync.Mutex
+	current *os.File
+	seq     int    // sequence number of the current file
+	pushed  uint64 // number of pushed events
+
+	syncMu sync.Mutex
+	synced uint64 // all events up to the number are synced
+
+	replayMu sync.Mutex
+}
+
+// NewFileSpillQueue opens a spill directory, events spilled before a restart are replayed first
+func NewFileSpillQueue(dir string) (*FileSpillQueue, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create directory: %w", err)
+	}
+	seqs, err := spillFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	q := &FileSpillQueue{dir: dir}
+	if len(seqs) > 0 {
+		q.seq = seqs[len(seqs)-1] + 1
+	}
+	return q, nil
+}
+
+// Push appends an event to the current file, implements service.SpillQueue
+func (q *FileSpillQueue) Push(event model.TrackingEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("encode event: %w", err)
+	}
+	data = append(data, '\n')
+
+	q.mu.Lock()
+	if q.current == nil {
+		file, err := os.OpenFile(q.path(q.seq), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+		if err != nil {
+			q.mu.Unlock()
+			return fmt.Errorf("create spill file: %w", err)
+		}
+		q.current = file
+	}
+	if _, err := q.current.Write(data); err != nil {
+		q.mu.Unlock()
+		return fmt.Errorf("write spill file: %w", err)
+	}
+	q.pushed++
+	pushed := q.pushed
+	q.mu.Unlock()
+
+	return q.sync(pushed)
+}
+
+// Replay passes spilled events to fn, implements service.SpillQueue.
+// Events pushed during the replay are kept for the next one.
+func (q *FileSpillQueue) Replay(ctx context.Context, fn func(model.TrackingEvent) error) error {
+	q.replayMu.Lock()
+	defer q.replayMu.Unlock()
+
+	if err := q.rotate(); err != nil {
+		return err
+	}
+
+	q.mu.Lock()
+	boundary := q.seq
+	q.mu.Unlock()
+
+	seqs, err := spillFiles(q.dir)
+	if err != nil {
+		return err
+	}
+	for _, seq := range seqs {
+		if seq >= boundary {
+			break
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := q.replayFile(q.path(seq), fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close syncs and closes the current file
+func (q *FileSpillQueue) Close() error {
+	return q.rotate()
+}
+
+// rotate completes the current file, the next pushed event starts a new one
+func (q *FileSpillQueue) rotate() error {
+	q.syncMu.Lock()
+	defer q.syncMu.Unlock()
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.current == nil {
+		return nil
+	}
+	file := q.current
+	q.current = nil
+	q.seq++
+
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return fmt.Errorf("sync spill file: %w", err)
+	}
+	q.synced = q.pushed
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("close spill file: %w", err)
+	}
+	return nil
+}
+
+// sync syncs the current file unless another push has synced the event already,
+// rotate takes syncMu as well, so the file is not closed during the sync
+func (q *FileSpillQueue) sync(pushed uint64) error {
+	q.syncMu.Lock()
+	defer q.syncMu.Unlock()
+
+	if q.synced >= pushed {
+		return nil
+	}
+
+	// events up to 'last' are written, previous files are synced on rotation
+	q.mu.Lock()
+	last := q.pushed
+	file := q.current
+	q.mu.Unlock()
+
+	if err := file.Sync(); err != nil {
+		return fmt.Errorf("sync spill file: %w", err)
+	}
+	q.synced = last
+	return nil
+}
+
+// replayFile passes events of a file to fn and removes the file,
+// if fn fails the file is replaced with the events which are not replayed yet
+func (q *FileSpillQueue) replayFile(path string, fn func(model.TrackingEvent) error) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read spill file: %w", err)
+	}
+
+	var lines [][]byte
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(nil, len(data)+1)
+	for scanner.Scan() {
+		if line := bytes.TrimSpace(scanner.Bytes()); len(line) > 0 {
+			lines = append(lines, line)
+		}
+	}
+
+	for i, line := range lines {
+		var event model.TrackingEvent
+		if err := json.Unmarshal(line, &event); err != nil {
+			// a torn line of a crash, the rest of the file cannot be trusted either
+			break
+		}
+		if err := fn(event); err != nil {
+			if err := writeFileAtomic(path, append(bytes.Join(lines[i:], []byte("\n")), '\n')); err != nil {
+				return fmt.Errorf("rewrite spill file: %w", err)
+			}
+			return fmt.Errorf("replay event: %w", err)
+		}
+	}
+
+	if err := os.Remove(path); err != nil {
+		return fmt.Errorf("remove spill file: %w", err)
+	}
+	return nil
+}
+
+func (q *FileSpillQueue) path(seq int) string {
+	return filepath.Join(q.dir, fmt.Sprintf("%s%012d%s", spillPrefix, seq, spillSuffix))
+}
+
+// spillFiles returns sorted sequence numbers of spill files of a directory
+func spillFiles(dir string) ([]int, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("read directory: %w", err)
+	}
+
+	var seqs []int
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasPrefix(name, spillPrefix) || !strings.HasSuffix(name, spillSuffix) {
+			continue
+		}
+		seq, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(name, spillPrefix), spillSuffix))
+		if err != nil {
+			continue
+		}
+		seqs = append(seqs, seq)
+	}
+	slices.Sort(seqs)
+	return seqs, nil
+}
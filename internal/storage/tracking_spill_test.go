@@ -0,0 +1,122 @@
+package storage
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	"sweng-task/internal/model"
+)
+
+func TestFileSpillQueue(t *testing.T) {
+	dir := t.TempDir()
+	queue, err := NewFileSpillQueue(dir)
+	if err != nil {
+		t.Fatalf("Create spill queue: %v", err)
+	}
+
+	for _, event := range trackingEvents(0, 5) {
+		if err := queue.Push(event); err != nil {
+			t.Fatalf("Push event: %v", err)
+		}
+	}
+
+	// the replay stops at the failed event, it is replayed again next time
+	var replayed []model.TrackingEvent
+	errFull := errors.New("buffer is full")
+	err = queue.Replay(t.Context(), func(event model.TrackingEvent) error {
+		if len(replayed) == 3 {
+			return errFull
+		}
+		replayed = append(replayed, event)
+		return nil
+	})
+	if !errors.Is(err, errFull) {
+		t.Fatalf("Replay must fail: %v", err)
+	}
+
+	// events survive a restart, the events pushed later are replayed after the earlier ones
+	if err := queue.Close(); err != nil {
+		t.Fatalf("Close spill queue: %v", err)
+	}
+	queue, err = NewFileSpillQueue(dir)
+	if err != nil {
+		t.Fatalf("Reopen spill queue: %v", err)
+	}
+	if err := queue.Push(trackingEvents(5, 1)[0]); err != nil {
+		t.Fatalf("Push event: %v", err)
+	}
+	err = queue.Replay(t.Context(), func(event model.TrackingEvent) error {
+		replayed = append(replayed, event)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Replay: %v", err)
+	}
+
+	if len(replayed) != 6 {
+		t.Fatalf("Wrong amount of replayed events: %d != 6", len(replayed))
+	}
+	for i, event := range replayed {
+		if want := trackingEvents(i, 1)[0].ID; event.ID != want {
+			t.Errorf("Wrong event order: %q != %q", event.ID, want)
+		}
+	}
+
+	// replayed events are removed
+	seqs, err := spillFiles(dir)
+	if err != nil {
+		t.Fatalf("List spill files: %v", err)
+	}
+	if len(seqs) != 0 {
+		t.Errorf("Replayed spill files must be removed: %v", seqs)
+	}
+}
+
+func TestFileSpillQueue_PushIsSynced(t *testing.T) {
+	queue, err := NewFileSpillQueue(t.TempDir())
+	if err != nil {
+		t.Fatalf("Create spill queue: %v", err)
+	}
+
+	// concurrent pushes share syncs, but all of them are synced once they return
+	push := func(events []model.TrackingEvent) {
+		var wg sync.WaitGroup
+		for _, event := range events {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if err := queue.Push(event); err != nil {
+					t.Errorf("Push event: %v", err)
+				}
+			}()
+		}
+		wg.Wait()
+
+		queue.syncMu.Lock()
+		defer queue.syncMu.Unlock()
+		if queue.synced != queue.pushed {
+			t.Errorf("Pushed events are not synced: %d != %d", queue.synced, queue.pushed)
+		}
+	}
+
+	push(trackingEvents(0, 25))
+	// a replay rotates the file, the next pushes sync the new one
+	errFull := errors.New("buffer is full")
+	if err := queue.Replay(t.Context(), func(model.TrackingEvent) error { return errFull }); !errors.Is(err, errFull) {
+		t.Fatalf("Replay must fail: %v", err)
+	}
+	push(trackingEvents(25, 25))
+
+	var replayed int
+	err = queue.Replay(t.Context(), func(model.TrackingEvent) error {
+		replayed++
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Replay: %v", err)
+	}
+	if replayed != 50 {
+		t.Errorf("Wrong amount of replayed events: %d != 50", replayed)
+	}
+}
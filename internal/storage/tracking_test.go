@@ -0,0 +1,66 @@
+package storage
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"sweng-task/internal/config"
+
+	"go.uber.org/zap"
+)
+
+func fileSinksConfig(dir string, sinks config.TrackingSinks) *config.Config {
+	cfg := &config.Config{}
+	cfg.Storage.DataDir = dir
+	cfg.Tracking.Sinks = sinks
+	cfg.Tracking.FileCompression = string(CompressionNone)
+	cfg.Tracking.FileMaxSegmentBytes = 1 << 20
+	cfg.Tracking.FileMaxSegmentAge = time.Minute
+	return cfg
+}
+
+func TestOpenTrackingEventsStorage_FileSinkDirs(t *testing.T) {
+	dir := t.TempDir()
+	for _, tc := range []struct {
+		name  string
+		sinks config.TrackingSinks
+		err   string
+	}{
+		{
+			name: "default dir",
+			sinks: config.TrackingSinks{
+				{Name: "durable", Type: "file", Required: true},
+				{Name: "archive", Type: "file", Dir: filepath.Join(dir, "archive")},
+			},
+			err: `sink "durable": dir must be set`,
+		},
+		{
+			name: "shared dir",
+			sinks: config.TrackingSinks{
+				{Name: "durable", Type: "file", Required: true, Dir: filepath.Join(dir, "events")},
+				{Name: "archive", Type: "file", Dir: filepath.Join(dir, "events") + "/"},
+			},
+			err: `sink "archive": dir`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := fileSinksConfig(dir, tc.sinks)
+			_, _, err := OpenTrackingEventsStorage(t.Context(), cfg, zap.NewNop().Sugar())
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("Wrong error: %v", err)
+			}
+		})
+	}
+
+	// a single file sink keeps the default dir
+	cfg := fileSinksConfig(dir, config.TrackingSinks{{Name: "durable", Type: "file", Required: true}})
+	_, closeSinks, err := OpenTrackingEventsStorage(t.Context(), cfg, zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatalf("Open storage: %v", err)
+	}
+	if err := closeSinks(); err != nil {
+		t.Errorf("Close storage: %v", err)
+	}
+}
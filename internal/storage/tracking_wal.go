@@ -0,0 +1,315 @@
+package storage
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"sweng-task/internal/model"
+	"sweng-task/internal/service"
+)
+
+const (
+	walPrefix      = "wal-"
+	walSuffix      = ".log"
+	walCheckpoint  = "checkpoint"
+	walOffsetWidth = 20
+)
+
+// walEntry is a line of a log segment
+type walEntry struct {
+	Offset uint64              `json:"o"`
+	Event  model.TrackingEvent `json:"e"`
+	Signed bool                `json:"s,omitempty"`
+}
+
+// FileEventLog is a write-ahead log of tracking events, it implements service.EventLog.
+// Events are appended as NDJSON to segment files named by the offset of their first event,
+// every append is synced before it returns, concurrent appends share a sync.
+// The checkpoint is the offset up to which all events are committed, it is persisted in a file,
+// segments with committed events only are removed.
+type FileEventLog struct {
+	dir          string
+	segmentBytes int64
+
+	mu          sync.Mutex
+	current     segmentFile
+	currentSize int64
+	segments    []uint64 // first offsets of segments, the last one is current
+	next        uint64   // offset of the next event
+	checkpoint  uint64   // all events up to the offset are committed
+	committed   map[uint64]struct{}
+	pending     []walEntry // events after the checkpoint found at startup
+
+	syncMu sync.Mutex
+	synced uint64 // all events up to the offset are synced
+}
+
+// NewFileEventLog opens a log directory, events after the checkpoint are kept for Pending
+func NewFileEventLog(dir string, segmentBytes int64) (*FileEventLog, error) {
+	if segmentBytes <= 0 {
+		return nil, fmt.Errorf("segment size must be positive")
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create directory: %w", err)
+	}
+
+	l := &FileEventLog{
+		dir:          dir,
+		segmentBytes: segmentBytes,
+		committed:    make(map[uint64]struct{}),
+	}
+	if err := l.load(); err != nil {
+		return nil, err
+	}
+	l.synced = l.next - 1
+	if err := l.rotate(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// Append appends an event and syncs it, implements service.EventLog
+func (l *FileEventLog) Append(event model.TrackingEvent, signed bool) (uint64, error) {
+	if err := l.rotateFull(); err != nil {
+		return 0, err
+	}
+
+	l.mu.Lock()
+	offset := l.next
+	data, err := json.Marshal(walEntry{Offset: offset, Event: event, Signed: signed})
+	if err != nil {
+		l.mu.Unlock()
+		return 0, fmt.Errorf("encode event: %w", err)
+	}
+	data = append(data, '\n')
+	if _, err := l.current.Write(data); err != nil {
+		// the torn line is skipped at startup, the next event starts a new segment
+		l.currentSize = l.segmentBytes
+		l.mu.Unlock()
+		return 0, fmt.Errorf("write log: %w", err)
+	}
+	l.currentSize += int64(len(data))
+	l.next++
+	l.mu.Unlock()
+
+	if err := l.sync(offset); err != nil {
+		// the event is rejected, so it is never committed by the service,
+		// its offset must not hold the checkpoint back
+		if err := l.Commit([]uint64{offset}); err != nil {
+			return 0, fmt.Errorf("commit rejected event: %w", err)
+		}
+		return 0, err
+	}
+	return offset, nil
+}
+
+// Commit marks events as written to the storage, implements service.EventLog.
+// The checkpoint advances up to the first event which isn't committed yet.
+func (l *FileEventLog) Commit(offsets []uint64) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, offset := range offsets {
+		if offset > l.checkpoint {
+			l.committed[offset] = struct{}{}
+		}
+	}
+	checkpoint := l.checkpoint
+	for {
+		if _, ok := l.committed[checkpoint+1]; !ok {
+			break
+		}
+		checkpoint++
+	}
+	if checkpoint == l.checkpoint {
+		return nil
+	}
+
+	// offsets are forgotten only once the checkpoint is persisted, a failed write is retried by the next commit
+	if err := writeFileAtomic(filepath.Join(l.dir, walCheckpoint), []byte(strconv.FormatUint(checkpoint, 10))); err != nil {
+		return fmt.Errorf("write checkpoint: %w", err)
+	}
+	for offset := l.checkpoint + 1; offset <= checkpoint; offset++ {
+		delete(l.committed, offset)
+	}
+	l.checkpoint = checkpoint
+
+	// a segment is removed once the next one starts after the checkpoint
+	for len(l.segments) > 1 && l.segments[1] <= checkpoint+1 {
+		if err := os.Remove(l.segmentPath(l.segments[0])); err != nil {
+			return fmt.Errorf("remove segment: %w", err)
+		}
+		l.segments = l.segments[1:]
+	}
+	return nil
+}
+
+// Pending returns events which were not committed before the restart, implements service.EventLog
+func (l *FileEventLog) Pending() []service.LoggedEvent {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	events := make([]service.LoggedEvent, len(l.pending))
+	for i, entry := range l.pending {
+		events[i] = service.LoggedEvent{Offset: entry.Offset, Event: entry.Event, Signed: entry.Signed}
+	}
+	l.pending = nil
+	return events
+}
+
+// Close syncs and closes the current segment
+func (l *FileEventLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err := l.current.Sync(); err != nil {
+		l.current.Close()
+		return fmt.Errorf("sync log: %w", err)
+	}
+	return l.current.Close()
+}
+
+// sync syncs the current segment unless another append has synced the event already
+func (l *FileEventLog) sync(offset uint64) error {
+	l.syncMu.Lock()
+	defer l.syncMu.Unlock()
+
+	if l.synced >= offset {
+		return nil
+	}
+
+	// events up to 'last' are written, previous segments are synced on rotation
+	l.mu.Lock()
+	last := l.next - 1
+	file := l.current
+	l.mu.Unlock()
+
+	if err := file.Sync(); err != nil {
+		return fmt.Errorf("sync log: %w", err)
+	}
+	l.synced = last
+	return nil
+}
+
+// rotateFull starts a new segment once the current one is full.
+// It takes syncMu as well, so the segment is not closed during a sync.
+func (l *FileEventLog) rotateFull() error {
+	l.syncMu.Lock()
+	defer l.syncMu.Unlock()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.currentSize < l.segmentBytes {
+		return nil
+	}
+	return l.rotate()
+}
+
+// rotate starts a new segment, must be called under both locks
+func (l *FileEventLog) rotate() error {
+	if l.current != nil {
+		if err := l.current.Sync(); err != nil {
+			return fmt.Errorf("sync log: %w", err)
+		}
+		l.synced = l.next - 1
+		if err := l.current.Close(); err != nil {
+			return fmt.Errorf("close log: %w", err)
+		}
+	}
+
+	file, err := os.OpenFile(l.segmentPath(l.next), os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("create segment: %w", err)
+	}
+	if err := syncDir(l.dir); err != nil {
+		file.Close()
+		return err
+	}
+	l.current = file
+	l.currentSize = 0
+	if len(l.segments) == 0 || l.segments[len(l.segments)-1] != l.next {
+		l.segments = append(l.segments, l.next)
+	}
+	return nil
+}
+
+// load reads the checkpoint and the events after it
+func (l *FileEventLog) load() error {
+	data, err := os.ReadFile(filepath.Join(l.dir, walCheckpoint))
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		return fmt.Errorf("read checkpoint: %w", err)
+	default:
+		l.checkpoint, err = strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+		if err != nil {
+			return fmt.Errorf("parse checkpoint: %w", err)
+		}
+	}
+	l.next = l.checkpoint + 1
+
+	entries, err := os.ReadDir(l.dir)
+	if err != nil {
+		return fmt.Errorf("read directory: %w", err)
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasPrefix(name, walPrefix) || !strings.HasSuffix(name, walSuffix) {
+			continue
+		}
+		first, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimPrefix(name, walPrefix), walSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		l.segments = append(l.segments, first)
+	}
+	slices.Sort(l.segments)
+
+	for _, first := range l.segments {
+		if err := l.loadSegment(first); err != nil {
+			return fmt.Errorf("read segment %d: %w", first, err)
+		}
+	}
+	return nil
+}
+
+// loadSegment collects events after the checkpoint, reading stops at a torn line
+func (l *FileEventLog) loadSegment(first uint64) error {
+	file, err := os.Open(l.segmentPath(first))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var entry walEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			return nil
+		}
+		if entry.Offset > l.checkpoint {
+			l.pending = append(l.pending, entry)
+		}
+		l.next = max(l.next, entry.Offset+1)
+	}
+}
+
+func (l *FileEventLog) segmentPath(first uint64) string {
+	return filepath.Join(l.dir, fmt.Sprintf("%s%0*d%s", walPrefix, walOffsetWidth, first, walSuffix))
+}
@@ -0,0 +1,162 @@
+package storage
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestFileEventLog(t *testing.T) {
+	dir := t.TempDir()
+	eventLog, err := NewFileEventLog(dir, 256)
+	if err != nil {
+		t.Fatalf("Create log: %v", err)
+	}
+
+	events := trackingEvents(0, 10)
+	offsets := make([]uint64, len(events))
+	for i, event := range events {
+		if offsets[i], err = eventLog.Append(event, i%2 == 0); err != nil {
+			t.Fatalf("Append event: %v", err)
+		}
+		if offsets[i] != uint64(i+1) {
+			t.Errorf("Wrong offset: %d != %d", offsets[i], i+1)
+		}
+	}
+	segments, _ := filepath.Glob(filepath.Join(dir, walPrefix+"*"))
+	if len(segments) < 2 {
+		t.Fatalf("Log must be rotated: %v", segments)
+	}
+
+	// the checkpoint doesn't pass the first event which isn't committed
+	if err := eventLog.Commit([]uint64{1, 2, 4, 5}); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+	if eventLog.checkpoint != 2 {
+		t.Errorf("Wrong checkpoint: %d != 2", eventLog.checkpoint)
+	}
+	if err := eventLog.Commit([]uint64{3, 6, 7, 8}); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+	if eventLog.checkpoint != 8 {
+		t.Errorf("Wrong checkpoint: %d != 8", eventLog.checkpoint)
+	}
+	left, _ := filepath.Glob(filepath.Join(dir, walPrefix+"*"))
+	if len(left) >= len(segments) {
+		t.Errorf("Committed segments must be removed: %d of %d are left", len(left), len(segments))
+	}
+
+	// events after the checkpoint are pending after a restart, a torn line is skipped
+	if err := eventLog.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	file, err := os.OpenFile(left[len(left)-1], os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatalf("Open segment: %v", err)
+	}
+	_, _ = file.WriteString(`{"o":11,"e":{"id":`)
+	file.Close()
+
+	eventLog, err = NewFileEventLog(dir, 256)
+	if err != nil {
+		t.Fatalf("Open log: %v", err)
+	}
+	defer eventLog.Close()
+	pending := eventLog.Pending()
+	if len(pending) != 2 || pending[0].Offset != 9 || pending[0].Event.ID != events[8].ID || !pending[0].Signed ||
+		pending[1].Offset != 10 || pending[1].Signed {
+		t.Errorf("Wrong pending events: %+v", pending)
+	}
+	if len(eventLog.Pending()) != 0 {
+		t.Errorf("Pending events must be returned once")
+	}
+	if offset, err := eventLog.Append(events[0], true); err != nil || offset != 11 {
+		t.Errorf("Offsets must continue after a restart: %d, %v", offset, err)
+	}
+}
+
+func TestFileEventLog_ConcurrentAppends(t *testing.T) {
+	// small segments rotate while other appends sync
+	eventLog, err := NewFileEventLog(t.TempDir(), 256)
+	if err != nil {
+		t.Fatalf("Create log: %v", err)
+	}
+	defer eventLog.Close()
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		offsets = make(map[uint64]struct{})
+	)
+	for _, event := range trackingEvents(0, 50) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			offset, err := eventLog.Append(event, true)
+			if err != nil {
+				t.Errorf("Append event: %v", err)
+				return
+			}
+			mu.Lock()
+			offsets[offset] = struct{}{}
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	all := make([]uint64, 0, len(offsets))
+	for offset := range offsets {
+		all = append(all, offset)
+	}
+	if len(all) != 50 {
+		t.Fatalf("Offsets must be unique: %d != 50", len(all))
+	}
+	if err := eventLog.Commit(all); err != nil || eventLog.checkpoint != 50 {
+		t.Errorf("All events must be committed: %d, %v", eventLog.checkpoint, err)
+	}
+}
+
+// failingSyncFile fails the next sync, like a disk error
+type failingSyncFile struct {
+	segmentFile
+	fail bool
+}
+
+func (f *failingSyncFile) Sync() error {
+	if f.fail {
+		f.fail = false
+		return errors.New("input/output error")
+	}
+	return f.segmentFile.Sync()
+}
+
+func TestFileEventLog_SyncFailure(t *testing.T) {
+	eventLog, err := NewFileEventLog(t.TempDir(), 1<<20)
+	if err != nil {
+		t.Fatalf("Create log: %v", err)
+	}
+	defer eventLog.Close()
+
+	events := trackingEvents(0, 3)
+	if _, err := eventLog.Append(events[0], true); err != nil {
+		t.Fatalf("Append event: %v", err)
+	}
+	eventLog.current = &failingSyncFile{segmentFile: eventLog.current, fail: true}
+	if _, err := eventLog.Append(events[1], true); err == nil {
+		t.Fatalf("Append must fail")
+	}
+	offset, err := eventLog.Append(events[2], true)
+	if err != nil {
+		t.Fatalf("Append event: %v", err)
+	}
+
+	// the rejected event doesn't hold the checkpoint back
+	if err := eventLog.Commit([]uint64{1, offset}); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+	if eventLog.checkpoint != offset || len(eventLog.committed) != 0 {
+		t.Errorf("Wrong checkpoint: %d != %d, %d offsets are kept", eventLog.checkpoint, offset, len(eventLog.committed))
+	}
+}